@@ -0,0 +1,42 @@
+// Command rebuild-snapshots discards all balance snapshots and recomputes
+// them from the full posting history. Run it after correcting postings by
+// hand or when changing the snapshot interval.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"ledger/internal/db"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	interval := flag.Duration("interval", 0, "snapshot period (default BALANCE_SNAPSHOT_INTERVAL or 1h)")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: Error loading .env file: %v", err)
+	}
+
+	if *interval == 0 {
+		d, err := db.SnapshotInterval()
+		if err != nil {
+			log.Fatal(err)
+		}
+		*interval = d
+	}
+
+	database, err := db.InitDB()
+	if err != nil {
+		log.Fatalf("Error connecting to the database: %v", err)
+	}
+	defer database.Close()
+
+	n, err := db.RebuildBalanceSnapshots(database, *interval)
+	if err != nil {
+		log.Fatalf("Error rebuilding balance snapshots: %v", err)
+	}
+	log.Printf("Rebuilt %d balance snapshots every %s", n, *interval)
+}
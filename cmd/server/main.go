@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"ledger/internal/api"
+	"ledger/internal/auth"
 	"ledger/internal/db"
+	"ledger/internal/jobs"
 	"log"
 	"os"
 
@@ -25,7 +28,22 @@ func main() {
 	}
 	defer db.Close()
 
-	server := api.NewServer(db, log.New(os.Stdout, "", log.LstdFlags))
+	tokens, err := auth.LoadTokenService()
+	if err != nil {
+		log.Fatalf("Error loading signing keys: %v", err)
+	}
+
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	server := api.NewServer(db, logger, tokens)
+
+	// Background jobs run next to the HTTP server for as long as it does
+	serverJobs, err := server.Jobs()
+	if err != nil {
+		log.Fatalf("Error configuring jobs: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.NewRunner(logger, serverJobs...).Run(ctx)
 
 	log.Printf("Server starting on :%s", os.Getenv("SERVER_PORT"))
 	if err := server.Start(":" + os.Getenv("SERVER_PORT")); err != nil {
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+
+	"github.com/lib/pq"
+)
+
+// openAccount opens an additional currency account for a user. A user holds
+// at most one account per currency.
+func (s *Server) openAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.OpenAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Currency == "" {
+		http.Error(w, "Currency is required", http.StatusBadRequest)
+		return
+	}
+
+	currency, ok := requestCurrency(req.Currency)
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+
+	accountType := req.Type
+	if accountType == "" {
+		accountType = models.AccountChecking
+	}
+	if !models.IsAccountType(accountType) {
+		http.Error(w, "Account type must be checking or savings", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var accountID int64
+	err = s.db.QueryRow(`
+		INSERT INTO accounts (user_id, currency, account_type)
+		VALUES ($1, $2, $3)
+		RETURNING id`,
+		userID, currency, accountType).Scan(&accountID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			http.Error(w, "Account already exists for this currency", http.StatusConflict)
+			return
+		}
+		s.logger.Printf("Error opening account: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	zero := models.NewMoney(0, currency)
+	json.NewEncoder(w).Encode(newCurrencyBalance(currency, zero, zero, zero))
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"ledger/internal/auth"
+	"ledger/internal/models"
+	"ledger/internal/utils"
+
+	"github.com/lib/pq"
+)
+
+var (
+	errAPIKeyNotFound       = errors.New("API key not found")
+	errAPIKeyAlreadyRevoked = errors.New("API key is already revoked")
+)
+
+// maxAPIKeyAttempts bounds how often createAPIKey draws a new key when the
+// prefix of the last one was taken.
+const maxAPIKeyAttempts = 3
+
+const apiKeyColumns = `
+	id, name, prefix, scopes, created_by, expires_at, last_used_at,
+	revoked_at, revoked_by, created_at`
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var (
+		key       models.APIKey
+		expiresAt sql.NullTime
+		lastUsed  sql.NullTime
+		revokedAt sql.NullTime
+		revokedBy sql.NullInt64
+	)
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedBy,
+		&expiresAt, &lastUsed, &revokedAt, &revokedBy, &key.CreatedAt)
+	if err != nil {
+		return key, err
+	}
+
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	if revokedBy.Valid {
+		key.RevokedBy = &revokedBy.Int64
+	}
+	return key, nil
+}
+
+// createAPIKey creates an API key acting for the admin who asks for it.
+// The key is in the response and nowhere else; only its hash is stored.
+func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adminID, _, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var (
+		key     string
+		created models.APIKey
+	)
+	for attempt := 1; ; attempt++ {
+		var prefix, hash string
+		key, prefix, hash, err = auth.GenerateAPIKey()
+		if err != nil {
+			s.logger.Printf("Error generating API key: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		created, err = scanAPIKey(s.db.QueryRow(`
+			INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+apiKeyColumns,
+			req.Name, prefix, hash, pq.Array(req.Scopes), adminID, req.ExpiresAt))
+		// Prefixes are short enough to collide now and then; draw a new key
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && attempt < maxAPIKeyAttempts {
+			continue
+		}
+		break
+	}
+	if err != nil {
+		s.logger.Printf("Error creating API key: %v", err)
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreatedAPIKey{APIKey: created, Key: key})
+}
+
+// listAPIKeys returns all API keys, newest first, without the keys
+// themselves.
+func (s *Server) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		ORDER BY id DESC`)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// revokeAPIKey stops an API key from working, for good.
+func (s *Server) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := utils.GetIDFromPath(r, "keyID")
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	adminID, _, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var key models.APIKey
+	err = s.inTx(func(tx *sql.Tx) error {
+		var revokedAt sql.NullTime
+		err := tx.QueryRow("SELECT revoked_at FROM api_keys WHERE id = $1 FOR UPDATE", keyID).Scan(&revokedAt)
+		if err == sql.ErrNoRows {
+			return errAPIKeyNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if revokedAt.Valid {
+			return errAPIKeyAlreadyRevoked
+		}
+
+		key, err = scanAPIKey(tx.QueryRow(`
+			UPDATE api_keys
+			SET revoked_at = NOW(), revoked_by = $2
+			WHERE id = $1
+			RETURNING `+apiKeyColumns, keyID, adminID))
+		return err
+	})
+	switch {
+	case errors.Is(err, errAPIKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errAPIKeyAlreadyRevoked):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		s.logger.Printf("Error revoking API key %d: %v", keyID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
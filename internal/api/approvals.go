@@ -0,0 +1,321 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+const defaultApprovalLifetime = 72 * time.Hour
+
+// approvalStillPending ends the error of an approved transfer that could
+// not be made, which leaves its approval to be decided again.
+const approvalStillPending = "; the approval is still pending"
+
+var (
+	errApprovalNotFound = errors.New("approval not found")
+	errSelfApproval     = errors.New("approver must differ from requester")
+	errApprovalExpired  = errors.New("approval has expired")
+)
+
+// approvalStatusError is returned when deciding on an approval that has
+// already been decided.
+type approvalStatusError struct{ status string }
+
+func (e *approvalStatusError) Error() string { return "approval is " + e.status }
+
+// approvalThreshold returns the amount above which a transfer in currency
+// needs approval, and false if transfers in it never do. It is set per
+// currency with TRANSFER_APPROVAL_THRESHOLD_<CURRENCY>, falling back to
+// TRANSFER_APPROVAL_THRESHOLD for all currencies.
+func approvalThreshold(currency string) (models.Money, bool, error) {
+	name := "TRANSFER_APPROVAL_THRESHOLD_" + currency
+	v := os.Getenv(name)
+	if v == "" {
+		name = "TRANSFER_APPROVAL_THRESHOLD"
+		v = os.Getenv(name)
+	}
+	if v == "" {
+		return models.Money{}, false, nil
+	}
+
+	threshold, err := models.ParseMoney(v, currency)
+	if err != nil {
+		return threshold, false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return threshold, true, nil
+}
+
+// needsApproval reports whether a transfer of amount is above the approval
+// threshold of its currency.
+func needsApproval(amount models.Money) (bool, error) {
+	threshold, ok, err := approvalThreshold(amount.Currency)
+	if err != nil || !ok {
+		return false, err
+	}
+	return threshold.LessThan(amount), nil
+}
+
+// approvalLifetime is how long a transfer may wait for approval, set with
+// TRANSFER_APPROVAL_TTL (e.g. "48h").
+func approvalLifetime() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("TRANSFER_APPROVAL_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultApprovalLifetime
+}
+
+const approvalColumns = `
+	id, from_user_id, to_user_id, amount, currency, to_currency, status, requested_by,
+	decided_by, COALESCE(decision_reason, ''), transaction_id, expires_at, created_at, decided_at`
+
+func scanApproval(row rowScanner) (models.TransferApproval, error) {
+	var (
+		a             models.TransferApproval
+		decidedBy     sql.NullInt64
+		transactionID sql.NullInt64
+		decidedAt     sql.NullTime
+	)
+	err := row.Scan(&a.ID, &a.FromUserID, &a.ToUserID, &a.Amount, &a.Currency, &a.ToCurrency, &a.Status,
+		&a.RequestedBy, &decidedBy, &a.DecisionReason, &transactionID, &a.ExpiresAt, &a.CreatedAt, &decidedAt)
+	if err != nil {
+		return a, err
+	}
+
+	a.Amount.Currency = a.Currency
+	if decidedBy.Valid {
+		a.DecidedBy = &decidedBy.Int64
+	}
+	if transactionID.Valid {
+		a.TransactionID = &transactionID.Int64
+	}
+	if decidedAt.Valid {
+		a.DecidedAt = &decidedAt.Time
+	}
+	return a, nil
+}
+
+// requestApproval records a transfer that needs approval instead of making
+// it, and answers 202 Accepted with the pending approval.
+func (s *Server) requestApproval(w http.ResponseWriter, req models.TransferRequest, toCurrency string, requestedBy int64) {
+	var approval models.TransferApproval
+	err := s.inTx(func(tx *sql.Tx) error {
+		// Catch missing accounts now rather than at approval
+		if _, err := movableAccountID(tx, req.FromUserID, req.Amount.Currency, true); err != nil {
+			return &partyError{subject: "Sender", currency: req.Amount.Currency, err: err}
+		}
+		if _, err := movableAccountID(tx, req.ToUserID, toCurrency, false); err != nil {
+			return &partyError{subject: "Recipient", currency: toCurrency, err: err}
+		}
+
+		var err error
+		approval, err = insertApproval(tx, req, toCurrency, requestedBy)
+		return err
+	})
+	if err != nil {
+		var pe *partyError
+		if errors.As(err, &pe) {
+			writeAccountError(w, pe.err, pe.subject, pe.currency)
+			return
+		}
+		s.logger.Printf("Error requesting transfer approval: %v", err)
+		http.Error(w, "Failed to request approval", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(approval)
+}
+
+// insertApproval records a pending approval for a transfer.
+func insertApproval(tx *sql.Tx, req models.TransferRequest, toCurrency string, requestedBy int64) (models.TransferApproval, error) {
+	return scanApproval(tx.QueryRow(`
+		INSERT INTO transfer_approvals (
+			from_user_id, to_user_id, amount, currency, to_currency, requested_by, expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+approvalColumns,
+		req.FromUserID, req.ToUserID, req.Amount, req.Amount.Currency, toCurrency,
+		requestedBy, time.Now().Add(approvalLifetime())))
+}
+
+// listApprovals returns transfer approvals with the status given by the
+// status query parameter, pending by default, oldest first.
+func (s *Server) listApprovals(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.ApprovalPending
+	case models.ApprovalPending, models.ApprovalApproved, models.ApprovalRejected, models.ApprovalExpired:
+	default:
+		http.Error(w, "status must be pending, approved, rejected or expired", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+approvalColumns+`
+		FROM transfer_approvals
+		WHERE status = $1
+		ORDER BY created_at, id`, status)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	approvals := []models.TransferApproval{}
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		approvals = append(approvals, a)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approvals)
+}
+
+func (s *Server) approveTransfer(w http.ResponseWriter, r *http.Request) {
+	s.decideApproval(w, r, models.ApprovalApproved)
+}
+
+func (s *Server) rejectTransfer(w http.ResponseWriter, r *http.Request) {
+	s.decideApproval(w, r, models.ApprovalRejected)
+}
+
+// decideApproval approves or rejects the pending transfer in the URL. An
+// approved transfer is made there and then; if it fails, for example for
+// lack of funds, the approval stays pending and the error says so.
+func (s *Server) decideApproval(w http.ResponseWriter, r *http.Request, decision string) {
+	approvalID, err := utils.GetIDFromPath(r, "approvalID")
+	if err != nil {
+		http.Error(w, "Invalid approval ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ApprovalDecisionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	deciderID, _, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var approval models.TransferApproval
+	err = s.inTx(func(tx *sql.Tx) error {
+		var err error
+		approval, err = scanApproval(tx.QueryRow(`
+			SELECT `+approvalColumns+`
+			FROM transfer_approvals
+			WHERE id = $1
+			FOR UPDATE`, approvalID))
+		if err == sql.ErrNoRows {
+			return errApprovalNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if approval.Status != models.ApprovalPending {
+			return &approvalStatusError{status: approval.Status}
+		}
+		if !approval.ExpiresAt.After(time.Now()) {
+			return errApprovalExpired
+		}
+		if approval.RequestedBy == deciderID {
+			return errSelfApproval
+		}
+
+		var transactionID *int64
+		if decision == models.ApprovalApproved {
+			result, err := transferTx(tx, approval.FromUserID, approval.ToUserID, approval.Amount, approval.ToCurrency, nil)
+			if err != nil {
+				return err
+			}
+			transactionID = &result.TransactionID
+		}
+
+		approval, err = scanApproval(tx.QueryRow(`
+			UPDATE transfer_approvals
+			SET status = $2, decided_by = $3, decision_reason = NULLIF($4, ''),
+				transaction_id = $5, decided_at = NOW()
+			WHERE id = $1
+			RETURNING `+approvalColumns,
+			approval.ID, decision, deciderID, req.Reason, transactionID))
+		return err
+	})
+
+	var (
+		statusErr *approvalStatusError
+		le        *limitError
+	)
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(approval)
+	case err == errApprovalNotFound:
+		http.Error(w, "Approval not found", http.StatusNotFound)
+	case err == errSelfApproval:
+		http.Error(w, "Transfers must be approved by an admin other than the requester", http.StatusForbidden)
+	case err == errApprovalExpired:
+		http.Error(w, "Approval has expired", http.StatusConflict)
+	case errors.As(err, &statusErr):
+		http.Error(w, "Approval is already "+statusErr.status, http.StatusConflict)
+	case errors.As(err, &le):
+		pending := *le
+		pending.Message += approvalStillPending
+		writeLimitError(w, &pending)
+	default:
+		status, message := transferErrorStatus(err, approval.Currency, approval.ToCurrency)
+		if status == http.StatusInternalServerError {
+			s.logger.Printf("Error deciding approval %d: %v", approvalID, err)
+			http.Error(w, message, status)
+			return
+		}
+		http.Error(w, message+approvalStillPending, status)
+	}
+}
+
+// expireApprovals marks pending approvals past their expiry as expired.
+func (s *Server) expireApprovals() (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE transfer_approvals
+		SET status = 'expired', decided_at = NOW()
+		WHERE status = 'pending' AND expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Server) expireApprovalsJob(ctx context.Context) error {
+	n, err := s.expireApprovals()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.logger.Printf("Expired %d transfer approvals", n)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// requestTestApproval asks for a transfer above the threshold as the admin
+// and returns the approval it waits for.
+func (e *testEnv) requestTestApproval(fromUserID, toUserID int64, amount string) models.TransferApproval {
+	e.t.Helper()
+	var approval models.TransferApproval
+	body := fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":%q}`, fromUserID, toUserID, amount)
+	e.expect(e.do("POST", "/api/transfer", e.adminToken, body), http.StatusAccepted, &approval)
+	if approval.Status != models.ApprovalPending {
+		e.t.Fatalf("Expected a pending approval, got %s", approval.Status)
+	}
+	return approval
+}
+
+// approvals lists the approvals with status.
+func (e *testEnv) approvals(status string) []models.TransferApproval {
+	e.t.Helper()
+	var approvals []models.TransferApproval
+	e.expect(e.do("GET", "/api/approvals?status="+status, e.adminToken, ""), http.StatusOK, &approvals)
+	return approvals
+}
+
+func hasApproval(approvals []models.TransferApproval, id int64) bool {
+	for _, a := range approvals {
+		if a.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTransferApprovals(t *testing.T) {
+	e := newTestEnv(t)
+	t.Setenv("TRANSFER_APPROVAL_THRESHOLD", "100.00")
+
+	_, approverToken := e.newUser(models.RoleAdmin)
+	from, _ := e.newUser(models.RoleUser)
+	to, _ := e.newUser(models.RoleUser)
+	e.credit(from, "500.00", "USD")
+
+	decide := func(approvalID int64, decision string) string {
+		return fmt.Sprintf("/api/approvals/%d/%s", approvalID, decision)
+	}
+
+	t.Run("Four Eyes", func(t *testing.T) {
+		approval := e.requestTestApproval(from, to, "200.00")
+		e.expectBalance(from, "USD", "500.00", "500.00")
+
+		rr := e.do("POST", decide(approval.ID, "approve"), e.adminToken, "")
+		e.expect(rr, http.StatusForbidden, nil)
+
+		var approved models.TransferApproval
+		e.expect(e.do("POST", decide(approval.ID, "approve"), approverToken, ""), http.StatusOK, &approved)
+		if approved.Status != models.ApprovalApproved || approved.TransactionID == nil {
+			t.Errorf("Expected an approved transfer with a transaction, got %+v", approved)
+		}
+		e.expectBalance(from, "USD", "300.00", "300.00")
+		e.expectBalance(to, "USD", "200.00", "200.00")
+
+		rr = e.do("POST", decide(approval.ID, "approve"), approverToken, "")
+		e.expect(rr, http.StatusConflict, nil)
+	})
+
+	t.Run("Failed Transfer", func(t *testing.T) {
+		approval := e.requestTestApproval(from, to, "400.00")
+
+		rr := e.do("POST", decide(approval.ID, "approve"), approverToken, "")
+		body := rr.Body.String()
+		e.expect(rr, http.StatusBadRequest, nil)
+		if !strings.Contains(body, "still pending") {
+			t.Errorf("Expected the error to say the approval is still pending, got %q", body)
+		}
+		if !hasApproval(e.approvals(models.ApprovalPending), approval.ID) {
+			t.Errorf("Expected approval %d to still be pending", approval.ID)
+		}
+		e.expectBalance(from, "USD", "300.00", "300.00")
+
+		// It can be approved once the funds are there
+		e.credit(from, "100.00", "USD")
+		e.expect(e.do("POST", decide(approval.ID, "approve"), approverToken, ""), http.StatusOK, nil)
+		e.expectBalance(from, "USD", "0.00", "0.00")
+	})
+
+	t.Run("Rejection", func(t *testing.T) {
+		e.credit(from, "200.00", "USD")
+		approval := e.requestTestApproval(from, to, "150.00")
+
+		var rejected models.TransferApproval
+		rr := e.do("POST", decide(approval.ID, "reject"), approverToken, `{"reason":"unexpected payee"}`)
+		e.expect(rr, http.StatusOK, &rejected)
+		if rejected.Status != models.ApprovalRejected || rejected.DecisionReason != "unexpected payee" || rejected.TransactionID != nil {
+			t.Errorf("Expected a rejection with its reason and no transaction, got %+v", rejected)
+		}
+		e.expectBalance(from, "USD", "200.00", "200.00")
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		approval := e.requestTestApproval(from, to, "150.00")
+		if _, err := e.db.Exec("UPDATE transfer_approvals SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1", approval.ID); err != nil {
+			t.Fatalf("Failed to expire approval: %v", err)
+		}
+
+		// An approval past its expiry cannot be approved even before the job runs
+		rr := e.do("POST", decide(approval.ID, "approve"), approverToken, "")
+		e.expect(rr, http.StatusConflict, nil)
+		e.expectBalance(from, "USD", "200.00", "200.00")
+
+		n, err := e.server.expireApprovals()
+		if err != nil || n != 1 {
+			t.Fatalf("expireApprovals = %d, %v; want 1", n, err)
+		}
+		if !hasApproval(e.approvals(models.ApprovalExpired), approval.ID) {
+			t.Errorf("Expected approval %d to be expired", approval.ID)
+		}
+	})
+}
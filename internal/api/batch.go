@@ -0,0 +1,199 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ledger/internal/models"
+)
+
+const maxBatchLegs = 1000
+
+// errBatchRejected rolls back a batch in which some leg failed.
+var errBatchRejected = errors.New("batch rejected")
+
+// batchTransfer makes a list of transfers in one database transaction:
+// either every leg is applied or none is. Each leg is checked against the
+// balances left by the legs before it, and all legs are checked even after
+// one fails so that the response lists every problem at once.
+func (s *Server) batchTransfer(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Transfers) == 0 {
+		http.Error(w, "At least one transfer is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Transfers) > maxBatchLegs {
+		http.Error(w, fmt.Sprintf("A batch can hold at most %d transfers", maxBatchLegs), http.StatusBadRequest)
+		return
+	}
+
+	tokenUserID, isAdmin, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check what can be checked without the database first
+	result := models.BatchTransferResult{Legs: make([]models.BatchLegResult, len(req.Transfers))}
+	legs := make([]batchLeg, 0, len(req.Transfers))
+	for i, t := range req.Transfers {
+		result.Legs[i] = models.BatchLegResult{Index: i, Status: models.LegValid}
+
+		currency, ok := requestCurrency(t.Currency)
+		toCurrency := currency
+		if ok && t.ToCurrency != "" {
+			toCurrency, ok = requestCurrency(t.ToCurrency)
+		}
+		switch {
+		case !ok:
+			failLeg(&result.Legs[i], "Unsupported currency")
+		case !t.Amount.IsPositive():
+			failLeg(&result.Legs[i], "Amount must be positive")
+		case !isAdmin && tokenUserID != t.FromUserID:
+			failLeg(&result.Legs[i], "Unauthorized to transfer from this account")
+		default:
+			t.Amount.Currency = currency
+			approval, err := needsApproval(t.Amount)
+			if err != nil {
+				s.logger.Printf("Error reading approval threshold: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if approval {
+				failLeg(&result.Legs[i], "Transfers above the approval threshold must be made on their own")
+				continue
+			}
+			legs = append(legs, batchLeg{index: i, req: t, toCurrency: toCurrency})
+		}
+	}
+
+	var batchID int64
+	err = s.inTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow("INSERT INTO transfer_batches (created_by) VALUES ($1) RETURNING id", tokenUserID).Scan(&batchID)
+		if err != nil {
+			return err
+		}
+
+		if err = lockBatchAccounts(tx, legs); err != nil {
+			return err
+		}
+
+		// Rejected transfers leave the ledger untouched, so the remaining
+		// legs can still be checked in the same transaction
+		for _, leg := range legs {
+			res := &result.Legs[leg.index]
+			res.Status, res.Error, res.Code, res.Result = models.LegValid, "", "", nil
+
+			t := leg.req
+			transfer, err := transferTx(tx, t.FromUserID, t.ToUserID, t.Amount, leg.toCurrency, &batchID)
+			if err != nil {
+				status, message := transferErrorStatus(err, t.Amount.Currency, leg.toCurrency)
+				if status == http.StatusInternalServerError {
+					return fmt.Errorf("leg %d: %w", leg.index, err)
+				}
+				failLeg(res, message)
+				if le, ok := err.(*limitError); ok {
+					res.Code = le.Code
+				}
+				continue
+			}
+			res.Result = &transfer
+		}
+
+		for _, leg := range result.Legs {
+			if leg.Status == models.LegFailed {
+				return errBatchRejected
+			}
+		}
+		return nil
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case err == errBatchRejected:
+		// Nothing was committed, so no leg keeps its transaction
+		result.Status = models.BatchRejected
+		for i := range result.Legs {
+			result.Legs[i].Result = nil
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(result)
+
+	case err != nil:
+		s.logger.Printf("Error applying batch transfer: %v", err)
+		http.Error(w, "Failed to record batch", http.StatusInternalServerError)
+
+	default:
+		result.Status = models.BatchApplied
+		result.BatchID = &batchID
+		for i := range result.Legs {
+			result.Legs[i].Status = models.LegApplied
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func failLeg(res *models.BatchLegResult, message string) {
+	res.Status = models.LegFailed
+	res.Error = message
+	res.Code = ""
+}
+
+// batchLeg is a transfer of a batch that passed the checks made before the
+// batch touches the database.
+type batchLeg struct {
+	index      int
+	req        models.TransferRequest
+	toCurrency string
+}
+
+// lockBatchAccounts locks the accounts of every leg up front and in one
+// ascending order, so that a batch cannot deadlock with other movements by
+// taking its locks leg by leg. Legs whose accounts do not exist are left to
+// fail in transferTx.
+func lockBatchAccounts(tx *sql.Tx, legs []batchLeg) error {
+	var ids []int64
+	for _, leg := range legs {
+		currency := leg.req.Amount.Currency
+		sides := []struct {
+			userID   int64
+			currency string
+		}{{leg.req.FromUserID, currency}, {leg.req.ToUserID, leg.toCurrency}}
+		for _, side := range sides {
+			id, err := userAccountID(tx, side.userID, side.currency)
+			if err == errUserNotFound || err == errCurrencyMismatch {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		// The sender's fees, if any, go to the fees account
+		id, err := systemAccountID(tx, feesAccount, currency)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+
+		if currency != leg.toCurrency {
+			for _, c := range []string{currency, leg.toCurrency} {
+				id, err := systemAccountID(tx, fxConversionAccount, c)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, id)
+			}
+		}
+	}
+	return lockAccounts(tx, ids...)
+}
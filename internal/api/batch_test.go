@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// batch sends a batch of the given legs as the admin.
+func (e *testEnv) batch(status int, legs ...string) models.BatchTransferResult {
+	e.t.Helper()
+	var result models.BatchTransferResult
+	body := `{"transfers":[` + strings.Join(legs, ",") + `]}`
+	e.expect(e.do("POST", "/api/transfers/batch", e.adminToken, body), status, &result)
+	return result
+}
+
+// legJSON is a batch leg moving amount USD.
+func legJSON(fromUserID, toUserID int64, amount string) string {
+	return fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":%q}`, fromUserID, toUserID, amount)
+}
+
+func TestBatchTransfer(t *testing.T) {
+	e := newTestEnv(t)
+	a, _ := e.newUser(models.RoleUser)
+	b, _ := e.newUser(models.RoleUser)
+	c, _ := e.newUser(models.RoleUser)
+	e.credit(a, "50.00", "USD")
+
+	t.Run("One Leg Fails", func(t *testing.T) {
+		// The second leg spends money the first brings in; the third asks
+		// for more than the first leaves
+		result := e.batch(http.StatusUnprocessableEntity,
+			legJSON(a, b, "30.00"),
+			legJSON(b, c, "10.00"),
+			legJSON(a, c, "30.00"),
+		)
+		if result.Status != models.BatchRejected || result.BatchID != nil {
+			t.Errorf("Expected a rejected batch without an ID, got %s %v", result.Status, result.BatchID)
+		}
+		want := []string{models.LegValid, models.LegValid, models.LegFailed}
+		for i, leg := range result.Legs {
+			if leg.Status != want[i] || leg.Result != nil {
+				t.Errorf("Leg %d: got %s with result %v, want %s without one", i, leg.Status, leg.Result, want[i])
+			}
+		}
+		if len(result.Legs) != 3 || result.Legs[2].Error == "" {
+			t.Errorf("Expected the failing leg to say why, got %+v", result.Legs)
+		}
+
+		// No leg was posted
+		e.expectBalance(a, "USD", "50.00", "50.00")
+		e.expectBalance(b, "USD", "0.00", "0.00")
+		e.expectBalance(c, "USD", "0.00", "0.00")
+
+		var n int
+		if err := e.db.QueryRow("SELECT COUNT(*) FROM transactions WHERE batch_id IS NOT NULL").Scan(&n); err != nil {
+			t.Fatalf("Failed to count batch transactions: %v", err)
+		}
+		if n != 0 {
+			t.Errorf("Expected no batch transactions, found %d", n)
+		}
+	})
+
+	t.Run("Applied", func(t *testing.T) {
+		result := e.batch(http.StatusCreated,
+			legJSON(a, b, "30.00"),
+			legJSON(b, c, "10.00"),
+		)
+		if result.Status != models.BatchApplied || result.BatchID == nil {
+			t.Fatalf("Expected an applied batch with an ID, got %s %v", result.Status, result.BatchID)
+		}
+		for i, leg := range result.Legs {
+			if leg.Status != models.LegApplied || leg.Result == nil {
+				t.Errorf("Leg %d: got %s with result %v, want applied with one", i, leg.Status, leg.Result)
+			}
+		}
+		e.expectBalance(a, "USD", "20.00", "20.00")
+		e.expectBalance(b, "USD", "20.00", "20.00")
+		e.expectBalance(c, "USD", "10.00", "10.00")
+	})
+}
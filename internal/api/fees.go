@@ -0,0 +1,350 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// quoteFees returns the fees charged on an operation moving amount and
+// their total, in amount's currency. Schedules that come to zero are left
+// out.
+func quoteFees(q queryer, operation string, amount models.Money) ([]models.FeeCharge, models.Money, error) {
+	fees := []models.FeeCharge{}
+	total := models.NewMoney(0, amount.Currency)
+
+	rows, err := q.Query(`
+		SELECT `+feeScheduleColumns+`
+		FROM fee_schedules
+		WHERE operation = $1 AND currency = $2 AND active
+		ORDER BY id`, operation, amount.Currency)
+	if err != nil {
+		return fees, total, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		schedule, err := scanFeeSchedule(rows)
+		if err != nil {
+			return fees, total, err
+		}
+		fee := schedule.Charge(amount)
+		if !fee.IsPositive() {
+			continue
+		}
+		fees = append(fees, models.FeeCharge{ScheduleID: schedule.ID, Name: schedule.Name, Amount: fee})
+		total = total.Add(fee)
+	}
+	return fees, total, rows.Err()
+}
+
+// feePostings moves each fee from the paying account to the fees account as
+// a leg of its own.
+func feePostings(tx *sql.Tx, payerAccountID int64, fees []models.FeeCharge) ([]posting, error) {
+	if len(fees) == 0 {
+		return nil, nil
+	}
+
+	feesAccountID, err := systemAccountID(tx, feesAccount, fees[0].Amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var postings []posting
+	for _, fee := range fees {
+		postings = append(postings,
+			posting{accountID: payerAccountID, amount: fee.Amount.Neg()},
+			posting{accountID: feesAccountID, amount: fee.Amount},
+		)
+	}
+	return postings, nil
+}
+
+// previewFee returns the fees an operation would be charged without moving
+// any money.
+//
+// Query parameters:
+//
+//	operation  transfer or withdrawal
+//	amount     the amount to be moved
+//	currency   the currency it is moved in, USD by default
+func (s *Server) previewFee(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	operation := q.Get("operation")
+	if operation != models.FeeTransfer && operation != models.FeeWithdrawal {
+		http.Error(w, "operation must be transfer or withdrawal", http.StatusBadRequest)
+		return
+	}
+
+	currency, ok := requestCurrency(q.Get("currency"))
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+
+	amount, err := models.ParseMoney(q.Get("amount"), currency)
+	if err != nil || !amount.IsPositive() {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	fees, total, err := quoteFees(s.db, operation, amount)
+	if err != nil {
+		s.logger.Printf("Error quoting fees: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.FeeQuote{
+		Operation: operation,
+		Amount:    amount,
+		Currency:  currency,
+		Fees:      fees,
+		TotalFee:  total,
+		Total:     amount.Add(total),
+	})
+}
+
+const feeScheduleColumns = `
+	id, name, operation, currency, kind, flat_amount, percent, tiers,
+	min_fee, max_fee, active, created_at, updated_at`
+
+func scanFeeSchedule(row rowScanner) (models.FeeSchedule, error) {
+	var (
+		f       models.FeeSchedule
+		flat    sql.NullString
+		percent sql.NullString
+		tiers   []byte
+		minFee  sql.NullString
+		maxFee  sql.NullString
+	)
+	err := row.Scan(&f.ID, &f.Name, &f.Operation, &f.Currency, &f.Kind, &flat, &percent, &tiers,
+		&minFee, &maxFee, &f.Active, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return f, err
+	}
+
+	for _, m := range []struct {
+		src sql.NullString
+		dst **models.Money
+	}{{flat, &f.Flat}, {minFee, &f.MinFee}, {maxFee, &f.MaxFee}} {
+		if m.src.Valid {
+			amount, err := models.ParseMoney(m.src.String, f.Currency)
+			if err != nil {
+				return f, err
+			}
+			*m.dst = &amount
+		}
+	}
+	if percent.Valid {
+		f.Percent = percent.String
+	}
+	if tiers != nil {
+		if err := json.Unmarshal(tiers, &f.Tiers); err != nil {
+			return f, err
+		}
+		for i := range f.Tiers {
+			f.Tiers[i].Flat.Currency = f.Currency
+			if f.Tiers[i].UpTo != nil {
+				f.Tiers[i].UpTo.Currency = f.Currency
+			}
+		}
+	}
+	return f, nil
+}
+
+// decodeFeeSchedule reads and validates a fee schedule from the request
+// body. It writes the error response and returns false if it is invalid.
+func decodeFeeSchedule(w http.ResponseWriter, r *http.Request) (models.FeeScheduleRequest, bool) {
+	var req models.FeeScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return req, false
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// feeScheduleArgs returns the column values of a fee schedule request in
+// the order name, operation, currency, kind, flat_amount, percent, tiers,
+// min_fee, max_fee, active.
+func feeScheduleArgs(req models.FeeScheduleRequest) ([]interface{}, error) {
+	var tiers sql.NullString
+	if len(req.Tiers) > 0 {
+		data, err := json.Marshal(req.Tiers)
+		if err != nil {
+			return nil, err
+		}
+		tiers = nullString(string(data))
+	}
+	active := req.Active == nil || *req.Active
+	return []interface{}{
+		req.Name, req.Operation, req.Currency, req.Kind, req.Flat, nullString(req.Percent), tiers,
+		req.MinFee, req.MaxFee, active,
+	}, nil
+}
+
+func (s *Server) createFeeSchedule(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeFeeSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	args, err := feeScheduleArgs(req)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var createdBy *int64
+	if id, _, err := actingUser(r); err == nil {
+		createdBy = &id
+	}
+
+	schedule, err := scanFeeSchedule(s.db.QueryRow(`
+		INSERT INTO fee_schedules (
+			name, operation, currency, kind, flat_amount, percent, tiers,
+			min_fee, max_fee, active, created_by
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+feeScheduleColumns,
+		append(args, createdBy)...))
+	if err != nil {
+		s.logger.Printf("Error creating fee schedule: %v", err)
+		http.Error(w, "Failed to create fee schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// listFeeSchedules returns all fee schedules, or those of one operation
+// when the operation query parameter is given.
+func (s *Server) listFeeSchedules(w http.ResponseWriter, r *http.Request) {
+	operation := r.URL.Query().Get("operation")
+
+	rows, err := s.db.Query(`
+		SELECT `+feeScheduleColumns+`
+		FROM fee_schedules
+		WHERE ($1 = '' OR operation = $1)
+		ORDER BY id`, operation)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	schedules := []models.FeeSchedule{}
+	for rows.Next() {
+		schedule, err := scanFeeSchedule(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+func (s *Server) getFeeSchedule(w http.ResponseWriter, r *http.Request) {
+	feeID, err := utils.GetIDFromPath(r, "feeID")
+	if err != nil {
+		http.Error(w, "Invalid fee schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := scanFeeSchedule(s.db.QueryRow(`SELECT `+feeScheduleColumns+` FROM fee_schedules WHERE id = $1`, feeID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Fee schedule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// updateFeeSchedule replaces a fee schedule. Fees already charged are not
+// affected.
+func (s *Server) updateFeeSchedule(w http.ResponseWriter, r *http.Request) {
+	feeID, err := utils.GetIDFromPath(r, "feeID")
+	if err != nil {
+		http.Error(w, "Invalid fee schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	req, ok := decodeFeeSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	args, err := feeScheduleArgs(req)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := scanFeeSchedule(s.db.QueryRow(`
+		UPDATE fee_schedules
+		SET name = $2, operation = $3, currency = $4, kind = $5, flat_amount = $6, percent = $7,
+			tiers = $8, min_fee = $9, max_fee = $10, active = $11, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+feeScheduleColumns,
+		append([]interface{}{feeID}, args...)...))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Fee schedule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("Error updating fee schedule: %v", err)
+		http.Error(w, "Failed to update fee schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func (s *Server) deleteFeeSchedule(w http.ResponseWriter, r *http.Request) {
+	feeID, err := utils.GetIDFromPath(r, "feeID")
+	if err != nil {
+		http.Error(w, "Invalid fee schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM fee_schedules WHERE id = $1", feeID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Fee schedule not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"ledger/internal/models"
+)
+
+// fxConversionAccount is the system account that sits between the two legs
+// of a cross-currency transfer, one account per currency.
+const fxConversionAccount = "fx_conversion"
+
+var errNoFXRate = errors.New("no FX rate for currency pair")
+
+// latestRate returns the FX rate currently in force for base to quote, both
+// parsed and as the decimal string it is stored as.
+func latestRate(tx *sql.Tx, base, quote string) (*big.Rat, string, error) {
+	var stored string
+	err := tx.QueryRow(`
+		SELECT rate
+		FROM fx_rates
+		WHERE base_currency = $1 AND quote_currency = $2
+		ORDER BY id DESC
+		LIMIT 1`,
+		base, quote).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return nil, "", errNoFXRate
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	rate, err := models.ParseRate(stored)
+	if err != nil {
+		return nil, "", err
+	}
+	return rate, stored, nil
+}
+
+// setFXRate publishes a new rate for a currency pair. Earlier rates are kept
+// so that past conversions remain traceable.
+func (s *Server) setFXRate(w http.ResponseWriter, r *http.Request) {
+	var req models.SetFXRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	base := strings.ToUpper(req.Base)
+	quote := strings.ToUpper(req.Quote)
+	if !models.IsSupportedCurrency(base) || !models.IsSupportedCurrency(quote) {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	if base == quote {
+		http.Error(w, "Base and quote currencies must differ", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := models.ParseRate(req.Rate); err != nil {
+		http.Error(w, "Invalid rate", http.StatusBadRequest)
+		return
+	}
+
+	var createdBy *int64
+	if id, _, err := actingUser(r); err == nil {
+		createdBy = &id
+	}
+
+	rate := models.FXRate{Base: base, Quote: quote, CreatedBy: createdBy}
+	err := s.db.QueryRow(`
+		INSERT INTO fx_rates (base_currency, quote_currency, rate, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING rate, created_at`,
+		base, quote, req.Rate, createdBy).Scan(&rate.Rate, &rate.CreatedAt)
+	if err != nil {
+		s.logger.Printf("Error storing FX rate: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rate)
+}
+
+// listFXRates returns the rate currently in force for every pair.
+func (s *Server) listFXRates(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT ON (base_currency, quote_currency)
+			base_currency, quote_currency, rate, created_by, created_at
+		FROM fx_rates
+		ORDER BY base_currency, quote_currency, id DESC`)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rates := []models.FXRate{}
+	for rows.Next() {
+		var (
+			rate      models.FXRate
+			createdBy sql.NullInt64
+		)
+		if err := rows.Scan(&rate.Base, &rate.Quote, &rate.Rate, &createdBy, &rate.CreatedAt); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if createdBy.Valid {
+			rate.CreatedBy = &createdBy.Int64
+		}
+		rates = append(rates, rate)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// historySorts maps the sort parameter to the column rows are ordered by.
+// Amounts are compared by size, since withdrawals are stored negative.
+var historySorts = map[string]string{
+	"created_at": "t.created_at",
+	"amount":     "ABS(t.amount)",
+}
+
+// historyCursor marks the last row of a page. It records the user, filters
+// and sort it was issued for, so a cursor cannot be replayed against a
+// different history or ordering.
+type historyCursor struct {
+	User    int64  `json:"u"`
+	Filters string `json:"f"`
+	Sort    string `json:"s"`
+	Order   string `json:"o"`
+	Value   string `json:"v"`
+	ID      int64  `json:"id"`
+}
+
+func (c historyCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeHistoryCursor(s string) (historyCursor, error) {
+	var c historyCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// historyQuery is the parsed query string of a transaction history request.
+type historyQuery struct {
+	types        []string
+	from, to     *time.Time
+	minAmount    *models.Money
+	maxAmount    *models.Money
+	counterparty *int64
+	sort         string
+	order        string
+	limit        int
+	cursor       *historyCursor
+}
+
+// filterKey fingerprints the filters of the query, in a form that does not
+// depend on how they were written.
+func (hq historyQuery) filterKey() string {
+	types := append([]string(nil), hq.types...)
+	sort.Strings(types)
+
+	parts := []string{strings.Join(types, ",")}
+	for _, t := range []*time.Time{hq.from, hq.to} {
+		var s string
+		if t != nil {
+			s = t.UTC().Format(time.RFC3339Nano)
+		}
+		parts = append(parts, s)
+	}
+	for _, m := range []*models.Money{hq.minAmount, hq.maxAmount} {
+		var s string
+		if m != nil {
+			s = m.String()
+		}
+		parts = append(parts, s)
+	}
+	var cp string
+	if hq.counterparty != nil {
+		cp = strconv.FormatInt(*hq.counterparty, 10)
+	}
+	parts = append(parts, cp)
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseHistoryQuery parses the query string of a request for userID's
+// history.
+func parseHistoryQuery(userID int64, q url.Values) (historyQuery, error) {
+	hq := historyQuery{
+		sort:  "created_at",
+		order: "desc",
+		limit: defaultHistoryLimit,
+	}
+
+	if v := q.Get("type"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			if !isTransactionType(t) {
+				return hq, fmt.Errorf("unknown transaction type %q", t)
+			}
+			hq.types = append(hq.types, t)
+		}
+	}
+
+	for name, dst := range map[string]**time.Time{"from": &hq.from, "to": &hq.to} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return hq, fmt.Errorf("invalid %s timestamp, use RFC3339", name)
+			}
+			*dst = &t
+		}
+	}
+
+	for name, dst := range map[string]**models.Money{"min_amount": &hq.minAmount, "max_amount": &hq.maxAmount} {
+		if v := q.Get(name); v != "" {
+			m, err := models.ParseMoney(v, "")
+			if err != nil {
+				return hq, fmt.Errorf("invalid %s", name)
+			}
+			*dst = &m
+		}
+	}
+
+	if v := q.Get("counterparty"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return hq, errors.New("invalid counterparty")
+		}
+		hq.counterparty = &id
+	}
+
+	if v := q.Get("sort"); v != "" {
+		if _, ok := historySorts[v]; !ok {
+			return hq, errors.New("sort must be created_at or amount")
+		}
+		hq.sort = v
+	}
+
+	if v := q.Get("order"); v != "" {
+		if v != "asc" && v != "desc" {
+			return hq, errors.New("order must be asc or desc")
+		}
+		hq.order = v
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			return hq, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+		}
+		hq.limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeHistoryCursor(v)
+		if err != nil || c.User != userID || c.Filters != hq.filterKey() || c.Sort != hq.sort || c.Order != hq.order {
+			return hq, errors.New("invalid cursor")
+		}
+		hq.cursor = &c
+	}
+
+	return hq, nil
+}
+
+func isTransactionType(t string) bool {
+	for _, known := range models.TransactionTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// getTransactions returns a page of the transactions a user took part in.
+//
+// Query parameters:
+//
+//	type          comma-separated transaction types
+//	from, to      RFC3339 bounds on created_at, from inclusive, to exclusive
+//	min_amount    lower bound on the transaction amount, inclusive
+//	max_amount    upper bound on the transaction amount, inclusive
+//	counterparty  only transfers with this other user
+//	sort, order   created_at (default) or amount; desc (default) or asc
+//	limit         page size, 1 to 200, default 50
+//	cursor        next_cursor of the previous page
+func (s *Server) getTransactions(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	hq, err := parseHistoryQuery(userID, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	user := arg(userID)
+	where := []string{fmt.Sprintf("(t.from_user_id = %s OR t.to_user_id = %s)", user, user)}
+
+	if len(hq.types) > 0 {
+		placeholders := make([]string, len(hq.types))
+		for i, t := range hq.types {
+			placeholders[i] = arg(t)
+		}
+		where = append(where, "t.type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if hq.from != nil {
+		where = append(where, "t.created_at >= "+arg(*hq.from))
+	}
+	if hq.to != nil {
+		where = append(where, "t.created_at < "+arg(*hq.to))
+	}
+	if hq.minAmount != nil {
+		where = append(where, "ABS(t.amount) >= "+arg(*hq.minAmount))
+	}
+	if hq.maxAmount != nil {
+		where = append(where, "ABS(t.amount) <= "+arg(*hq.maxAmount))
+	}
+	if hq.counterparty != nil {
+		cp := arg(*hq.counterparty)
+		where = append(where, fmt.Sprintf(
+			"((t.from_user_id = %s AND t.to_user_id = %s) OR (t.to_user_id = %s AND t.from_user_id = %s))",
+			user, cp, user, cp))
+	}
+
+	sortColumn := historySorts[hq.sort]
+	direction, comparison := "DESC", "<"
+	if hq.order == "asc" {
+		direction, comparison = "ASC", ">"
+	}
+	if hq.cursor != nil {
+		where = append(where, fmt.Sprintf("(%s, t.id) %s (%s, %s)",
+			sortColumn, comparison, arg(hq.cursor.Value), arg(hq.cursor.ID)))
+	}
+
+	// Fetch one row more than the page size to learn whether there is a
+	// next page.
+	query := `
+		SELECT t.id, t.from_user_id, t.to_user_id, t.type, t.amount, t.currency,
+			t.target_amount, t.target_currency, t.fx_rate, t.original_transaction_id, t.batch_id,
+			COALESCE(je.description, ''), t.created_at, ba.currency, ba.balance_after
+		FROM transactions t
+		LEFT JOIN journal_entries je ON je.transaction_id = t.id
+		LEFT JOIN LATERAL (
+			SELECT a.currency, p.balance_after
+			FROM postings p
+			JOIN accounts a ON a.id = p.account_id
+			WHERE p.entry_id = je.id AND a.user_id = ` + user + `
+			ORDER BY p.id DESC
+			LIMIT 1
+		) ba ON true
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY ` + sortColumn + ` ` + direction + `, t.id ` + direction + `
+		LIMIT ` + arg(hq.limit+1)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		s.logger.Printf("Error listing transactions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	page := models.TransactionPage{Transactions: []models.Transaction{}}
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			s.logger.Printf("Error scanning transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		t.UserID = userID
+		page.Transactions = append(page.Transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(page.Transactions) > hq.limit {
+		page.Transactions = page.Transactions[:hq.limit]
+		last := page.Transactions[hq.limit-1]
+
+		value := last.CreatedAt.Format(time.RFC3339Nano)
+		if hq.sort == "amount" {
+			value = last.Amount.String()
+			if last.Amount.IsNegative() {
+				value = last.Amount.Neg().String()
+			}
+		}
+		page.NextCursor = historyCursor{
+			User:    userID,
+			Filters: hq.filterKey(),
+			Sort:    hq.sort,
+			Order:   hq.order,
+			Value:   value,
+			ID:      last.ID,
+		}.encode()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// scanTransaction reads a row selected with the columns used by
+// getTransactions.
+func scanTransaction(row rowScanner) (models.Transaction, error) {
+	var (
+		t               models.Transaction
+		fromUserID      sql.NullInt64
+		targetAmount    sql.NullString
+		targetCurrency  sql.NullString
+		fxRate          sql.NullString
+		originalID      sql.NullInt64
+		batchID         sql.NullInt64
+		balanceCurrency sql.NullString
+		balanceAfter    sql.NullString
+	)
+	err := row.Scan(&t.ID, &fromUserID, &t.ToUserID, &t.Type, &t.Amount, &t.Currency,
+		&targetAmount, &targetCurrency, &fxRate, &originalID, &batchID, &t.Description, &t.CreatedAt,
+		&balanceCurrency, &balanceAfter)
+	if err != nil {
+		return t, err
+	}
+
+	if balanceCurrency.Valid && balanceAfter.Valid {
+		balance, err := models.ParseMoney(balanceAfter.String, balanceCurrency.String)
+		if err != nil {
+			return t, err
+		}
+		t.BalanceAfter = &balance
+	}
+
+	t.Amount.Currency = t.Currency
+	if fromUserID.Valid {
+		t.FromUserID = &fromUserID.Int64
+	}
+	if targetAmount.Valid && targetCurrency.Valid {
+		amount, err := models.ParseMoney(targetAmount.String, targetCurrency.String)
+		if err != nil {
+			return t, err
+		}
+		t.TargetAmount = &amount
+		t.TargetCurrency = targetCurrency.String
+	}
+	if fxRate.Valid {
+		t.FXRate = &fxRate.String
+	}
+	if originalID.Valid {
+		t.OriginalTransactionID = &originalID.Int64
+	}
+	if batchID.Valid {
+		t.BatchID = &batchID.Int64
+	}
+	return t, nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+func TestHistoryCursorBinding(t *testing.T) {
+	issued, err := parseHistoryQuery(7, url.Values{"type": {"transfer,credit"}, "min_amount": {"5"}})
+	if err != nil {
+		t.Fatalf("parseHistoryQuery: %v", err)
+	}
+	cursor := historyCursor{
+		User:    7,
+		Filters: issued.filterKey(),
+		Sort:    issued.sort,
+		Order:   issued.order,
+		Value:   "2024-03-01T12:00:00Z",
+		ID:      42,
+	}.encode()
+
+	tests := []struct {
+		name    string
+		userID  int64
+		query   url.Values
+		wantErr bool
+	}{
+		{"Same Query", 7, url.Values{"type": {"transfer,credit"}, "min_amount": {"5"}}, false},
+		{"Same Filters Written Differently", 7, url.Values{"type": {"credit,transfer"}, "min_amount": {"5.00"}, "limit": {"10"}}, false},
+		{"Other User", 8, url.Values{"type": {"transfer,credit"}, "min_amount": {"5"}}, true},
+		{"Other Filters", 7, url.Values{"type": {"transfer"}, "min_amount": {"5"}}, true},
+		{"Filter Dropped", 7, url.Values{"type": {"transfer,credit"}}, true},
+		{"Other Sort", 7, url.Values{"type": {"transfer,credit"}, "min_amount": {"5"}, "sort": {"amount"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Set("cursor", cursor)
+			_, err := parseHistoryQuery(tt.userID, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseHistoryQuery error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := parseHistoryQuery(7, url.Values{"cursor": {"not-a-cursor"}}); err == nil {
+		t.Error("Expected an error for a malformed cursor")
+	}
+}
+
+// history fetches a page of the user's transactions with the given query.
+func (e *testEnv) history(userID int64, query string, status int) models.TransactionPage {
+	e.t.Helper()
+	var page models.TransactionPage
+	rr := e.do("GET", fmt.Sprintf("/api/users/%d/transactions?%s", userID, query), e.adminToken, "")
+	if status != http.StatusOK {
+		e.expect(rr, status, nil)
+		return page
+	}
+	e.expect(rr, status, &page)
+	return page
+}
+
+func TestTransactionHistory(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+	other, _ := e.newUser(models.RoleUser)
+
+	e.credit(userID, "100.00", "USD")
+	e.transfer(userID, other, "10.00")
+	e.transfer(userID, other, "20.00")
+	e.transfer(userID, other, "30.00")
+	e.expect(e.do("POST", fmt.Sprintf("/api/users/%d/withdraw", userID), e.adminToken, `{"amount":"5.00"}`), http.StatusOK, nil)
+
+	t.Run("Pagination", func(t *testing.T) {
+		var (
+			amounts []string
+			after   []string
+			query   = "limit=2"
+			pages   int
+		)
+		for {
+			page := e.history(userID, query, http.StatusOK)
+			pages++
+			for _, tx := range page.Transactions {
+				amounts = append(amounts, tx.Amount.String())
+				if tx.BalanceAfter == nil {
+					t.Fatalf("Transaction %d has no balance_after", tx.ID)
+				}
+				after = append(after, tx.BalanceAfter.String())
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query = "limit=2&cursor=" + url.QueryEscape(page.NextCursor)
+		}
+
+		wantAmounts := []string{"-5.00", "30.00", "20.00", "10.00", "100.00"}
+		wantAfter := []string{"35.00", "40.00", "70.00", "90.00", "100.00"}
+		if pages != 3 || fmt.Sprint(amounts) != fmt.Sprint(wantAmounts) || fmt.Sprint(after) != fmt.Sprint(wantAfter) {
+			t.Errorf("Got %d pages of %v with balances %v, want 3 of %v with %v", pages, amounts, after, wantAmounts, wantAfter)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		tests := []struct {
+			query string
+			want  []string
+		}{
+			{"type=transfer", []string{"30.00", "20.00", "10.00"}},
+			{"type=credit,withdrawal", []string{"-5.00", "100.00"}},
+			{fmt.Sprintf("counterparty=%d", other), []string{"30.00", "20.00", "10.00"}},
+			{"min_amount=20&max_amount=50", []string{"30.00", "20.00"}},
+			{"type=transfer&sort=amount&order=asc", []string{"10.00", "20.00", "30.00"}},
+			{"sort=amount", []string{"100.00", "30.00", "20.00", "10.00", "-5.00"}},
+		}
+		for _, tt := range tests {
+			var got []string
+			for _, tx := range e.history(userID, tt.query, http.StatusOK).Transactions {
+				got = append(got, tx.Amount.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("Recipient Balance", func(t *testing.T) {
+		page := e.history(other, "order=asc", http.StatusOK)
+		var after []string
+		for _, tx := range page.Transactions {
+			after = append(after, tx.BalanceAfter.String())
+		}
+		if fmt.Sprint(after) != fmt.Sprint([]string{"10.00", "30.00", "60.00"}) {
+			t.Errorf("Got balances %v, want [10.00 30.00 60.00]", after)
+		}
+	})
+
+	t.Run("Cursor Reuse", func(t *testing.T) {
+		page := e.history(userID, "type=transfer&limit=1", http.StatusOK)
+		if page.NextCursor == "" {
+			t.Fatal("Expected a next cursor")
+		}
+		cursor := url.QueryEscape(page.NextCursor)
+
+		e.history(userID, "type=transfer&limit=1&cursor="+cursor, http.StatusOK)
+		e.history(other, "type=transfer&limit=1&cursor="+cursor, http.StatusBadRequest)
+		e.history(userID, "type=credit&limit=1&cursor="+cursor, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,383 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+// defaultHoldLifetime is how long a hold reserves funds when the request does
+// not say otherwise.
+const defaultHoldLifetime = 7 * 24 * time.Hour
+
+const holdColumns = `
+	h.id, a.user_id, a.currency, h.amount, h.captured_amount, h.status,
+	COALESCE(h.description, ''), h.transaction_id, h.expires_at, h.created_at, h.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanHold(row rowScanner) (models.Hold, error) {
+	var (
+		hold          models.Hold
+		captured      sql.NullString
+		transactionID sql.NullInt64
+	)
+	err := row.Scan(&hold.ID, &hold.UserID, &hold.Currency, &hold.Amount, &captured, &hold.Status,
+		&hold.Description, &transactionID, &hold.ExpiresAt, &hold.CreatedAt, &hold.UpdatedAt)
+	if err != nil {
+		return hold, err
+	}
+
+	hold.Amount.Currency = hold.Currency
+	if captured.Valid {
+		amount, err := models.ParseMoney(captured.String, hold.Currency)
+		if err != nil {
+			return hold, err
+		}
+		hold.CapturedAmount = &amount
+	}
+	if transactionID.Valid {
+		hold.TransactionID = &transactionID.Int64
+	}
+	return hold, nil
+}
+
+// createHold reserves funds on one of a user's accounts. The hold lowers the
+// available balance straight away but posts nothing until it is captured.
+func (s *Server) createHold(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	currency, ok := requestCurrency(req.Currency)
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	req.Amount.Currency = currency
+
+	if !req.Amount.IsPositive() {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := time.Now().Add(defaultHoldLifetime)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	accountID, err := movableAccountID(tx, userID, currency, true)
+	if err != nil {
+		writeAccountError(w, err, "User", currency)
+		return
+	}
+
+	if err = lockAccounts(tx, accountID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = ensureFunds(tx, accountID, req.Amount); err != nil {
+		writeFundsError(w, err)
+		return
+	}
+
+	var holdID int64
+	err = tx.QueryRow(`
+		INSERT INTO holds (account_id, amount, description, expires_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		RETURNING id`,
+		accountID, req.Amount, req.Description, expiresAt).Scan(&holdID)
+	if err != nil {
+		s.logger.Printf("Error creating hold: %v", err)
+		http.Error(w, "Failed to create hold", http.StatusInternalServerError)
+		return
+	}
+
+	hold, err := scanHold(tx.QueryRow(`
+		SELECT `+holdColumns+`
+		FROM holds h
+		JOIN accounts a ON a.id = h.account_id
+		WHERE h.id = $1`, holdID))
+	if err != nil {
+		http.Error(w, "Failed to create hold", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+// listHolds returns every hold placed on a user's accounts, newest first.
+func (s *Server) listHolds(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+holdColumns+`
+		FROM holds h
+		JOIN accounts a ON a.id = h.account_id
+		WHERE a.user_id = $1
+		ORDER BY h.id DESC`, userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	holds := []models.Hold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		holds = append(holds, hold)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holds)
+}
+
+// lockPendingHold locks a hold and checks that it can still be captured or
+// voided. On failure it writes the response and returns false.
+func lockPendingHold(w http.ResponseWriter, tx *sql.Tx, holdID int64) (models.Hold, int64, bool) {
+	var (
+		accountID int64
+		status    string
+		expired   bool
+	)
+	err := tx.QueryRow(`
+		SELECT account_id, status, expires_at <= NOW()
+		FROM holds
+		WHERE id = $1
+		FOR UPDATE`, holdID).Scan(&accountID, &status, &expired)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Hold not found", http.StatusNotFound)
+		return models.Hold{}, 0, false
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return models.Hold{}, 0, false
+	}
+
+	if status != models.HoldPending {
+		http.Error(w, "Hold is already "+status, http.StatusConflict)
+		return models.Hold{}, 0, false
+	}
+	if expired {
+		http.Error(w, "Hold has expired", http.StatusConflict)
+		return models.Hold{}, 0, false
+	}
+
+	hold, err := scanHold(tx.QueryRow(`
+		SELECT `+holdColumns+`
+		FROM holds h
+		JOIN accounts a ON a.id = h.account_id
+		WHERE h.id = $1`, holdID))
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return models.Hold{}, 0, false
+	}
+	return hold, accountID, true
+}
+
+// captureHold turns a pending hold into a posted debit, either in full or for
+// part of the held amount. Whatever is not captured is released.
+func (s *Server) captureHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := utils.GetIDFromPath(r, "holdID")
+	if err != nil {
+		http.Error(w, "Invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.CaptureHoldRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	hold, accountID, ok := lockPendingHold(w, tx, holdID)
+	if !ok {
+		return
+	}
+
+	amount := hold.Amount
+	if req.Amount != nil {
+		amount = *req.Amount
+		amount.Currency = hold.Currency
+		if !amount.IsPositive() {
+			http.Error(w, "Amount must be positive", http.StatusBadRequest)
+			return
+		}
+		if hold.Amount.LessThan(amount) {
+			http.Error(w, "Capture exceeds held amount", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if err = checkUserStatus(tx, hold.UserID, true); err != nil {
+		writeAccountError(w, err, "User", hold.Currency)
+		return
+	}
+
+	settlementID, err := systemAccountID(tx, settlementAccount, hold.Currency)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var transactionID int64
+	err = tx.QueryRow(`
+		INSERT INTO transactions (to_user_id, amount, currency, type)
+		VALUES ($1, $2, $3, 'capture')
+		RETURNING id`,
+		hold.UserID, amount.Neg(), hold.Currency).Scan(&transactionID)
+	if err != nil {
+		http.Error(w, "Failed to record transaction", http.StatusInternalServerError)
+		return
+	}
+
+	err = postEntry(tx, transactionID, "Hold capture", []posting{
+		{accountID: accountID, amount: amount.Neg()},
+		{accountID: settlementID, amount: amount},
+	})
+	if err != nil {
+		http.Error(w, "Failed to post journal entry", http.StatusInternalServerError)
+		return
+	}
+
+	hold, err = scanHold(tx.QueryRow(`
+		WITH h AS (
+			UPDATE holds
+			SET status = 'captured', captured_amount = $1, transaction_id = $2, updated_at = NOW()
+			WHERE id = $3
+			RETURNING *
+		)
+		SELECT `+holdColumns+`
+		FROM h
+		JOIN accounts a ON a.id = h.account_id`,
+		amount, transactionID, holdID))
+	if err != nil {
+		http.Error(w, "Failed to update hold", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hold)
+}
+
+// voidHold releases a pending hold without moving any money.
+func (s *Server) voidHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := utils.GetIDFromPath(r, "holdID")
+	if err != nil {
+		http.Error(w, "Invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, _, ok := lockPendingHold(w, tx, holdID); !ok {
+		return
+	}
+
+	hold, err := scanHold(tx.QueryRow(`
+		WITH h AS (
+			UPDATE holds
+			SET status = 'voided', updated_at = NOW()
+			WHERE id = $1
+			RETURNING *
+		)
+		SELECT `+holdColumns+`
+		FROM h
+		JOIN accounts a ON a.id = h.account_id`,
+		holdID))
+	if err != nil {
+		http.Error(w, "Failed to update hold", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hold)
+}
+
+// expireHolds marks pending holds past their expiry as expired. Such holds
+// already stopped counting against the available balance when they expired;
+// this records the fact.
+func (s *Server) expireHolds() (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE holds
+		SET status = 'expired', updated_at = NOW()
+		WHERE status = 'pending' AND expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Server) expireHoldsJob(ctx context.Context) error {
+	n, err := s.expireHolds()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.logger.Printf("Expired %d holds", n)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// createTestHold places a hold of amount USD on the user's account.
+func (e *testEnv) createTestHold(userID int64, amount string) models.Hold {
+	e.t.Helper()
+	var hold models.Hold
+	body := fmt.Sprintf(`{"amount":%q,"description":"card authorization"}`, amount)
+	e.expect(e.do("POST", fmt.Sprintf("/api/users/%d/holds", userID), e.adminToken, body), http.StatusCreated, &hold)
+	return hold
+}
+
+func TestHolds(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+	e.credit(userID, "100.00", "USD")
+
+	hold := e.createTestHold(userID, "30.00")
+	if hold.Status != models.HoldPending || hold.Amount.String() != "30.00" {
+		t.Fatalf("Expected a pending hold of 30.00, got %s %s", hold.Status, hold.Amount)
+	}
+	e.expectBalance(userID, "USD", "100.00", "70.00")
+
+	b := e.balance(userID, "USD")
+	if b.Balance != b.Posted {
+		t.Errorf("Expected balance %s to repeat posted %s", b.Balance, b.Posted)
+	}
+
+	// Holds reserve funds like any debit
+	rr := e.do("POST", fmt.Sprintf("/api/users/%d/holds", userID), e.adminToken, `{"amount":"70.01"}`)
+	e.expect(rr, http.StatusBadRequest, nil)
+
+	t.Run("Full Capture", func(t *testing.T) {
+		var captured models.Hold
+		e.expect(e.do("POST", fmt.Sprintf("/api/holds/%d/capture", hold.ID), e.adminToken, ""), http.StatusOK, &captured)
+		if captured.Status != models.HoldCaptured || captured.CapturedAmount == nil ||
+			captured.CapturedAmount.String() != "30.00" || captured.TransactionID == nil {
+			t.Errorf("Expected a capture of 30.00 with a transaction, got %+v", captured)
+		}
+		e.expectBalance(userID, "USD", "70.00", "70.00")
+
+		rr := e.do("POST", fmt.Sprintf("/api/holds/%d/capture", hold.ID), e.adminToken, "")
+		e.expect(rr, http.StatusConflict, nil)
+	})
+
+	t.Run("Partial Capture", func(t *testing.T) {
+		hold := e.createTestHold(userID, "20.00")
+		e.expectBalance(userID, "USD", "70.00", "50.00")
+
+		rr := e.do("POST", fmt.Sprintf("/api/holds/%d/capture", hold.ID), e.adminToken, `{"amount":"20.01"}`)
+		e.expect(rr, http.StatusUnprocessableEntity, nil)
+
+		var captured models.Hold
+		rr = e.do("POST", fmt.Sprintf("/api/holds/%d/capture", hold.ID), e.adminToken, `{"amount":"5.00"}`)
+		e.expect(rr, http.StatusOK, &captured)
+		if captured.CapturedAmount == nil || captured.CapturedAmount.String() != "5.00" {
+			t.Errorf("Expected 5.00 captured, got %v", captured.CapturedAmount)
+		}
+		// The 15.00 not captured is released
+		e.expectBalance(userID, "USD", "65.00", "65.00")
+	})
+
+	t.Run("Void", func(t *testing.T) {
+		hold := e.createTestHold(userID, "10.00")
+		e.expectBalance(userID, "USD", "65.00", "55.00")
+
+		var voided models.Hold
+		e.expect(e.do("POST", fmt.Sprintf("/api/holds/%d/void", hold.ID), e.adminToken, ""), http.StatusOK, &voided)
+		if voided.Status != models.HoldVoided || voided.TransactionID != nil {
+			t.Errorf("Expected a voided hold without a transaction, got %+v", voided)
+		}
+		e.expectBalance(userID, "USD", "65.00", "65.00")
+
+		rr := e.do("POST", fmt.Sprintf("/api/holds/%d/capture", hold.ID), e.adminToken, "")
+		e.expect(rr, http.StatusConflict, nil)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		hold := e.createTestHold(userID, "10.00")
+		if _, err := e.db.Exec("UPDATE holds SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1", hold.ID); err != nil {
+			t.Fatalf("Failed to expire hold: %v", err)
+		}
+
+		// An expired hold stops reserving funds even before the job runs
+		e.expectBalance(userID, "USD", "65.00", "65.00")
+		rr := e.do("POST", fmt.Sprintf("/api/holds/%d/capture", hold.ID), e.adminToken, "")
+		e.expect(rr, http.StatusConflict, nil)
+
+		n, err := e.server.expireHolds()
+		if err != nil || n != 1 {
+			t.Fatalf("expireHolds = %d, %v; want 1", n, err)
+		}
+
+		var holds []models.Hold
+		e.expect(e.do("GET", fmt.Sprintf("/api/users/%d/holds", userID), e.adminToken, ""), http.StatusOK, &holds)
+		if len(holds) == 0 || holds[0].ID != hold.ID || holds[0].Status != models.HoldExpired {
+			t.Errorf("Expected hold %d to be expired, got %+v", hold.ID, holds)
+		}
+	})
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"ledger/internal/models"
+)
+
+// interestExpenseAccount is the system account interest is paid out of.
+const interestExpenseAccount = "interest_expense"
+
+// setInterestRate sets the rate paid on an account type and currency from
+// a given day on. Earlier rates are kept for the days they applied to.
+func (s *Server) setInterestRate(w http.ResponseWriter, r *http.Request) {
+	var req models.SetInterestRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(time.Now().UTC()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var createdBy *int64
+	if id, _, err := actingUser(r); err == nil {
+		createdBy = &id
+	}
+
+	rate, err := scanInterestRate(s.db.QueryRow(`
+		INSERT INTO interest_rates (account_type, currency, annual_rate, day_count, effective_from, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+interestRateColumns,
+		req.AccountType, req.Currency, req.AnnualRate, req.DayCount, req.EffectiveFrom, createdBy))
+	if err != nil {
+		s.logger.Printf("Error storing interest rate: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rate)
+}
+
+// listInterestRates returns every interest rate set, latest first.
+func (s *Server) listInterestRates(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT ` + interestRateColumns + `
+		FROM interest_rates
+		ORDER BY account_type, currency, effective_from DESC, id DESC`)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rates := []models.InterestRate{}
+	for rows.Next() {
+		rate, err := scanInterestRate(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		rates = append(rates, rate)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
+
+const interestRateColumns = `
+	id, account_type, currency, annual_rate, day_count,
+	to_char(effective_from, 'YYYY-MM-DD'), created_by, created_at`
+
+func scanInterestRate(row rowScanner) (models.InterestRate, error) {
+	var (
+		rate      models.InterestRate
+		createdBy sql.NullInt64
+	)
+	err := row.Scan(&rate.ID, &rate.AccountType, &rate.Currency, &rate.AnnualRate, &rate.DayCount,
+		&rate.EffectiveFrom, &createdBy, &rate.CreatedAt)
+	if createdBy.Valid {
+		rate.CreatedBy = &createdBy.Int64
+	}
+	return rate, err
+}
+
+// accrual is the interest one account earned on one day.
+type accrual struct {
+	accountID  int64
+	balance    models.Money
+	annualRate string
+	dayCount   string
+}
+
+// accrueInterest records the interest every user account with a positive
+// balance at the end of day earned that day, at the rate in force for its
+// account type and currency. A day is accrued at most once; accruing it
+// again returns false.
+func (s *Server) accrueInterest(day time.Time) (bool, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+	date := day.Format("2006-01-02")
+
+	// Claim the day. A concurrent run of the same day waits here and then
+	// finds it taken.
+	res, err := tx.Exec(`
+		INSERT INTO interest_accrual_runs (accrual_date)
+		VALUES ($1)
+		ON CONFLICT (accrual_date) DO NOTHING`, date)
+	if err != nil {
+		return false, 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, 0, nil
+	}
+
+	endOfDay := day.AddDate(0, 0, 1)
+	rows, err := tx.Query(`
+		SELECT a.id, a.currency, `+balanceAtSQL("$1", false)+`, r.annual_rate, r.day_count
+		FROM accounts a
+		JOIN LATERAL (
+			SELECT ir.annual_rate, ir.day_count
+			FROM interest_rates ir
+			WHERE ir.account_type = a.account_type
+			AND ir.currency = a.currency
+			AND ir.effective_from <= $2
+			ORDER BY ir.effective_from DESC, ir.id DESC
+			LIMIT 1
+		) r ON true
+		WHERE a.user_id IS NOT NULL
+		AND a.created_at < $1
+		AND r.annual_rate > 0`,
+		endOfDay, date)
+	if err != nil {
+		return false, 0, err
+	}
+
+	var accruals []accrual
+	for rows.Next() {
+		var a accrual
+		if err := rows.Scan(&a.accountID, &a.balance.Currency, &a.balance, &a.annualRate, &a.dayCount); err != nil {
+			rows.Close()
+			return false, 0, err
+		}
+		if a.balance.IsPositive() {
+			accruals = append(accruals, a)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, 0, err
+	}
+
+	for _, a := range accruals {
+		rate, err := models.ParsePercent(a.annualRate)
+		if err != nil {
+			return false, 0, err
+		}
+		amount := models.DailyInterest(a.balance, rate, a.dayCount, day)
+
+		_, err = tx.Exec(`
+			INSERT INTO interest_accruals (account_id, accrual_date, balance, annual_rate, day_count, amount)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			a.accountID, date, a.balance, a.annualRate, a.dayCount, amount.FloatString(10))
+		if err != nil {
+			return false, 0, err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE interest_accrual_runs SET accounts = $2 WHERE accrual_date = $1", date, len(accruals))
+	if err != nil {
+		return false, 0, err
+	}
+	return true, len(accruals), tx.Commit()
+}
+
+// interestAccrualJob accrues interest for every day that has ended since
+// the last day accrued, so that days missed while the server was down are
+// caught up on. The first run only accrues yesterday.
+func (s *Server) interestAccrualJob(ctx context.Context) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+
+	var last sql.NullTime
+	if err := s.db.QueryRow("SELECT MAX(accrual_date) FROM interest_accrual_runs").Scan(&last); err != nil {
+		return err
+	}
+	day := yesterday
+	if last.Valid {
+		day = last.Time.UTC().AddDate(0, 0, 1)
+	}
+
+	for ; !day.After(yesterday) && ctx.Err() == nil; day = day.AddDate(0, 0, 1) {
+		accrued, n, err := s.accrueInterest(day)
+		if err != nil {
+			return err
+		}
+		if accrued {
+			s.logger.Printf("Accrued interest for %d accounts on %s", n, day.Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
+// postInterest credits an account with the interest it accrued over a
+// month that has ended, rounded to the minor unit, as one credit from the
+// interest expense account. Accruals are marked posted in the same database
+// transaction, so a month is never paid twice. Interest of a closed user is
+// forfeited, and that of a user frozen with block_credits waits until they
+// are unfrozen.
+func (s *Server) postInterest(accountID int64, month time.Time) (bool, error) {
+	posted := false
+	err := s.inTx(func(tx *sql.Tx) error {
+		posted = false
+
+		var total sql.NullString
+		err := tx.QueryRow(`
+			SELECT ROUND(SUM(amount), 2)
+			FROM (
+				SELECT amount
+				FROM interest_accruals
+				WHERE account_id = $1
+				AND accrual_date >= $2 AND accrual_date < $3
+				AND posted_at IS NULL
+				FOR UPDATE
+			) unposted`,
+			accountID, month.Format("2006-01-02"), month.AddDate(0, 1, 0).Format("2006-01-02")).Scan(&total)
+		if err != nil || !total.Valid {
+			// Posted by someone else in the meantime
+			return err
+		}
+
+		var (
+			userID   int64
+			currency string
+		)
+		err = tx.QueryRow("SELECT user_id, currency FROM accounts WHERE id = $1", accountID).Scan(&userID, &currency)
+		if err != nil {
+			return err
+		}
+		amount, err := models.ParseMoney(total.String, currency)
+		if err != nil {
+			return err
+		}
+
+		forfeit := false
+		switch err := checkUserStatus(tx, userID, false); err {
+		case nil:
+		case errAccountClosed:
+			forfeit = true
+		case errAccountFrozen:
+			return nil
+		default:
+			return err
+		}
+
+		var transactionID *int64
+		if amount.IsPositive() && !forfeit {
+			expenseID, err := systemAccountID(tx, interestExpenseAccount, currency)
+			if err != nil {
+				return err
+			}
+			postings := []posting{
+				{accountID: expenseID, amount: amount.Neg()},
+				{accountID: accountID, amount: amount},
+			}
+			if err = lockAccounts(tx, postingAccountIDs(postings)...); err != nil {
+				return err
+			}
+
+			var id int64
+			err = tx.QueryRow(`
+				INSERT INTO transactions (to_user_id, amount, currency, type)
+				VALUES ($1, $2, $3, 'credit')
+				RETURNING id`,
+				userID, amount, currency).Scan(&id)
+			if err != nil {
+				return err
+			}
+			if err = postEntry(tx, id, "Interest for "+month.Format("January 2006"), postings); err != nil {
+				return err
+			}
+			transactionID = &id
+		}
+
+		_, err = tx.Exec(`
+			UPDATE interest_accruals
+			SET posted_at = NOW(), transaction_id = $4
+			WHERE account_id = $1
+			AND accrual_date >= $2 AND accrual_date < $3
+			AND posted_at IS NULL`,
+			accountID, month.Format("2006-01-02"), month.AddDate(0, 1, 0).Format("2006-01-02"), transactionID)
+		posted = err == nil
+		return err
+	})
+	return posted, err
+}
+
+// forfeitInterest marks the interest a user has accrued but not been paid
+// as posted without a transaction. It is used on closing, after which the
+// user's accounts take no more credits.
+func forfeitInterest(tx *sql.Tx, userID int64) error {
+	_, err := tx.Exec(`
+		UPDATE interest_accruals
+		SET posted_at = NOW()
+		WHERE posted_at IS NULL
+		AND account_id IN (SELECT id FROM accounts WHERE user_id = $1)`, userID)
+	return err
+}
+
+// interestPostingJob posts the interest of every month that has ended and
+// not been posted yet.
+func (s *Server) interestPostingJob(ctx context.Context) error {
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	rows, err := s.db.Query(`
+		SELECT account_id, date_trunc('month', accrual_date)::date AS month
+		FROM interest_accruals
+		WHERE posted_at IS NULL AND accrual_date < $1
+		GROUP BY account_id, month
+		ORDER BY month, account_id`, monthStart)
+	if err != nil {
+		return err
+	}
+
+	type accountMonth struct {
+		accountID int64
+		month     time.Time
+	}
+	var due []accountMonth
+	for rows.Next() {
+		var am accountMonth
+		if err := rows.Scan(&am.accountID, &am.month); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, am)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	n := 0
+	for _, am := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		posted, err := s.postInterest(am.accountID, am.month.UTC())
+		if err != nil {
+			return err
+		}
+		if posted {
+			n++
+		}
+	}
+	if n > 0 {
+		s.logger.Printf("Posted interest to %d accounts", n)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"ledger/internal/models"
+)
+
+// accrueTestInterest records amount of interest accrued by the user's USD
+// account on day and returns the account.
+func (e *testEnv) accrueTestInterest(userID int64, day time.Time, amount string) int64 {
+	e.t.Helper()
+	var accountID int64
+	err := e.db.QueryRow("SELECT id FROM accounts WHERE user_id = $1 AND currency = 'USD'", userID).Scan(&accountID)
+	if err != nil {
+		e.t.Fatalf("Failed to find account: %v", err)
+	}
+	_, err = e.db.Exec(`
+		INSERT INTO interest_accruals (account_id, accrual_date, balance, annual_rate, day_count, amount)
+		VALUES ($1, $2, 100, 5, 'ACT/365', $3)`,
+		accountID, day.Format("2006-01-02"), amount)
+	if err != nil {
+		e.t.Fatalf("Failed to record accrual: %v", err)
+	}
+	return accountID
+}
+
+func TestInterestPostingFollowsUserStatus(t *testing.T) {
+	e := newTestEnv(t)
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Frozen With Blocked Credits", func(t *testing.T) {
+		userID, _ := e.newUser(models.RoleUser)
+		accountID := e.accrueTestInterest(userID, month, "1.2345")
+		e.changeStatus(userID, "freeze", `"block_credits":true`, http.StatusOK)
+
+		posted, err := e.server.postInterest(accountID, month)
+		if err != nil || posted {
+			t.Fatalf("postInterest = %v, %v; want it to wait", posted, err)
+		}
+		e.expectBalance(userID, "USD", "0.00", "0.00")
+
+		e.changeStatus(userID, "unfreeze", "", http.StatusOK)
+		posted, err = e.server.postInterest(accountID, month)
+		if err != nil || !posted {
+			t.Fatalf("postInterest = %v, %v; want it posted", posted, err)
+		}
+		e.expectBalance(userID, "USD", "1.23", "1.23")
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		userID, _ := e.newUser(models.RoleUser)
+		accountID := e.accrueTestInterest(userID, month, "1.2345")
+		e.changeStatus(userID, "close", "", http.StatusOK)
+
+		var unposted int
+		err := e.db.QueryRow("SELECT COUNT(*) FROM interest_accruals WHERE account_id = $1 AND posted_at IS NULL", accountID).Scan(&unposted)
+		if err != nil {
+			t.Fatalf("Failed to count accruals: %v", err)
+		}
+		if unposted != 0 {
+			t.Errorf("Expected closing to forfeit the accruals, %d left", unposted)
+		}
+
+		// Accruals recorded after closing, by a late accrual run, are
+		// forfeited when posted
+		accountID = e.accrueTestInterest(userID, month.AddDate(0, 0, 1), "0.5")
+		posted, err := e.server.postInterest(accountID, month)
+		if err != nil || !posted {
+			t.Fatalf("postInterest = %v, %v; want the accrual settled", posted, err)
+		}
+		e.expectBalance(userID, "USD", "0.00", "0.00")
+	})
+}
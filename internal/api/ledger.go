@@ -0,0 +1,461 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"ledger/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// System accounts. Money entering or leaving the ledger is booked against
+// the settlement account; opening_balance absorbs balances migrated from the
+// old users.balance column; fees collects the fees charged to users.
+const (
+	settlementAccount     = "settlement"
+	openingBalanceAccount = "opening_balance"
+	feesAccount           = "fees"
+)
+
+var (
+	errUserNotFound        = errors.New("user not found")
+	errCurrencyMismatch    = errors.New("no account in this currency")
+	errUnbalancedEntry     = errors.New("journal entry does not balance")
+	errInsufficientBalance = errors.New("insufficient balance")
+	errAmountTooSmall      = errors.New("amount too small to convert")
+	errAccountFrozen       = errors.New("account is frozen")
+	errAccountClosed       = errors.New("account is closed")
+)
+
+// partyError attributes a failed account lookup to one side of a movement,
+// e.g. the sender or the recipient of a transfer.
+type partyError struct {
+	subject  string
+	currency string
+	err      error
+}
+
+func (e *partyError) Error() string {
+	return strings.ToLower(e.subject) + ": " + e.err.Error()
+}
+
+func (e *partyError) Unwrap() error { return e.err }
+
+// availableBalanceSQL computes the available balance of the account aliased
+// as a: the posted balance less every pending hold that has not expired.
+const availableBalanceSQL = `a.balance - COALESCE((
+	SELECT SUM(h.amount)
+	FROM holds h
+	WHERE h.account_id = a.id
+	AND h.status = 'pending'
+	AND h.expires_at > NOW()
+), 0)`
+
+// balanceAtSQL computes the balance of the account aliased as a at the time
+// given by the placeholder at, either including or excluding postings made
+// at that exact time. It is the running balance of the last such posting,
+// searched from the nearest balance snapshot, whose balance stands if no
+// posting was made since it was taken.
+func balanceAtSQL(at string, inclusive bool) string {
+	comparison := "<"
+	if inclusive {
+		comparison = "<="
+	}
+	return `COALESCE((
+		SELECT p.balance_after
+		FROM postings p
+		WHERE p.account_id = a.id
+		AND p.created_at >= COALESCE((
+			SELECT MAX(bs.taken_at)
+			FROM balance_snapshots bs
+			WHERE bs.account_id = a.id AND bs.taken_at <= ` + at + `
+		), '-infinity')
+		AND p.created_at ` + comparison + ` ` + at + `
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT 1
+	), (
+		SELECT bs.balance
+		FROM balance_snapshots bs
+		WHERE bs.account_id = a.id AND bs.taken_at <= ` + at + `
+		ORDER BY bs.taken_at DESC
+		LIMIT 1
+	), 0)`
+}
+
+// posting is one leg of a journal entry. A positive amount increases the
+// account balance, a negative amount decreases it.
+type posting struct {
+	accountID int64
+	amount    models.Money
+}
+
+// requestCurrency normalises the currency named in a request, defaulting to
+// models.DefaultCurrency when none is given.
+func requestCurrency(currency string) (string, bool) {
+	if currency == "" {
+		return models.DefaultCurrency, true
+	}
+	currency = strings.ToUpper(currency)
+	return currency, models.IsSupportedCurrency(currency)
+}
+
+// userAccountID returns the user's account in currency. It fails with
+// errUserNotFound if the user does not exist and with errCurrencyMismatch if
+// the user holds no account in that currency.
+func userAccountID(tx *sql.Tx, userID int64, currency string) (int64, error) {
+	var id sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT a.id
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id AND a.currency = $2
+		WHERE u.id = $1`,
+		userID, currency).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errUserNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !id.Valid {
+		return 0, errCurrencyMismatch
+	}
+	return id.Int64, nil
+}
+
+// checkUserStatus fails with errAccountClosed or errAccountFrozen unless the
+// user's status allows money to leave (debit) or enter their accounts.
+// Closed users refuse both; frozen users refuse debits, and credits too when
+// frozen with block_credits. The user row is share-locked so that a status
+// change waits for movements already under way.
+func checkUserStatus(tx *sql.Tx, userID int64, debit bool) error {
+	var (
+		status       string
+		blockCredits bool
+	)
+	err := tx.QueryRow("SELECT status, block_credits FROM users WHERE id = $1 FOR SHARE", userID).
+		Scan(&status, &blockCredits)
+	if err == sql.ErrNoRows {
+		return errUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status == models.UserClosed:
+		return errAccountClosed
+	case status == models.UserFrozen && (debit || blockCredits):
+		return errAccountFrozen
+	}
+	return nil
+}
+
+// movableAccountID is userAccountID for an account about to be debited or
+// credited, checking the user's status allows it.
+func movableAccountID(tx *sql.Tx, userID int64, currency string, debit bool) (int64, error) {
+	id, err := userAccountID(tx, userID, currency)
+	if err != nil {
+		return 0, err
+	}
+	return id, checkUserStatus(tx, userID, debit)
+}
+
+// systemAccountID returns the id of a system account by its code and
+// currency, opening the account the first time it is needed.
+func systemAccountID(tx *sql.Tx, code, currency string) (int64, error) {
+	_, err := tx.Exec(`
+		INSERT INTO accounts (code, currency)
+		VALUES ($1, $2)
+		ON CONFLICT (code, currency) DO NOTHING`,
+		code, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRow("SELECT id FROM accounts WHERE code = $1 AND currency = $2", code, currency).Scan(&id)
+	return id, err
+}
+
+// currencyBalance returns the balances of an account.
+func currencyBalance(tx *sql.Tx, accountID int64) (models.CurrencyBalance, error) {
+	var b models.CurrencyBalance
+	err := tx.QueryRow(`
+		SELECT a.currency, a.balance, `+availableBalanceSQL+`, a.overdraft_limit
+		FROM accounts a
+		WHERE a.id = $1`,
+		accountID).Scan(&b.Currency, &b.Posted, &b.Available, &b.OverdraftLimit)
+	if err != nil {
+		return b, err
+	}
+	return newCurrencyBalance(b.Currency, b.Posted, b.Available, b.OverdraftLimit), nil
+}
+
+// newCurrencyBalance fills in a CurrencyBalance, working out how much of the
+// overdraft limit is left.
+func newCurrencyBalance(currency string, posted, available, limit models.Money) models.CurrencyBalance {
+	posted.Currency = currency
+	available.Currency = currency
+	limit.Currency = currency
+
+	left := limit
+	if available.IsNegative() {
+		left = limit.Add(available)
+	}
+	if left.IsNegative() {
+		// The limit was lowered below what is already drawn
+		left = models.NewMoney(0, currency)
+	}
+
+	return models.CurrencyBalance{
+		Currency:           currency,
+		Balance:            posted,
+		Posted:             posted,
+		Available:          available,
+		OverdraftLimit:     limit,
+		OverdraftAvailable: left,
+	}
+}
+
+// lockAccounts locks the given accounts for update in ascending id order.
+// Every movement locks all the accounts it touches this way before checking
+// funds, so that the check holds until commit and concurrent movements that
+// share accounts queue up rather than deadlock.
+func lockAccounts(tx *sql.Tx, ids ...int64) error {
+	_, err := tx.Exec(`
+		SELECT id
+		FROM accounts
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE`,
+		pq.Array(ids))
+	return err
+}
+
+// postingAccountIDs returns the accounts of an entry's postings.
+func postingAccountIDs(postings []posting) []int64 {
+	ids := make([]int64, len(postings))
+	for i, p := range postings {
+		ids[i] = p.accountID
+	}
+	return ids
+}
+
+// ensureFunds fails with errInsufficientBalance unless amount can be taken
+// from the account's available balance and what is left of its overdraft
+// limit. Every debit - transfers, withdrawals
+// and new holds - goes through this check, with the account locked by
+// lockAccounts.
+func ensureFunds(tx *sql.Tx, accountID int64, amount models.Money) error {
+	b, err := currencyBalance(tx, accountID)
+	if err != nil {
+		return err
+	}
+	if b.Available.Add(b.OverdraftLimit).LessThan(amount) {
+		return errInsufficientBalance
+	}
+	return nil
+}
+
+// writeFundsError responds to a failed ensureFunds check.
+func writeFundsError(w http.ResponseWriter, err error) {
+	if err == errInsufficientBalance {
+		http.Error(w, "Insufficient balance", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to get user balance", http.StatusInternalServerError)
+}
+
+// writeAccountError responds to a failed userAccountID lookup. subject names
+// the party in the error message, e.g. "User" or "Recipient".
+func writeAccountError(w http.ResponseWriter, err error, subject, currency string) {
+	status, message := accountErrorStatus(err, subject, currency)
+	http.Error(w, message, status)
+}
+
+// accountErrorStatus maps an error from userAccountID to a response status
+// and message.
+func accountErrorStatus(err error, subject, currency string) (int, string) {
+	switch err {
+	case errUserNotFound:
+		return http.StatusNotFound, subject + " not found"
+	case errCurrencyMismatch:
+		return http.StatusUnprocessableEntity, fmt.Sprintf("%s has no %s account", subject, currency)
+	case errAccountFrozen:
+		return http.StatusConflict, subject + " account is frozen"
+	case errAccountClosed:
+		return http.StatusConflict, subject + " account is closed"
+	default:
+		return http.StatusInternalServerError, "Internal server error"
+	}
+}
+
+// maxTxAttempts bounds how often inTx runs a transaction that keeps failing
+// with a serialization failure or deadlock.
+const maxTxAttempts = 5
+
+// isRetryable reports whether err aborted a transaction in a way that
+// running it again from the start may avoid.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	// serialization_failure and deadlock_detected
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+// inTx runs fn in a transaction and commits it. A transaction that fails
+// with a serialization failure or deadlock is rolled back and run again,
+// up to maxTxAttempts times in all, so fn must not have effects outside
+// the transaction.
+func (s *Server) inTx(fn func(tx *sql.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runTx(s.db, fn)
+		if err == nil || !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+		s.logger.Printf("Retrying transaction after attempt %d: %v", attempt, err)
+		time.Sleep(time.Duration(attempt*10+rand.Intn(10)) * time.Millisecond)
+	}
+}
+
+func runTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// postEntry records a journal entry for transactionID and applies its
+// postings to the cached account balances. The postings must sum to zero in
+// every currency.
+func postEntry(tx *sql.Tx, transactionID int64, description string, postings []posting) error {
+	sums := make(map[string]int64)
+	for _, p := range postings {
+		sums[p.amount.Currency] += p.amount.Units
+	}
+	if len(postings) < 2 {
+		return errUnbalancedEntry
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return errUnbalancedEntry
+		}
+	}
+
+	// Callers have normally locked the accounts already; this only makes
+	// sure no entry takes its locks out of order
+	if err := lockAccounts(tx, postingAccountIDs(postings)...); err != nil {
+		return err
+	}
+
+	var entryID int64
+	err := tx.QueryRow(`
+		INSERT INTO journal_entries (transaction_id, description)
+		VALUES ($1, $2)
+		RETURNING id`,
+		transactionID, description).Scan(&entryID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range postings {
+		// Update the account first: the row lock it takes orders this
+		// posting after every earlier one on the account.
+		var balanceAfter models.Money
+		err = tx.QueryRow(`
+			UPDATE accounts
+			SET balance = balance + $1
+			WHERE id = $2
+			RETURNING balance`,
+			p.amount, p.accountID).Scan(&balanceAfter)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO postings (entry_id, account_id, amount, balance_after)
+			VALUES ($1, $2, $3, $4)`,
+			entryID, p.accountID, p.amount, balanceAfter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyLedger checks that every journal entry balances and that every
+// cached account balance matches the sum of its postings.
+func (s *Server) verifyLedger(w http.ResponseWriter, r *http.Request) {
+	check := models.LedgerCheck{
+		UnbalancedEntries:  []models.UnbalancedEntry{},
+		MismatchedAccounts: []models.AccountMismatch{},
+	}
+
+	rows, err := s.db.Query(`
+		SELECT p.entry_id, a.currency, SUM(p.amount)
+		FROM postings p
+		JOIN accounts a ON a.id = p.account_id
+		GROUP BY p.entry_id, a.currency
+		HAVING SUM(p.amount) <> 0
+		ORDER BY p.entry_id`)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.UnbalancedEntry
+		if err := rows.Scan(&e.EntryID, &e.Currency, &e.Sum); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		e.Sum.Currency = e.Currency
+		check.UnbalancedEntries = append(check.UnbalancedEntries, e)
+	}
+
+	rows, err = s.db.Query(`
+		SELECT a.id, a.currency, a.balance, COALESCE(SUM(p.amount), 0)
+		FROM accounts a
+		LEFT JOIN postings p ON p.account_id = a.id
+		GROUP BY a.id, a.currency, a.balance
+		HAVING a.balance <> COALESCE(SUM(p.amount), 0)
+		ORDER BY a.id`)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.AccountMismatch
+		if err := rows.Scan(&m.AccountID, &m.Currency, &m.CachedBalance, &m.PostedBalance); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		m.CachedBalance.Currency = m.Currency
+		m.PostedBalance.Currency = m.Currency
+		check.MismatchedAccounts = append(check.MismatchedAccounts, m)
+	}
+
+	check.Balanced = len(check.UnbalancedEntries) == 0 && len(check.MismatchedAccounts) == 0
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(check)
+}
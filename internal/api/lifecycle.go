@@ -0,0 +1,311 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+var (
+	errNotFrozen        = errors.New("account is not frozen")
+	errBalanceRemaining = errors.New("balance remaining")
+	errOverdrawn        = errors.New("account is overdrawn")
+	errHoldsPending     = errors.New("holds pending")
+	errSweepToSelf      = errors.New("sweep target is the account being closed")
+)
+
+func (s *Server) freezeUser(w http.ResponseWriter, r *http.Request) {
+	s.changeUserStatus(w, r, models.UserFrozen)
+}
+
+func (s *Server) unfreezeUser(w http.ResponseWriter, r *http.Request) {
+	s.changeUserStatus(w, r, models.UserActive)
+}
+
+func (s *Server) closeUser(w http.ResponseWriter, r *http.Request) {
+	s.changeUserStatus(w, r, models.UserClosed)
+}
+
+// changeUserStatus moves the user in the URL to status and logs the change
+// with its reason. Active users can be frozen or closed, frozen users
+// unfrozen, refrozen with a different block_credits, or closed. Closing
+// needs every account at zero with no pending holds, unless a sweep target
+// is named to take over the remaining balances. It also forfeits interest
+// not yet paid and cancels the user's scheduled transfers.
+func (s *Server) changeUserStatus(w http.ResponseWriter, r *http.Request, status string) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.UserStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "Reason is required", http.StatusBadRequest)
+		return
+	}
+	if req.BlockCredits && status != models.UserFrozen {
+		http.Error(w, "block_credits only applies to freezing", http.StatusBadRequest)
+		return
+	}
+	if req.SweepToUserID != nil && status != models.UserClosed {
+		http.Error(w, "sweep_to_user_id only applies to closing", http.StatusBadRequest)
+		return
+	}
+
+	changedBy, _, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var change models.UserStatusChange
+	err = s.inTx(func(tx *sql.Tx) error {
+		var oldStatus string
+		err := tx.QueryRow("SELECT status FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&oldStatus)
+		if err == sql.ErrNoRows {
+			return errUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case oldStatus == models.UserClosed:
+			return errAccountClosed
+		case status == models.UserActive && oldStatus != models.UserFrozen:
+			return errNotFrozen
+		}
+
+		var sweeps []int64
+		if status == models.UserClosed {
+			if sweeps, err = sweepBalances(tx, userID, req.SweepToUserID); err != nil {
+				return err
+			}
+			// Closed users cannot log in, so they should not stay logged in
+			if _, err = revokeSessions(tx, "user_id = $1", userID); err != nil {
+				return err
+			}
+			if err = forfeitInterest(tx, userID); err != nil {
+				return err
+			}
+			if err = cancelUserSchedules(tx, userID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec("UPDATE users SET status = $2, block_credits = $3 WHERE id = $1", userID, status, req.BlockCredits)
+		if err != nil {
+			return err
+		}
+
+		change, err = scanUserStatusChange(tx.QueryRow(`
+			INSERT INTO user_status_changes (
+				user_id, old_status, new_status, block_credits, reason, sweep_to_user_id, changed_by
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING `+userStatusChangeColumns,
+			userID, oldStatus, status, req.BlockCredits, req.Reason, req.SweepToUserID, changedBy))
+		change.Sweeps = sweeps
+		return err
+	})
+
+	var pe *partyError
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(change)
+	case err == errUserNotFound:
+		http.Error(w, "User not found", http.StatusNotFound)
+	case err == errAccountClosed:
+		http.Error(w, "Account is closed", http.StatusConflict)
+	case err == errNotFrozen:
+		http.Error(w, "Account is not frozen", http.StatusConflict)
+	case err == errSweepToSelf:
+		http.Error(w, "Balances cannot be swept to the account being closed", http.StatusBadRequest)
+	case errors.As(err, &pe):
+		writeAccountError(w, pe.err, pe.subject, pe.currency)
+	case err == errBalanceRemaining:
+		http.Error(w, "Account balances must be zero, or a sweep target given, to close", http.StatusConflict)
+	case err == errOverdrawn:
+		http.Error(w, "An overdrawn account cannot be closed", http.StatusConflict)
+	case err == errHoldsPending:
+		http.Error(w, "Pending holds must be captured or voided before closing", http.StatusConflict)
+	default:
+		s.logger.Printf("Error changing status of user %d: %v", userID, err)
+		http.Error(w, "Failed to change account status", http.StatusInternalServerError)
+	}
+}
+
+// sweepBalances empties the accounts of a user being closed into the
+// accounts of sweepTo in the same currencies, one transfer per account, and
+// returns the transfers. Without sweepTo every account must already be at
+// zero. Overdrawn accounts and pending holds block closing either way.
+func sweepBalances(tx *sql.Tx, userID int64, sweepTo *int64) ([]int64, error) {
+	if sweepTo != nil && *sweepTo == userID {
+		return nil, errSweepToSelf
+	}
+
+	var pending bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM holds h
+			JOIN accounts a ON a.id = h.account_id
+			WHERE a.user_id = $1 AND h.status = 'pending' AND h.expires_at > NOW()
+		)`, userID).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, errHoldsPending
+	}
+
+	rows, err := tx.Query("SELECT id, currency FROM accounts WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	type account struct {
+		id       int64
+		currency string
+	}
+	var accounts []account
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.id, &a.currency); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Resolve the target accounts first, so all locks are taken at once
+	ids := make([]int64, 0, 2*len(accounts))
+	targets := make(map[string]int64)
+	for _, a := range accounts {
+		ids = append(ids, a.id)
+		if sweepTo == nil {
+			continue
+		}
+		target, err := movableAccountID(tx, *sweepTo, a.currency, false)
+		if err != nil {
+			return nil, &partyError{subject: "Sweep target", currency: a.currency, err: err}
+		}
+		targets[a.currency] = target
+		ids = append(ids, target)
+	}
+	if err = lockAccounts(tx, ids...); err != nil {
+		return nil, err
+	}
+
+	var sweeps []int64
+	for _, a := range accounts {
+		var balance models.Money
+		if err := tx.QueryRow("SELECT balance FROM accounts WHERE id = $1", a.id).Scan(&balance); err != nil {
+			return nil, err
+		}
+		balance.Currency = a.currency
+
+		switch {
+		case balance.IsZero():
+			continue
+		case balance.IsNegative():
+			return nil, errOverdrawn
+		case sweepTo == nil:
+			return nil, errBalanceRemaining
+		}
+
+		var transactionID int64
+		err = tx.QueryRow(`
+			INSERT INTO transactions (
+				from_user_id, to_user_id, amount, currency, type, target_amount, target_currency
+			)
+			VALUES ($1, $2, $3, $4, 'transfer', $3, $4)
+			RETURNING id`,
+			userID, *sweepTo, balance, a.currency).Scan(&transactionID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = postEntry(tx, transactionID, "Account closure sweep", []posting{
+			{accountID: a.id, amount: balance.Neg()},
+			{accountID: targets[a.currency], amount: balance},
+		})
+		if err != nil {
+			return nil, err
+		}
+		sweeps = append(sweeps, transactionID)
+	}
+	return sweeps, nil
+}
+
+const userStatusChangeColumns = `
+	id, user_id, old_status, new_status, block_credits, reason, sweep_to_user_id, changed_by, created_at`
+
+func scanUserStatusChange(row rowScanner) (models.UserStatusChange, error) {
+	var (
+		c         models.UserStatusChange
+		sweepTo   sql.NullInt64
+		changedBy sql.NullInt64
+	)
+	err := row.Scan(&c.ID, &c.UserID, &c.OldStatus, &c.NewStatus, &c.BlockCredits, &c.Reason,
+		&sweepTo, &changedBy, &c.CreatedAt)
+	if sweepTo.Valid {
+		c.SweepToUserID = &sweepTo.Int64
+	}
+	if changedBy.Valid {
+		c.ChangedBy = &changedBy.Int64
+	}
+	return c, err
+}
+
+// listUserStatusChanges returns a user's status history, oldest first.
+func (s *Server) listUserStatusChanges(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+userStatusChangeColumns+`
+		FROM user_status_changes
+		WHERE user_id = $1
+		ORDER BY created_at, id`, userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	changes := []models.UserStatusChange{}
+	for rows.Next() {
+		c, err := scanUserStatusChange(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"ledger/internal/models"
+)
+
+// changeStatus freezes, unfreezes or closes a user as the admin, with body
+// adding to the reason.
+func (e *testEnv) changeStatus(userID int64, action, body string, status int) models.UserStatusChange {
+	e.t.Helper()
+	var change models.UserStatusChange
+	if body != "" {
+		body = "," + body
+	}
+	rr := e.do("POST", fmt.Sprintf("/api/users/%d/%s", userID, action), e.adminToken, `{"reason":"test"`+body+`}`)
+	if status != http.StatusOK {
+		e.expect(rr, status, nil)
+		return change
+	}
+	e.expect(rr, status, &change)
+	return change
+}
+
+// transferStatus attempts a transfer of amount USD as the admin and returns
+// the response status.
+func (e *testEnv) transferStatus(fromUserID, toUserID int64, amount string) int {
+	e.t.Helper()
+	body := fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":%q}`, fromUserID, toUserID, amount)
+	return e.do("POST", "/api/transfer", e.adminToken, body).Code
+}
+
+func TestFreezeAndUnfreeze(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+	other, _ := e.newUser(models.RoleUser)
+	e.credit(userID, "100.00", "USD")
+	e.credit(other, "100.00", "USD")
+
+	e.expect(e.do("POST", fmt.Sprintf("/api/users/%d/freeze", userID), e.adminToken, `{}`), http.StatusBadRequest, nil)
+	e.changeStatus(userID, "unfreeze", "", http.StatusConflict)
+
+	change := e.changeStatus(userID, "freeze", "", http.StatusOK)
+	if change.OldStatus != models.UserActive || change.NewStatus != models.UserFrozen {
+		t.Errorf("Expected active to frozen, got %s to %s", change.OldStatus, change.NewStatus)
+	}
+	if got := e.transferStatus(userID, other, "1.00"); got != http.StatusConflict {
+		t.Errorf("Transfer from a frozen user: got %d, want 409", got)
+	}
+	if got := e.transferStatus(other, userID, "1.00"); got != http.StatusOK {
+		t.Errorf("Transfer to a frozen user: got %d, want 200", got)
+	}
+
+	// Freezing again can block credits too
+	e.changeStatus(userID, "freeze", `"block_credits":true`, http.StatusOK)
+	if got := e.transferStatus(other, userID, "1.00"); got != http.StatusConflict {
+		t.Errorf("Transfer to a user frozen with block_credits: got %d, want 409", got)
+	}
+
+	e.changeStatus(userID, "unfreeze", "", http.StatusOK)
+	if got := e.transferStatus(userID, other, "1.00"); got != http.StatusOK {
+		t.Errorf("Transfer from an unfrozen user: got %d, want 200", got)
+	}
+
+	var changes []models.UserStatusChange
+	e.expect(e.do("GET", fmt.Sprintf("/api/users/%d/status-changes", userID), e.adminToken, ""), http.StatusOK, &changes)
+	if len(changes) != 3 {
+		t.Errorf("Expected 3 status changes, got %d", len(changes))
+	}
+}
+
+func TestCompensationFollowsUserStatus(t *testing.T) {
+	e := newTestEnv(t)
+	sender, _ := e.newUser(models.RoleUser)
+	recipient, _ := e.newUser(models.RoleUser)
+	e.credit(sender, "100.00", "USD")
+	transfer := e.transfer(sender, recipient, "10.00")
+
+	// The recipient gives the money back, so must be free to pay
+	e.changeStatus(recipient, "freeze", "", http.StatusOK)
+	e.compensate(transfer.TransactionID, "", http.StatusConflict)
+	e.changeStatus(recipient, "unfreeze", "", http.StatusOK)
+
+	// The sender takes it back, so must be free to receive
+	e.changeStatus(sender, "freeze", `"block_credits":true`, http.StatusOK)
+	e.compensate(transfer.TransactionID, "5.00", http.StatusConflict)
+	e.changeStatus(sender, "freeze", "", http.StatusOK)
+	e.compensate(transfer.TransactionID, "5.00", http.StatusCreated)
+	e.changeStatus(sender, "unfreeze", "", http.StatusOK)
+
+	e.changeStatus(recipient, "close", fmt.Sprintf(`"sweep_to_user_id":%d`, sender), http.StatusOK)
+	e.compensate(transfer.TransactionID, "", http.StatusConflict)
+	e.expectBalance(sender, "USD", "100.00", "100.00")
+}
+
+func TestCloseUser(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+	target, _ := e.newUser(models.RoleUser)
+	e.credit(userID, "100.00", "USD")
+
+	t.Run("Pending Holds", func(t *testing.T) {
+		hold := e.createTestHold(userID, "10.00")
+		e.changeStatus(userID, "close", fmt.Sprintf(`"sweep_to_user_id":%d`, target), http.StatusConflict)
+		e.expect(e.do("POST", fmt.Sprintf("/api/holds/%d/void", hold.ID), e.adminToken, ""), http.StatusOK, nil)
+	})
+
+	t.Run("Balance Remaining", func(t *testing.T) {
+		e.changeStatus(userID, "close", "", http.StatusConflict)
+		e.changeStatus(userID, "close", fmt.Sprintf(`"sweep_to_user_id":%d`, userID), http.StatusBadRequest)
+		e.changeStatus(userID, "close", `"sweep_to_user_id":999999999`, http.StatusNotFound)
+	})
+
+	t.Run("Overdrawn", func(t *testing.T) {
+		overdrawn, _ := e.newUser(models.RoleUser)
+		body := `{"limit":"50.00","currency":"USD","reason":"test"}`
+		e.expect(e.do("PUT", fmt.Sprintf("/api/users/%d/overdraft-limit", overdrawn), e.adminToken, body), http.StatusOK, nil)
+		e.expect(e.do("POST", fmt.Sprintf("/api/users/%d/withdraw", overdrawn), e.adminToken, `{"amount":"20.00"}`), http.StatusOK, nil)
+		e.expectBalance(overdrawn, "USD", "-20.00", "-20.00")
+
+		e.changeStatus(overdrawn, "close", fmt.Sprintf(`"sweep_to_user_id":%d`, target), http.StatusConflict)
+	})
+
+	t.Run("Sweep", func(t *testing.T) {
+		st := e.createTestSchedule(userID, target, "5.00", models.FrequencyDaily, time.Now().Add(time.Hour))
+		incoming := e.createTestSchedule(target, userID, "5.00", models.FrequencyWeekly, time.Now().Add(time.Hour))
+
+		change := e.changeStatus(userID, "close", fmt.Sprintf(`"sweep_to_user_id":%d`, target), http.StatusOK)
+		if change.NewStatus != models.UserClosed || len(change.Sweeps) != 1 {
+			t.Errorf("Expected a close with one sweep, got %s with %v", change.NewStatus, change.Sweeps)
+		}
+		e.expectBalance(userID, "USD", "0.00", "0.00")
+		e.expectBalance(target, "USD", "100.00", "100.00")
+
+		for _, id := range []int64{st.ID, incoming.ID} {
+			var status string
+			if err := e.db.QueryRow("SELECT status FROM scheduled_transfers WHERE id = $1", id).Scan(&status); err != nil {
+				t.Fatalf("Failed to load schedule %d: %v", id, err)
+			}
+			if status != models.ScheduleCancelled {
+				t.Errorf("Schedule %d: got %s, want cancelled", id, status)
+			}
+		}
+
+		if got := e.transferStatus(target, userID, "1.00"); got != http.StatusConflict {
+			t.Errorf("Transfer to a closed user: got %d, want 409", got)
+		}
+		e.changeStatus(userID, "close", "", http.StatusConflict)
+		e.changeStatus(userID, "unfreeze", "", http.StatusConflict)
+	})
+}
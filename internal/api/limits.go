@@ -0,0 +1,356 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+// Error codes returned when an operation breaks a limit rule.
+const (
+	limitSingleAmountExceeded = "limit_single_amount_exceeded"
+	limitTotalAmountExceeded  = "limit_total_amount_exceeded"
+	limitCountExceeded        = "limit_count_exceeded"
+)
+
+// limitError is returned by checkLimits for an operation a rule forbids.
+type limitError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RuleID  int64  `json:"rule_id"`
+}
+
+func (e *limitError) Error() string { return e.Code + ": " + e.Message }
+
+// writeLimitError responds to a broken limit rule with its code.
+func writeLimitError(w http.ResponseWriter, err *limitError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(err)
+}
+
+// limitUsageSQL selects the count and total amount of a user's operations
+// of one kind since a number of seconds ago, optionally in one currency.
+var limitUsageSQL = map[string]string{
+	models.LimitTransfer: `
+		SELECT COUNT(*), COALESCE(SUM(amount), 0)
+		FROM transactions
+		WHERE type = 'transfer' AND from_user_id = $1
+		AND ($2 = '' OR currency = $2)
+		AND created_at > NOW() - make_interval(secs => $3)`,
+	models.LimitWithdrawal: `
+		SELECT COUNT(*), COALESCE(SUM(-amount), 0)
+		FROM transactions
+		WHERE type = 'withdrawal' AND to_user_id = $1
+		AND ($2 = '' OR currency = $2)
+		AND created_at > NOW() - make_interval(secs => $3)`,
+}
+
+// checkLimits fails with a *limitError if taking amount out of userID's
+// account by operation would break one of the rules in force for the user.
+// It must run with the account locked, so that concurrent operations are
+// counted against each other.
+func checkLimits(tx *sql.Tx, userID int64, operation string, amount models.Money) error {
+	rows, err := tx.Query(`
+		SELECT DISTINCT ON (r.kind, COALESCE(r.currency, ''), COALESCE(r.window_seconds, 0))
+			r.id, r.kind, COALESCE(r.currency, ''), r.max_amount, r.max_count, r.window_seconds
+		FROM limit_rules r
+		JOIN users u ON u.id = $1
+		WHERE r.operation = $2
+		AND (r.currency IS NULL OR r.currency = $3)
+		AND (r.user_id = u.id OR r.role = u.role)
+		ORDER BY r.kind, COALESCE(r.currency, ''), COALESCE(r.window_seconds, 0), r.user_id NULLS LAST`,
+		userID, operation, amount.Currency)
+	if err != nil {
+		return err
+	}
+
+	var rules []models.LimitRule
+	for rows.Next() {
+		var (
+			rule      models.LimitRule
+			maxAmount sql.NullString
+			maxCount  sql.NullInt64
+			window    sql.NullInt64
+		)
+		if err := rows.Scan(&rule.ID, &rule.Kind, &rule.Currency, &maxAmount, &maxCount, &window); err != nil {
+			rows.Close()
+			return err
+		}
+		if maxAmount.Valid {
+			limit, err := models.ParseMoney(maxAmount.String, rule.Currency)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			rule.MaxAmount = &limit
+		}
+		if maxCount.Valid {
+			rule.MaxCount = &maxCount.Int64
+		}
+		if window.Valid {
+			rule.WindowSeconds = &window.Int64
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.Kind == models.LimitMaxSingleAmount {
+			if rule.MaxAmount.LessThan(amount) {
+				return &limitError{
+					Code:    limitSingleAmountExceeded,
+					Message: fmt.Sprintf("A single %s may not exceed %s %s", operation, rule.MaxAmount, rule.Currency),
+					RuleID:  rule.ID,
+				}
+			}
+			continue
+		}
+
+		var (
+			count int64
+			total = models.Money{Currency: rule.Currency}
+		)
+		err := tx.QueryRow(limitUsageSQL[operation], userID, rule.Currency, *rule.WindowSeconds).Scan(&count, &total)
+		if err != nil {
+			return err
+		}
+
+		switch rule.Kind {
+		case models.LimitMaxTotalAmount:
+			if rule.MaxAmount.LessThan(total.Add(amount)) {
+				return &limitError{
+					Code: limitTotalAmountExceeded,
+					Message: fmt.Sprintf("The %s total within %ds may not exceed %s %s; %s already used",
+						operation, *rule.WindowSeconds, rule.MaxAmount, rule.Currency, total),
+					RuleID: rule.ID,
+				}
+			}
+		case models.LimitMaxCount:
+			if count >= *rule.MaxCount {
+				return &limitError{
+					Code:    limitCountExceeded,
+					Message: fmt.Sprintf("At most %d %ss are allowed within %ds", *rule.MaxCount, operation, *rule.WindowSeconds),
+					RuleID:  rule.ID,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+const limitRuleColumns = `
+	id, kind, operation, COALESCE(role, ''), user_id, COALESCE(currency, ''),
+	max_amount, max_count, window_seconds, created_at, updated_at`
+
+func scanLimitRule(row rowScanner) (models.LimitRule, error) {
+	var (
+		rule      models.LimitRule
+		userID    sql.NullInt64
+		maxAmount sql.NullString
+		maxCount  sql.NullInt64
+		window    sql.NullInt64
+	)
+	err := row.Scan(&rule.ID, &rule.Kind, &rule.Operation, &rule.Role, &userID, &rule.Currency,
+		&maxAmount, &maxCount, &window, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return rule, err
+	}
+
+	if userID.Valid {
+		rule.UserID = &userID.Int64
+	}
+	if maxAmount.Valid {
+		limit, err := models.ParseMoney(maxAmount.String, rule.Currency)
+		if err != nil {
+			return rule, err
+		}
+		rule.MaxAmount = &limit
+	}
+	if maxCount.Valid {
+		rule.MaxCount = &maxCount.Int64
+	}
+	if window.Valid {
+		rule.WindowSeconds = &window.Int64
+	}
+	return rule, nil
+}
+
+// decodeLimitRule reads and validates a limit rule from the request body.
+// It writes the error response and returns false if the rule is invalid.
+func decodeLimitRule(w http.ResponseWriter, r *http.Request) (models.LimitRuleRequest, bool) {
+	var req models.LimitRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return req, false
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// nullString maps the empty string to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *Server) createLimitRule(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeLimitRule(w, r)
+	if !ok {
+		return
+	}
+
+	var createdBy *int64
+	if id, _, err := actingUser(r); err == nil {
+		createdBy = &id
+	}
+
+	rule, err := scanLimitRule(s.db.QueryRow(`
+		INSERT INTO limit_rules (
+			kind, operation, role, user_id, currency,
+			max_amount, max_count, window_seconds, created_by
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+limitRuleColumns,
+		req.Kind, req.Operation, nullString(req.Role), req.UserID, nullString(req.Currency),
+		req.MaxAmount, req.MaxCount, req.WindowSeconds, createdBy))
+	if err != nil {
+		s.logger.Printf("Error creating limit rule: %v", err)
+		http.Error(w, "Failed to create limit rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// listLimitRules returns all limit rules, or those of one role or user when
+// the role or user_id query parameter is given.
+func (s *Server) listLimitRules(w http.ResponseWriter, r *http.Request) {
+	var userID *int64
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = &id
+	}
+	role := r.URL.Query().Get("role")
+
+	rows, err := s.db.Query(`
+		SELECT `+limitRuleColumns+`
+		FROM limit_rules
+		WHERE ($1::INTEGER IS NULL OR user_id = $1)
+		AND ($2 = '' OR role = $2)
+		ORDER BY id`, userID, role)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rules := []models.LimitRule{}
+	for rows.Next() {
+		rule, err := scanLimitRule(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (s *Server) getLimitRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := utils.GetIDFromPath(r, "ruleID")
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := scanLimitRule(s.db.QueryRow(`SELECT `+limitRuleColumns+` FROM limit_rules WHERE id = $1`, ruleID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Limit rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// updateLimitRule replaces a limit rule.
+func (s *Server) updateLimitRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := utils.GetIDFromPath(r, "ruleID")
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	req, ok := decodeLimitRule(w, r)
+	if !ok {
+		return
+	}
+
+	rule, err := scanLimitRule(s.db.QueryRow(`
+		UPDATE limit_rules
+		SET kind = $2, operation = $3, role = $4, user_id = $5, currency = $6,
+			max_amount = $7, max_count = $8, window_seconds = $9, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+limitRuleColumns,
+		ruleID, req.Kind, req.Operation, nullString(req.Role), req.UserID, nullString(req.Currency),
+		req.MaxAmount, req.MaxCount, req.WindowSeconds))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Limit rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("Error updating limit rule: %v", err)
+		http.Error(w, "Failed to update limit rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (s *Server) deleteLimitRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := utils.GetIDFromPath(r, "ruleID")
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM limit_rules WHERE id = $1", ruleID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Limit rule not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// createTestLimitRule creates a limit rule from a JSON body as the admin.
+func (e *testEnv) createTestLimitRule(body string) models.LimitRule {
+	e.t.Helper()
+	var rule models.LimitRule
+	e.expect(e.do("POST", "/api/limit-rules", e.adminToken, body), http.StatusCreated, &rule)
+	return rule
+}
+
+// expectLimitError fails the test unless rr reports that the rule with ruleID
+// was broken, with code.
+func (e *testEnv) expectLimitError(rr *httptest.ResponseRecorder, code string, ruleID int64) {
+	e.t.Helper()
+	var le limitError
+	e.expect(rr, http.StatusUnprocessableEntity, &le)
+	if le.Code != code || le.RuleID != ruleID {
+		e.t.Errorf("Expected %s from rule %d, got %s from rule %d", code, ruleID, le.Code, le.RuleID)
+	}
+}
+
+// limitOperations are the operations limit rules apply to, each taking
+// amount USD out of a user's account as the admin.
+var limitOperations = []struct {
+	operation string
+	do        func(e *testEnv, userID, toUserID int64, amount string) *httptest.ResponseRecorder
+}{
+	{
+		operation: models.LimitTransfer,
+		do: func(e *testEnv, userID, toUserID int64, amount string) *httptest.ResponseRecorder {
+			body := fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":%q}`, userID, toUserID, amount)
+			return e.do("POST", "/api/transfer", e.adminToken, body)
+		},
+	},
+	{
+		operation: models.LimitWithdrawal,
+		do: func(e *testEnv, userID, _ int64, amount string) *httptest.ResponseRecorder {
+			body := fmt.Sprintf(`{"amount":%q,"currency":"USD"}`, amount)
+			return e.do("POST", fmt.Sprintf("/api/users/%d/withdraw", userID), e.adminToken, body)
+		},
+	},
+}
+
+func TestSingleAmountLimit(t *testing.T) {
+	for _, op := range limitOperations {
+		t.Run(op.operation, func(t *testing.T) {
+			e := newTestEnv(t)
+			userID, _ := e.newUser(models.RoleUser)
+			other, _ := e.newUser(models.RoleUser)
+			e.credit(userID, "500.00", "USD")
+
+			rule := e.createTestLimitRule(fmt.Sprintf(
+				`{"kind":"max_single_amount","operation":%q,"role":"user","currency":"USD","max_amount":"50.00"}`,
+				op.operation))
+
+			e.expect(op.do(e, userID, other, "50.00"), http.StatusOK, nil)
+			e.expectLimitError(op.do(e, userID, other, "50.01"), limitSingleAmountExceeded, rule.ID)
+			e.expectBalance(userID, "USD", "450.00", "450.00")
+		})
+	}
+}
+
+func TestTotalAmountLimit(t *testing.T) {
+	for _, op := range limitOperations {
+		t.Run(op.operation, func(t *testing.T) {
+			e := newTestEnv(t)
+			userID, _ := e.newUser(models.RoleUser)
+			other, _ := e.newUser(models.RoleUser)
+			e.credit(userID, "500.00", "USD")
+
+			rule := e.createTestLimitRule(fmt.Sprintf(
+				`{"kind":"max_total_amount","operation":%q,"role":"user","currency":"USD","max_amount":"100.00","window_seconds":3600}`,
+				op.operation))
+
+			e.expect(op.do(e, userID, other, "60.00"), http.StatusOK, nil)
+			e.expect(op.do(e, userID, other, "40.00"), http.StatusOK, nil)
+			e.expectLimitError(op.do(e, userID, other, "0.01"), limitTotalAmountExceeded, rule.ID)
+			e.expectBalance(userID, "USD", "400.00", "400.00")
+		})
+	}
+}
+
+func TestCountLimit(t *testing.T) {
+	for _, op := range limitOperations {
+		t.Run(op.operation, func(t *testing.T) {
+			e := newTestEnv(t)
+			userID, _ := e.newUser(models.RoleUser)
+			other, _ := e.newUser(models.RoleUser)
+			e.credit(userID, "500.00", "USD")
+
+			rule := e.createTestLimitRule(fmt.Sprintf(
+				`{"kind":"max_count","operation":%q,"role":"user","max_count":2,"window_seconds":3600}`,
+				op.operation))
+
+			e.expect(op.do(e, userID, other, "1.00"), http.StatusOK, nil)
+			e.expect(op.do(e, userID, other, "1.00"), http.StatusOK, nil)
+			e.expectLimitError(op.do(e, userID, other, "1.00"), limitCountExceeded, rule.ID)
+			e.expectBalance(userID, "USD", "498.00", "498.00")
+		})
+	}
+}
+
+func TestUserLimitOverridesRoleLimit(t *testing.T) {
+	e := newTestEnv(t)
+	raised, _ := e.newUser(models.RoleUser)
+	lowered, _ := e.newUser(models.RoleUser)
+	other, _ := e.newUser(models.RoleUser)
+	for _, id := range []int64{raised, lowered, other} {
+		e.credit(id, "500.00", "USD")
+	}
+
+	roleRule := e.createTestLimitRule(
+		`{"kind":"max_single_amount","operation":"transfer","role":"user","currency":"USD","max_amount":"50.00"}`)
+	e.createTestLimitRule(fmt.Sprintf(
+		`{"kind":"max_single_amount","operation":"transfer","user_id":%d,"currency":"USD","max_amount":"200.00"}`, raised))
+	loweredRule := e.createTestLimitRule(fmt.Sprintf(
+		`{"kind":"max_single_amount","operation":"transfer","user_id":%d,"currency":"USD","max_amount":"10.00"}`, lowered))
+
+	transfer := limitOperations[0].do
+
+	// The user's own rule replaces the role's, whether it is looser or
+	// stricter
+	e.expect(transfer(e, raised, other, "150.00"), http.StatusOK, nil)
+	e.expectLimitError(transfer(e, lowered, other, "20.00"), limitSingleAmountExceeded, loweredRule.ID)
+
+	// Users without a rule of their own still get the role's
+	e.expectLimitError(transfer(e, other, raised, "150.00"), limitSingleAmountExceeded, roleRule.ID)
+}
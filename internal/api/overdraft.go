@@ -0,0 +1,147 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+// setOverdraftLimit sets the overdraft limit of one of a user's accounts and
+// records the change. Lowering a limit below what is already drawn is
+// allowed; the account then cannot be debited until it is back within it.
+func (s *Server) setOverdraftLimit(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.SetOverdraftLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	currency, ok := requestCurrency(req.Currency)
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	req.Limit.Currency = currency
+
+	if req.Limit.IsNegative() {
+		http.Error(w, "Limit must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	accountID, err := userAccountID(tx, userID, currency)
+	if err != nil {
+		writeAccountError(w, err, "User", currency)
+		return
+	}
+
+	// Lock the account so concurrent changes are recorded in order
+	if err = lockAccounts(tx, accountID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	oldLimit := models.Money{Currency: currency}
+	err = tx.QueryRow("SELECT overdraft_limit FROM accounts WHERE id = $1", accountID).Scan(&oldLimit)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = tx.Exec("UPDATE accounts SET overdraft_limit = $1 WHERE id = $2", req.Limit, accountID); err != nil {
+		http.Error(w, "Failed to set overdraft limit", http.StatusInternalServerError)
+		return
+	}
+
+	var changedBy interface{}
+	if id, _, err := actingUser(r); err == nil {
+		changedBy = id
+	}
+	_, err = tx.Exec(`
+		INSERT INTO overdraft_limit_changes (account_id, old_limit, new_limit, reason, changed_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)`,
+		accountID, oldLimit, req.Limit, req.Reason, changedBy)
+	if err != nil {
+		s.logger.Printf("Error recording overdraft limit change: %v", err)
+		http.Error(w, "Failed to record overdraft limit change", http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := currencyBalance(tx, accountID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(balance)
+}
+
+// listOverdraftLimitChanges returns the audit trail of a user's overdraft
+// limits across all their accounts, latest first.
+func (s *Server) listOverdraftLimitChanges(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT c.id, a.user_id, a.currency, c.old_limit, c.new_limit,
+			COALESCE(c.reason, ''), c.changed_by, c.created_at
+		FROM overdraft_limit_changes c
+		JOIN accounts a ON a.id = c.account_id
+		WHERE a.user_id = $1
+		ORDER BY c.created_at DESC, c.id DESC`, userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	changes := []models.OverdraftLimitChange{}
+	for rows.Next() {
+		var (
+			c         models.OverdraftLimitChange
+			changedBy sql.NullInt64
+		)
+		err := rows.Scan(&c.ID, &c.UserID, &c.Currency, &c.OldLimit, &c.NewLimit, &c.Reason, &changedBy, &c.CreatedAt)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		c.OldLimit.Currency = c.Currency
+		c.NewLimit.Currency = c.Currency
+		if changedBy.Valid {
+			c.ChangedBy = &changedBy.Int64
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// setTestOverdraftLimit sets the user's USD overdraft limit as the admin and
+// returns the balance it reports.
+func (e *testEnv) setTestOverdraftLimit(userID int64, limit, reason string) models.CurrencyBalance {
+	e.t.Helper()
+	var balance models.CurrencyBalance
+	body := fmt.Sprintf(`{"limit":%q,"currency":"USD","reason":%q}`, limit, reason)
+	e.expect(e.do("PUT", fmt.Sprintf("/api/users/%d/overdraft-limit", userID), e.adminToken, body), http.StatusOK, &balance)
+	return balance
+}
+
+// expectOverdraft fails the test unless the user's USD account has the given
+// available balance and overdraft left.
+func (e *testEnv) expectOverdraft(userID int64, available, left string) {
+	e.t.Helper()
+	b := e.balance(userID, "USD")
+	if b.Available.String() != available || b.OverdraftAvailable.String() != left {
+		e.t.Errorf("User %d: available %s, overdraft left %s; want %s, %s",
+			userID, b.Available, b.OverdraftAvailable, available, left)
+	}
+}
+
+func TestOverdraft(t *testing.T) {
+	for _, op := range limitOperations {
+		t.Run(op.operation, func(t *testing.T) {
+			e := newTestEnv(t)
+			userID, _ := e.newUser(models.RoleUser)
+			other, _ := e.newUser(models.RoleUser)
+			e.credit(userID, "10.00", "USD")
+
+			balance := e.setTestOverdraftLimit(userID, "50.00", "")
+			if balance.OverdraftLimit.String() != "50.00" || balance.OverdraftAvailable.String() != "50.00" {
+				t.Errorf("Expected a limit of 50.00 with all of it left, got %s with %s left",
+					balance.OverdraftLimit, balance.OverdraftAvailable)
+			}
+
+			e.expect(op.do(e, userID, other, "40.00"), http.StatusOK, nil)
+			e.expectOverdraft(userID, "-30.00", "20.00")
+
+			// One cent past the limit is refused and changes nothing
+			rr := op.do(e, userID, other, "20.01")
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Insufficient balance") {
+				t.Fatalf("Expected an insufficient balance, got %d: %s", rr.Code, rr.Body.String())
+			}
+			e.expectOverdraft(userID, "-30.00", "20.00")
+
+			e.expect(op.do(e, userID, other, "20.00"), http.StatusOK, nil)
+			e.expectOverdraft(userID, "-50.00", "0.00")
+		})
+	}
+}
+
+func TestOverdraftLimitChanges(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+
+	e.setTestOverdraftLimit(userID, "50.00", "Salary advance")
+	e.setTestOverdraftLimit(userID, "20.00", "")
+
+	var changes []models.OverdraftLimitChange
+	e.expect(e.do("GET", fmt.Sprintf("/api/users/%d/overdraft-limit/changes", userID), e.adminToken, ""), http.StatusOK, &changes)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d", len(changes))
+	}
+
+	tests := []struct {
+		oldLimit, newLimit, reason string
+	}{
+		{"50.00", "20.00", ""},
+		{"0.00", "50.00", "Salary advance"},
+	}
+	for i, tt := range tests {
+		c := changes[i]
+		if c.UserID != userID || c.Currency != "USD" {
+			t.Errorf("Change %d: expected user %d USD, got user %d %s", i, userID, c.UserID, c.Currency)
+		}
+		if c.OldLimit.String() != tt.oldLimit || c.NewLimit.String() != tt.newLimit || c.Reason != tt.reason {
+			t.Errorf("Change %d: got %s -> %s (%q), want %s -> %s (%q)",
+				i, c.OldLimit, c.NewLimit, c.Reason, tt.oldLimit, tt.newLimit, tt.reason)
+		}
+		if c.ChangedBy == nil || *c.ChangedBy != e.adminID {
+			t.Errorf("Change %d: expected to be made by admin %d, got %v", i, e.adminID, c.ChangedBy)
+		}
+		if c.CreatedAt.IsZero() {
+			t.Errorf("Change %d has no time", i)
+		}
+	}
+	if changes[0].CreatedAt.Before(changes[1].CreatedAt) {
+		t.Errorf("Expected the latest change first, got %v before %v", changes[0].CreatedAt, changes[1].CreatedAt)
+	}
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+// originalTransaction is the part of a transaction needed to compensate it.
+type originalTransaction struct {
+	id             int64
+	kind           string
+	fromUserID     sql.NullInt64
+	toUserID       int64
+	amount         models.Money
+	currency       string
+	targetAmount   models.Money
+	targetCurrency string
+}
+
+// lockOriginalTransaction loads a transaction for update so that concurrent
+// reversals and refunds of it are serialised.
+func lockOriginalTransaction(tx *sql.Tx, id int64) (originalTransaction, error) {
+	var (
+		orig           originalTransaction
+		targetAmount   sql.NullString
+		targetCurrency sql.NullString
+	)
+	err := tx.QueryRow(`
+		SELECT id, type, from_user_id, to_user_id, amount, currency, target_amount, target_currency
+		FROM transactions
+		WHERE id = $1
+		FOR UPDATE`, id).Scan(&orig.id, &orig.kind, &orig.fromUserID, &orig.toUserID,
+		&orig.amount, &orig.currency, &targetAmount, &targetCurrency)
+	if err != nil {
+		return orig, err
+	}
+
+	// Withdrawals and captures are stored as negative amounts; what can be
+	// compensated is the size of the movement.
+	if orig.amount.IsNegative() {
+		orig.amount = orig.amount.Neg()
+	}
+	orig.amount.Currency = orig.currency
+
+	// Transfers made before cross-currency support have no target columns.
+	orig.targetCurrency = orig.currency
+	orig.targetAmount = orig.amount
+	if targetAmount.Valid && targetCurrency.Valid {
+		orig.targetCurrency = targetCurrency.String
+		if orig.targetAmount, err = models.ParseMoney(targetAmount.String, orig.targetCurrency); err != nil {
+			return orig, err
+		}
+	}
+	return orig, nil
+}
+
+func (s *Server) reverseTransaction(w http.ResponseWriter, r *http.Request) {
+	var req models.ReverseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	s.compensate(w, r, models.TransactionReversal, nil, req.Reason)
+}
+
+func (s *Server) refundTransaction(w http.ResponseWriter, r *http.Request) {
+	var req models.RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+	if !req.Amount.IsPositive() {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+	s.compensate(w, r, models.TransactionRefund, &req.Amount, req.Reason)
+}
+
+// compensate books a reversal or refund of the transaction in the URL as a
+// new transaction with its own journal entry running the original in the
+// opposite direction. A reversal undoes whatever has not been refunded yet
+// and returns the fees charged on the original; a refund undoes amount,
+// which is in the original's source currency, and leaves the fees charged.
+func (s *Server) compensate(w http.ResponseWriter, r *http.Request, kind string, amount *models.Money, reason string) {
+	originalID, err := utils.GetIDFromPath(r, "txID")
+	if err != nil {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	orig, err := lockOriginalTransaction(tx, originalID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch orig.kind {
+	case models.TransactionCredit, models.TransactionTransfer,
+		models.TransactionWithdrawal, models.TransactionCapture:
+	default:
+		http.Error(w, "Only credits, transfers, withdrawals and captures can be compensated", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Work out how much of the original is still outstanding
+	var (
+		refunded       = models.NewMoney(0, orig.currency)
+		refundedTarget = models.NewMoney(0, orig.targetCurrency)
+		reversed       bool
+	)
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(ABS(amount)), 0),
+			COALESCE(SUM(ABS(COALESCE(target_amount, amount))), 0),
+			COALESCE(BOOL_OR(type = 'reversal'), false)
+		FROM transactions
+		WHERE original_transaction_id = $1`,
+		orig.id).Scan(&refunded, &refundedTarget, &reversed)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if reversed {
+		http.Error(w, "Transaction has already been reversed", http.StatusConflict)
+		return
+	}
+
+	remaining := orig.amount.Sub(refunded)
+	remainingTarget := orig.targetAmount.Sub(refundedTarget)
+	if !remaining.IsPositive() {
+		http.Error(w, "Transaction has already been fully refunded", http.StatusConflict)
+		return
+	}
+
+	source := remaining
+	target := remainingTarget
+	if amount != nil {
+		source = *amount
+		source.Currency = orig.currency
+		if remaining.LessThan(source) {
+			http.Error(w, "Refund exceeds the refundable amount of "+remaining.String(), http.StatusUnprocessableEntity)
+			return
+		}
+		// A partial refund takes the same share of the target side; the
+		// refund that completes the original takes whatever is left so that
+		// rounding never leaves a residue.
+		if source.LessThan(remaining) {
+			target = proportion(orig.targetAmount, source, orig.amount)
+		}
+	}
+
+	fee := models.NewMoney(0, orig.currency)
+	if kind == models.TransactionReversal {
+		if fee, err = chargedFees(tx, orig.id, orig.currency); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	postings, err := compensatingPostings(tx, orig, source, target, fee)
+	if err != nil {
+		var pe *partyError
+		switch {
+		case err == errInsufficientBalance:
+			writeFundsError(w, err)
+		case errors.As(err, &pe):
+			writeAccountError(w, pe.err, pe.subject, pe.currency)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Single-user rows carry the signed effect on the user, two-user rows
+	// swap the parties of the original.
+	var (
+		fromUserID interface{}
+		toUserID   = orig.toUserID
+		rowAmount  = source
+	)
+	switch orig.kind {
+	case models.TransactionCredit:
+		rowAmount = source.Neg()
+	case models.TransactionTransfer:
+		fromUserID = orig.toUserID
+		toUserID = orig.fromUserID.Int64
+	}
+
+	result := models.CompensationResult{
+		OriginalTransactionID: orig.id,
+		Type:                  kind,
+		Amount:                source,
+		Currency:              orig.currency,
+		TargetAmount:          target,
+		TargetCurrency:        orig.targetCurrency,
+		Refundable:            remaining.Sub(source),
+		ReturnedFee:           fee,
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO transactions (
+			from_user_id, to_user_id, amount, currency, type,
+			target_amount, target_currency, original_transaction_id
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		fromUserID, toUserID, rowAmount, orig.currency, kind,
+		target, orig.targetCurrency, orig.id).Scan(&result.TransactionID)
+	if err != nil {
+		s.logger.Printf("Error recording %s: %v", kind, err)
+		http.Error(w, "Failed to record transaction", http.StatusInternalServerError)
+		return
+	}
+
+	description := "Reversal of transaction"
+	if kind == models.TransactionRefund {
+		description = "Refund of transaction"
+	}
+	if reason != "" {
+		description += ": " + reason
+	}
+
+	if err = postEntry(tx, result.TransactionID, description, postings); err != nil {
+		http.Error(w, "Failed to post journal entry", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// compensatingPostings builds the journal entry that moves source (and, on
+// the recipient's side, target) back the way it came, and fee from the fees
+// account back to whoever paid it. The party giving the money back must
+// have the funds to do so, and both parties a status that lets the money
+// move, as for a transfer between them.
+func compensatingPostings(tx *sql.Tx, orig originalTransaction, source, target, fee models.Money) ([]posting, error) {
+	switch orig.kind {
+	case models.TransactionCredit:
+		accountID, err := movableAccountID(tx, orig.toUserID, orig.currency, true)
+		if err != nil {
+			return nil, &partyError{subject: "User", currency: orig.currency, err: err}
+		}
+		settlementID, err := systemAccountID(tx, settlementAccount, orig.currency)
+		if err != nil {
+			return nil, err
+		}
+		if err = lockAccounts(tx, accountID, settlementID); err != nil {
+			return nil, err
+		}
+		if err = ensureFunds(tx, accountID, source); err != nil {
+			return nil, err
+		}
+		return []posting{
+			{accountID: accountID, amount: source.Neg()},
+			{accountID: settlementID, amount: source},
+		}, nil
+
+	case models.TransactionWithdrawal, models.TransactionCapture:
+		accountID, err := movableAccountID(tx, orig.toUserID, orig.currency, false)
+		if err != nil {
+			return nil, &partyError{subject: "User", currency: orig.currency, err: err}
+		}
+		settlementID, err := systemAccountID(tx, settlementAccount, orig.currency)
+		if err != nil {
+			return nil, err
+		}
+		postings := []posting{
+			{accountID: settlementID, amount: source.Neg()},
+			{accountID: accountID, amount: source},
+		}
+		return appendFeeReturn(tx, postings, accountID, fee)
+	}
+
+	// Transfer: the recipient pays back target, the sender gets source
+	recipientID, err := movableAccountID(tx, orig.toUserID, orig.targetCurrency, true)
+	if err != nil {
+		return nil, &partyError{subject: "Recipient", currency: orig.targetCurrency, err: err}
+	}
+	senderID, err := movableAccountID(tx, orig.fromUserID.Int64, orig.currency, false)
+	if err != nil {
+		return nil, &partyError{subject: "Sender", currency: orig.currency, err: err}
+	}
+
+	postings := []posting{{accountID: recipientID, amount: target.Neg()}}
+	if orig.targetCurrency != orig.currency {
+		fxTargetID, err := systemAccountID(tx, fxConversionAccount, orig.targetCurrency)
+		if err != nil {
+			return nil, err
+		}
+		fxSourceID, err := systemAccountID(tx, fxConversionAccount, orig.currency)
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings,
+			posting{accountID: fxTargetID, amount: target},
+			posting{accountID: fxSourceID, amount: source.Neg()},
+		)
+	}
+	postings = append(postings, posting{accountID: senderID, amount: source})
+	if postings, err = appendFeeReturn(tx, postings, senderID, fee); err != nil {
+		return nil, err
+	}
+
+	if err = lockAccounts(tx, postingAccountIDs(postings)...); err != nil {
+		return nil, err
+	}
+	if err = ensureFunds(tx, recipientID, target); err != nil {
+		return nil, err
+	}
+	return postings, nil
+}
+
+// chargedFees returns the fees charged on a transaction, which its journal
+// entry posted to the fees account in currency.
+func chargedFees(tx *sql.Tx, transactionID int64, currency string) (models.Money, error) {
+	fees := models.NewMoney(0, currency)
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(p.amount), 0)
+		FROM postings p
+		JOIN journal_entries e ON e.id = p.entry_id
+		JOIN accounts a ON a.id = p.account_id
+		WHERE e.transaction_id = $1 AND a.code = $2 AND a.currency = $3`,
+		transactionID, feesAccount, currency).Scan(&fees)
+	return fees, err
+}
+
+// appendFeeReturn adds the legs moving fee from the fees account back to
+// the payer's account, if there is a fee to return.
+func appendFeeReturn(tx *sql.Tx, postings []posting, payerAccountID int64, fee models.Money) ([]posting, error) {
+	if !fee.IsPositive() {
+		return postings, nil
+	}
+	feesAccountID, err := systemAccountID(tx, feesAccount, fee.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return append(postings,
+		posting{accountID: feesAccountID, amount: fee.Neg()},
+		posting{accountID: payerAccountID, amount: fee},
+	), nil
+}
+
+// proportion returns total * part / whole, rounded half away from zero, in
+// total's currency.
+func proportion(total, part, whole models.Money) models.Money {
+	ratio := new(big.Rat).SetFrac64(part.Units, whole.Units)
+	// part never exceeds whole, so the result cannot outgrow total
+	result, _ := total.Convert(ratio, total.Currency)
+	return result
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// compensate reverses the transaction, or refunds amount of it when amount
+// is not empty, and expects status.
+func (e *testEnv) compensate(transactionID int64, amount string, status int) models.CompensationResult {
+	e.t.Helper()
+	var result models.CompensationResult
+	path := fmt.Sprintf("/api/transactions/%d/reverse", transactionID)
+	body := ""
+	if amount != "" {
+		path = fmt.Sprintf("/api/transactions/%d/refund", transactionID)
+		body = fmt.Sprintf(`{"amount":%q,"reason":"test"}`, amount)
+	}
+	rr := e.do("POST", path, e.adminToken, body)
+	if status != http.StatusCreated {
+		e.expect(rr, status, nil)
+		return result
+	}
+	e.expect(rr, status, &result)
+	return result
+}
+
+func TestReverseTransfer(t *testing.T) {
+	e := newTestEnv(t)
+	sender, _ := e.newUser(models.RoleUser)
+	recipient, _ := e.newUser(models.RoleUser)
+	e.credit(sender, "100.00", "USD")
+
+	transfer := e.transfer(sender, recipient, "40.00")
+	result := e.compensate(transfer.TransactionID, "", http.StatusCreated)
+	if result.Amount.String() != "40.00" || !result.Refundable.IsZero() {
+		t.Errorf("Expected 40.00 reversed with nothing left, got %s, %s left", result.Amount, result.Refundable)
+	}
+	e.expectBalance(sender, "USD", "100.00", "100.00")
+	e.expectBalance(recipient, "USD", "0.00", "0.00")
+
+	// Nothing is left to reverse or refund
+	e.compensate(transfer.TransactionID, "", http.StatusConflict)
+	e.compensate(transfer.TransactionID, "1.00", http.StatusConflict)
+	e.compensate(result.TransactionID, "", http.StatusUnprocessableEntity)
+}
+
+func TestRefundTransfer(t *testing.T) {
+	e := newTestEnv(t)
+	sender, _ := e.newUser(models.RoleUser)
+	recipient, _ := e.newUser(models.RoleUser)
+	e.credit(sender, "100.00", "USD")
+
+	transfer := e.transfer(sender, recipient, "50.00")
+
+	result := e.compensate(transfer.TransactionID, "20.00", http.StatusCreated)
+	if result.Refundable.String() != "30.00" {
+		t.Errorf("Expected 30.00 left to refund, got %s", result.Refundable)
+	}
+	e.expectBalance(sender, "USD", "70.00", "70.00")
+	e.expectBalance(recipient, "USD", "30.00", "30.00")
+
+	// Refunds together never exceed the original
+	e.compensate(transfer.TransactionID, "30.01", http.StatusUnprocessableEntity)
+	result = e.compensate(transfer.TransactionID, "30.00", http.StatusCreated)
+	if !result.Refundable.IsZero() {
+		t.Errorf("Expected nothing left to refund, got %s", result.Refundable)
+	}
+	e.compensate(transfer.TransactionID, "0.01", http.StatusConflict)
+	e.compensate(transfer.TransactionID, "", http.StatusConflict)
+
+	e.expectBalance(sender, "USD", "100.00", "100.00")
+	e.expectBalance(recipient, "USD", "0.00", "0.00")
+}
+
+func TestRefundRecipientWithoutFunds(t *testing.T) {
+	e := newTestEnv(t)
+	sender, _ := e.newUser(models.RoleUser)
+	recipient, _ := e.newUser(models.RoleUser)
+	other, _ := e.newUser(models.RoleUser)
+	e.credit(sender, "50.00", "USD")
+
+	transfer := e.transfer(sender, recipient, "50.00")
+	e.transfer(recipient, other, "45.00")
+
+	e.compensate(transfer.TransactionID, "", http.StatusBadRequest)
+	e.compensate(transfer.TransactionID, "5.00", http.StatusCreated)
+}
+
+func TestReverseFXTransfer(t *testing.T) {
+	e := newTestEnv(t)
+	sender, _ := e.newUser(models.RoleUser)
+	recipient, _ := e.newUser(models.RoleUser)
+	e.openAccount(recipient, "EUR")
+	e.credit(sender, "100.00", "USD")
+	e.expect(e.do("PUT", "/api/fx-rates", e.adminToken, `{"base":"USD","quote":"EUR","rate":"0.9"}`), http.StatusCreated, nil)
+
+	var transfer models.TransferResult
+	body := fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":"10.00","to_currency":"EUR"}`, sender, recipient)
+	e.expect(e.do("POST", "/api/transfer", e.adminToken, body), http.StatusOK, &transfer)
+	if transfer.TargetAmount.String() != "9.00" {
+		t.Fatalf("Expected 9.00 EUR, got %s", transfer.TargetAmount)
+	}
+
+	// A partial refund takes the same share of the target side
+	refund := e.compensate(transfer.TransactionID, "5.00", http.StatusCreated)
+	if refund.TargetAmount.String() != "4.50" || refund.TargetCurrency != "EUR" {
+		t.Errorf("Expected 4.50 EUR refunded, got %s %s", refund.TargetAmount, refund.TargetCurrency)
+	}
+
+	// The reversal takes whatever is left, on both sides
+	reversal := e.compensate(transfer.TransactionID, "", http.StatusCreated)
+	if reversal.Amount.String() != "5.00" || reversal.TargetAmount.String() != "4.50" {
+		t.Errorf("Expected 5.00 USD / 4.50 EUR reversed, got %s / %s", reversal.Amount, reversal.TargetAmount)
+	}
+
+	e.expectBalance(sender, "USD", "100.00", "100.00")
+	e.expectBalance(recipient, "EUR", "0.00", "0.00")
+}
+
+func TestReversalReturnsFees(t *testing.T) {
+	e := newTestEnv(t)
+	sender, _ := e.newUser(models.RoleUser)
+	recipient, _ := e.newUser(models.RoleUser)
+	e.credit(sender, "100.00", "USD")
+	e.expect(e.do("POST", "/api/fee-schedules", e.adminToken,
+		`{"name":"Transfer fee","operation":"transfer","currency":"USD","kind":"flat","flat":"1.00"}`),
+		http.StatusCreated, nil)
+
+	transfer := e.transfer(sender, recipient, "10.00")
+	if transfer.TotalFee.String() != "1.00" {
+		t.Fatalf("Expected a fee of 1.00, got %s", transfer.TotalFee)
+	}
+	e.expectBalance(sender, "USD", "89.00", "89.00")
+
+	// Refunds leave the fee charged
+	refund := e.compensate(transfer.TransactionID, "4.00", http.StatusCreated)
+	if !refund.ReturnedFee.IsZero() {
+		t.Errorf("Expected no fee returned on a refund, got %s", refund.ReturnedFee)
+	}
+	e.expectBalance(sender, "USD", "93.00", "93.00")
+
+	// A reversal gives it back
+	reversal := e.compensate(transfer.TransactionID, "", http.StatusCreated)
+	if reversal.ReturnedFee.String() != "1.00" {
+		t.Errorf("Expected 1.00 fee returned, got %s", reversal.ReturnedFee)
+	}
+	e.expectBalance(sender, "USD", "100.00", "100.00")
+	e.expectBalance(recipient, "USD", "0.00", "0.00")
+}
+
+func TestReverseWithdrawal(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+	e.credit(userID, "100.00", "USD")
+	e.expect(e.do("POST", "/api/fee-schedules", e.adminToken,
+		`{"name":"Withdrawal fee","operation":"withdrawal","currency":"USD","kind":"flat","flat":"2.00"}`),
+		http.StatusCreated, nil)
+
+	var withdrawal models.WithdrawResult
+	rr := e.do("POST", fmt.Sprintf("/api/users/%d/withdraw", userID), e.adminToken, `{"amount":"30.00"}`)
+	e.expect(rr, http.StatusOK, &withdrawal)
+	e.expectBalance(userID, "USD", "68.00", "68.00")
+
+	reversal := e.compensate(withdrawal.TransactionID, "", http.StatusCreated)
+	if reversal.Amount.String() != "30.00" || reversal.ReturnedFee.String() != "2.00" {
+		t.Errorf("Expected 30.00 reversed and 2.00 returned, got %s and %s", reversal.Amount, reversal.ReturnedFee)
+	}
+	e.expectBalance(userID, "USD", "100.00", "100.00")
+}
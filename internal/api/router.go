@@ -2,8 +2,7 @@ package api
 
 import (
 	"ledger/internal/middleware"
-
-	"net/http"
+	"ledger/internal/models"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -11,41 +10,98 @@ import (
 func (s *Server) RegisterRoutes() *chi.Mux {
 	r := chi.NewRouter()
 
+	// Money-moving routes replay their first response for a repeated
+	// Idempotency-Key instead of moving the money again
+	idempotent := middleware.Idempotency(s.db)
+
 	// Public routes (no auth required)
 	r.Post("/api/login", s.login)
 	r.Post("/api/users", s.createUser) // Allow signup without auth
+	r.Post("/api/token/refresh", s.refreshSession)
+	r.Get("/.well-known/jwks.json", s.jwks)
+
+	authenticate := middleware.AuthMiddleware(s.tokens, s.denylist, s.apiKeys)
+
+	// Routes API keys may call, with the scope each needs. The scope has to
+	// be set before authentication, which is why these sit outside the
+	// protected group; API keys are refused everywhere else.
+	r.With(middleware.Scope(models.ScopeBalancesRead), authenticate, middleware.OwnerOrAdmin).
+		Get("/api/users/{id}/balance", s.getUserBalance)
+	r.With(middleware.Scope(models.ScopeBalancesRead), authenticate, middleware.AdminOnly).
+		Get("/api/users/balances", s.getAllBalances)
+	r.With(middleware.Scope(models.ScopeCreditsWrite), authenticate, middleware.AdminOnly, idempotent).
+		Post("/api/users/{id}/credit", s.addCredit)
+	// Transfers check ownership of the paying account themselves
+	r.With(middleware.Scope(models.ScopeTransfersWrite), authenticate, idempotent).
+		Post("/api/transfer", s.transfer)
 
 	// Protected routes
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware)
+		r.Use(authenticate)
+
+		r.Post("/api/logout", s.logout)
 
 		// User routes (protected by OwnerOrAdmin)
 		r.Group(func(r chi.Router) {
 			r.Use(middleware.OwnerOrAdmin)
-			r.Get("/api/users/{id}/balance", s.getUserBalance)
-			r.Post("/api/users/{id}/withdraw", s.withdrawCredit)
+			r.With(idempotent).Post("/api/users/{id}/withdraw", s.withdrawCredit)
 			r.Get("/api/users/{id}/balance-at-time", s.getBalanceAtTime)
+			r.Post("/api/users/{id}/accounts", s.openAccount)
+			r.Get("/api/users/{id}/holds", s.listHolds)
+			r.Get("/api/users/{id}/transactions", s.getTransactions)
+			r.Get("/api/users/{id}/statements", s.getStatement)
+			r.Get("/api/users/{id}/scheduled-transfers", s.listScheduledTransfers)
 		})
 
 		// Admin routes
 		r.Group(func(r chi.Router) {
 			r.Use(middleware.AdminOnly)
-			r.Get("/api/users/balances", s.getAllBalances)
-			r.Post("/api/users/{id}/credit", s.addCredit)
+			r.Get("/api/ledger/verify", s.verifyLedger)
+			r.Post("/api/admin/users", s.createUser)
+			r.Put("/api/fx-rates", s.setFXRate)
+			r.With(idempotent).Post("/api/users/{id}/holds", s.createHold)
+			r.With(idempotent).Post("/api/holds/{holdID}/capture", s.captureHold)
+			r.Post("/api/holds/{holdID}/void", s.voidHold)
+			r.With(idempotent).Post("/api/transactions/{txID}/reverse", s.reverseTransaction)
+			r.With(idempotent).Post("/api/transactions/{txID}/refund", s.refundTransaction)
+			r.Post("/api/users/{id}/freeze", s.freezeUser)
+			r.Post("/api/users/{id}/unfreeze", s.unfreezeUser)
+			r.Post("/api/users/{id}/close", s.closeUser)
+			r.Get("/api/users/{id}/status-changes", s.listUserStatusChanges)
+			r.Post("/api/users/{id}/sessions/revoke", s.revokeUserSessions)
+			r.Put("/api/users/{id}/overdraft-limit", s.setOverdraftLimit)
+			r.Get("/api/users/{id}/overdraft-limit/changes", s.listOverdraftLimitChanges)
+			r.Get("/api/limit-rules", s.listLimitRules)
+			r.Post("/api/limit-rules", s.createLimitRule)
+			r.Get("/api/limit-rules/{ruleID}", s.getLimitRule)
+			r.Put("/api/limit-rules/{ruleID}", s.updateLimitRule)
+			r.Delete("/api/limit-rules/{ruleID}", s.deleteLimitRule)
+			r.Get("/api/fee-schedules", s.listFeeSchedules)
+			r.Post("/api/fee-schedules", s.createFeeSchedule)
+			r.Get("/api/fee-schedules/{feeID}", s.getFeeSchedule)
+			r.Put("/api/fee-schedules/{feeID}", s.updateFeeSchedule)
+			r.Delete("/api/fee-schedules/{feeID}", s.deleteFeeSchedule)
+			r.Put("/api/interest-rates", s.setInterestRate)
+			r.Get("/api/interest-rates", s.listInterestRates)
+			r.Get("/api/approvals", s.listApprovals)
+			r.With(idempotent).Post("/api/approvals/{approvalID}/approve", s.approveTransfer)
+			r.Post("/api/approvals/{approvalID}/reject", s.rejectTransfer)
+			r.Get("/api/api-keys", s.listAPIKeys)
+			r.Post("/api/api-keys", s.createAPIKey)
+			r.Post("/api/api-keys/{keyID}/revoke", s.revokeAPIKey)
 		})
 
-		// Transfer requires special handling
-		r.Post("/api/transfer", func(w http.ResponseWriter, r *http.Request) {
-			s.transfer(w, r)
-		})
+		r.Get("/api/fx-rates", s.listFXRates)
+		r.Get("/api/fees/preview", s.previewFee)
 
-		// Protected routes
-		r.Get("/api/users/{id}/balance", func(w http.ResponseWriter, r *http.Request) {
-			s.getUserBalance(w, r)
-		})
-		r.Post("/api/users/{id}/credit", func(w http.ResponseWriter, r *http.Request) {
-			s.addCredit(w, r)
-		})
+		r.With(idempotent).Post("/api/transfers/batch", s.batchTransfer)
+
+		// Scheduled transfers check ownership of the paying account themselves
+		r.With(idempotent).Post("/api/scheduled-transfers", s.createScheduledTransfer)
+		r.Get("/api/scheduled-transfers/{scheduleID}/runs", s.listScheduledTransferRuns)
+		r.Post("/api/scheduled-transfers/{scheduleID}/pause", s.pauseScheduledTransfer)
+		r.Post("/api/scheduled-transfers/{scheduleID}/resume", s.resumeScheduledTransfer)
+		r.Post("/api/scheduled-transfers/{scheduleID}/cancel", s.cancelScheduledTransfer)
 	})
 
 	return r
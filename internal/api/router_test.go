@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// noDenylist revokes nothing, keeping the unreachable database out of
+// authentication.
+type noDenylist struct{}
+
+func (noDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, nil
+}
+
+// staticAPIKeys knows a fixed set of API keys, keeping the unreachable
+// database out of authentication.
+type staticAPIKeys map[string]*models.APIKey
+
+func (k staticAPIKeys) LookupAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	return k[key], nil
+}
+
+// TestRouteAccess checks which roles, and which API keys, get past the auth
+// policies of each route. The database is unreachable, so a request that
+// gets through fails later with something other than 401 or 403.
+func TestRouteAccess(t *testing.T) {
+	db, err := sql.Open("postgres", "host=/nonexistent dbname=ledger_db sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	tokens := testTokens(t)
+	apiKeys := staticAPIKeys{
+		"lk_reader": {ID: 1, CreatedBy: 1, Scopes: []string{models.ScopeBalancesRead}},
+		"lk_writer": {ID: 2, CreatedBy: 1, Scopes: []string{models.ScopeCreditsWrite, models.ScopeTransfersWrite}},
+	}
+	s := &Server{db: db, logger: log.New(io.Discard, "", 0), tokens: tokens, denylist: noDenylist{}, apiKeys: apiKeys}
+	router := s.RegisterRoutes()
+
+	token := func(userID int64, role string) string {
+		tok, _, err := tokens.Issue(userID, role)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		return tok
+	}
+	bearer := map[string]string{
+		"anonymous": "",
+		"owner":     token(7, models.RoleUser),
+		"other":     token(8, models.RoleUser),
+		"admin":     token(1, models.RoleAdmin),
+	}
+	apiKey := map[string]string{
+		"reader": "lk_reader",
+		"writer": "lk_writer",
+	}
+
+	const (
+		denied       = "denied"
+		unauthorized = "unauthorized"
+		allowed      = "allowed"
+	)
+	tests := []struct {
+		method, path, body string
+		want               map[string]string
+	}{
+		{"GET", "/api/users/7/balance", "", map[string]string{
+			"anonymous": unauthorized, "owner": allowed, "other": denied, "admin": allowed, "reader": allowed, "writer": denied}},
+		{"POST", "/api/users/7/withdraw", `{"amount":"1.00"}`, map[string]string{
+			"anonymous": unauthorized, "owner": allowed, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"GET", "/api/users/7/transactions", "", map[string]string{
+			"anonymous": unauthorized, "owner": allowed, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"POST", "/api/users/7/credit", `{"amount":"1.00"}`, map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": denied, "writer": allowed}},
+		{"GET", "/api/users/balances", "", map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": allowed, "writer": denied}},
+		{"GET", "/api/ledger/verify", "", map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"POST", "/api/users/7/freeze", `{"reason":"test"}`, map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"POST", "/api/users/7/sessions/revoke", "", map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"POST", "/api/logout", "", map[string]string{
+			"anonymous": unauthorized, "owner": allowed, "other": allowed, "admin": allowed, "reader": denied, "writer": denied}},
+		{"GET", "/api/approvals", "", map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"POST", "/api/transfer", `{"from_user_id":7,"to_user_id":8,"amount":"1.00"}`, map[string]string{
+			"anonymous": unauthorized, "owner": allowed, "other": denied, "admin": allowed, "reader": denied, "writer": allowed}},
+		{"GET", "/.well-known/jwks.json", "", map[string]string{
+			"anonymous": allowed, "owner": allowed, "other": allowed, "admin": allowed, "reader": allowed, "writer": allowed}},
+		{"POST", "/api/api-keys", `{"name":"test","scopes":["balances:read"]}`, map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"POST", "/api/users", `{"name":"Test","email":"test@example.com","password":"secret"}`, map[string]string{
+			"anonymous": allowed, "owner": allowed, "other": allowed, "admin": allowed, "reader": allowed, "writer": allowed}},
+		// The public signup never authenticates, so not even an admin's
+		// token lets it create an admin
+		{"POST", "/api/users", `{"name":"Test","email":"test@example.com","password":"secret","role":"admin"}`, map[string]string{
+			"anonymous": denied, "owner": denied, "other": denied, "admin": denied, "reader": denied, "writer": denied}},
+		{"POST", "/api/admin/users", `{"name":"Test","email":"test@example.com","password":"secret","role":"admin"}`, map[string]string{
+			"anonymous": unauthorized, "owner": denied, "other": denied, "admin": allowed, "reader": denied, "writer": denied}},
+		{"GET", "/api/fx-rates", "", map[string]string{
+			"anonymous": unauthorized, "owner": allowed, "other": allowed, "admin": allowed, "reader": denied, "writer": denied}},
+	}
+
+	for _, tt := range tests {
+		for role, want := range tt.want {
+			t.Run(role+" "+tt.method+" "+tt.path, func(t *testing.T) {
+				req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+				if bearer[role] != "" {
+					req.Header.Set("Authorization", "Bearer "+bearer[role])
+				}
+				if apiKey[role] != "" {
+					req.Header.Set("X-API-Key", apiKey[role])
+				}
+				// Headers a client might forge to pose as an admin
+				req.Header.Set("user_id", "1")
+				req.Header.Set("user_role", "admin")
+
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+
+				var got string
+				switch rr.Code {
+				case http.StatusUnauthorized:
+					got = unauthorized
+				case http.StatusForbidden:
+					got = denied
+				default:
+					got = allowed
+				}
+				if got != want {
+					t.Errorf("got %s (status %d: %s), want %s", got, rr.Code, strings.TrimSpace(rr.Body.String()), want)
+				}
+			})
+		}
+	}
+}
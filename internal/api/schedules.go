@@ -0,0 +1,545 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+const scheduleColumns = `
+	st.id, st.from_user_id, st.to_user_id, st.amount, st.currency, st.to_currency, st.frequency,
+	st.day_of_month, st.status, st.next_run_at, st.last_run_at, st.created_by, st.created_at, st.updated_at`
+
+func scanSchedule(row rowScanner) (models.ScheduledTransfer, error) {
+	var (
+		st         models.ScheduledTransfer
+		dayOfMonth sql.NullInt64
+		nextRunAt  sql.NullTime
+		lastRunAt  sql.NullTime
+		createdBy  sql.NullInt64
+	)
+	err := row.Scan(&st.ID, &st.FromUserID, &st.ToUserID, &st.Amount, &st.Currency, &st.ToCurrency,
+		&st.Frequency, &dayOfMonth, &st.Status, &nextRunAt, &lastRunAt, &createdBy, &st.CreatedAt, &st.UpdatedAt)
+	if err != nil {
+		return st, err
+	}
+
+	st.Amount.Currency = st.Currency
+	if dayOfMonth.Valid {
+		day := int(dayOfMonth.Int64)
+		st.DayOfMonth = &day
+	}
+	if nextRunAt.Valid {
+		st.NextRunAt = &nextRunAt.Time
+	}
+	if lastRunAt.Valid {
+		st.LastRunAt = &lastRunAt.Time
+	}
+	if createdBy.Valid {
+		st.CreatedBy = &createdBy.Int64
+	}
+	return st, nil
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// nextRunAfter returns the first run of st after now that follows its
+// current one, or the zero time if it has none. Runs missed while the
+// server was down or the schedule was paused are skipped, not made up.
+func nextRunAfter(st models.ScheduledTransfer, now time.Time) time.Time {
+	var day int
+	if st.DayOfMonth != nil {
+		day = *st.DayOfMonth
+	}
+	next := models.NextRun(st.Frequency, day, *st.NextRunAt)
+	for !next.IsZero() && !next.After(now) {
+		next = models.NextRun(st.Frequency, day, next)
+	}
+	return next
+}
+
+// createScheduledTransfer schedules a transfer from the caller's account,
+// or from any account for admins.
+func (s *Server) createScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateScheduledTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	currency, ok := requestCurrency(req.Currency)
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	req.Amount.Currency = currency
+
+	toCurrency := currency
+	if req.ToCurrency != "" {
+		if toCurrency, ok = requestCurrency(req.ToCurrency); !ok {
+			http.Error(w, "Unsupported currency", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !req.Amount.IsPositive() {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	// Schedules are meant to run unattended, so they may not start out
+	// needing an approver
+	approval, err := needsApproval(req.Amount)
+	if err != nil {
+		s.logger.Printf("Error reading approval threshold: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if approval {
+		http.Error(w, "Transfers above the approval threshold cannot be scheduled", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if req.StartAt.IsZero() {
+		http.Error(w, "start_at is required", http.StatusBadRequest)
+		return
+	}
+	if !req.StartAt.After(time.Now()) {
+		http.Error(w, "start_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	startAt := req.StartAt.UTC()
+
+	var dayOfMonth *int
+	firstRun := startAt
+	switch req.Frequency {
+	case models.FrequencyOnce, models.FrequencyDaily, models.FrequencyWeekly:
+		if req.DayOfMonth != 0 {
+			http.Error(w, "day_of_month only applies to monthly schedules", http.StatusBadRequest)
+			return
+		}
+	case models.FrequencyMonthly:
+		day := req.DayOfMonth
+		if day == 0 {
+			day = startAt.Day()
+		}
+		if day < 1 || day > 31 {
+			http.Error(w, "day_of_month must be between 1 and 31", http.StatusBadRequest)
+			return
+		}
+		dayOfMonth = &day
+		firstRun = models.FirstRun(req.Frequency, day, startAt)
+	default:
+		http.Error(w, "frequency must be once, daily, weekly or monthly", http.StatusBadRequest)
+		return
+	}
+
+	tokenUserID, isAdmin, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !isAdmin && tokenUserID != req.FromUserID {
+		http.Error(w, "Unauthorized to transfer from this account", http.StatusForbidden)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Catch missing accounts now rather than on the first run
+	if _, err = movableAccountID(tx, req.FromUserID, currency, true); err != nil {
+		writeAccountError(w, err, "Sender", currency)
+		return
+	}
+	if _, err = movableAccountID(tx, req.ToUserID, toCurrency, false); err != nil {
+		writeAccountError(w, err, "Recipient", toCurrency)
+		return
+	}
+
+	st, err := scanSchedule(tx.QueryRow(`
+		INSERT INTO scheduled_transfers AS st (
+			from_user_id, to_user_id, amount, currency, to_currency,
+			frequency, day_of_month, next_run_at, created_by
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+scheduleColumns,
+		req.FromUserID, req.ToUserID, req.Amount, currency, toCurrency,
+		req.Frequency, dayOfMonth, firstRun, tokenUserID))
+	if err != nil {
+		s.logger.Printf("Error creating scheduled transfer: %v", err)
+		http.Error(w, "Failed to create scheduled transfer", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(st)
+}
+
+// listScheduledTransfers returns the schedules paying out of a user's
+// accounts.
+func (s *Server) listScheduledTransfers(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+scheduleColumns+`
+		FROM scheduled_transfers st
+		WHERE st.from_user_id = $1
+		ORDER BY st.id`, userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	schedules := []models.ScheduledTransfer{}
+	for rows.Next() {
+		st, err := scanSchedule(rows)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		schedules = append(schedules, st)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// loadSchedule loads the schedule in the URL, locking it when tx is used
+// to change it, and checks that the caller may manage it. It writes the
+// error response and returns false if not.
+func loadSchedule(w http.ResponseWriter, r *http.Request, q queryRower, forUpdate bool) (models.ScheduledTransfer, bool) {
+	scheduleID, err := utils.GetIDFromPath(r, "scheduleID")
+	if err != nil {
+		http.Error(w, "Invalid scheduled transfer ID", http.StatusBadRequest)
+		return models.ScheduledTransfer{}, false
+	}
+
+	query := `SELECT ` + scheduleColumns + ` FROM scheduled_transfers st WHERE st.id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	st, err := scanSchedule(q.QueryRow(query, scheduleID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Scheduled transfer not found", http.StatusNotFound)
+		return st, false
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return st, false
+	}
+
+	tokenUserID, isAdmin, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return st, false
+	}
+	if !isAdmin && tokenUserID != st.FromUserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return st, false
+	}
+	return st, true
+}
+
+// listScheduledTransferRuns returns the runs of a schedule, latest first.
+func (s *Server) listScheduledTransferRuns(w http.ResponseWriter, r *http.Request) {
+	st, ok := loadSchedule(w, r, s.db, false)
+	if !ok {
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, schedule_id, scheduled_for, status, transaction_id, approval_id, COALESCE(message, ''), created_at
+		FROM scheduled_transfer_runs
+		WHERE schedule_id = $1
+		ORDER BY scheduled_for DESC`, st.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	runs := []models.ScheduledTransferRun{}
+	for rows.Next() {
+		var (
+			run           models.ScheduledTransferRun
+			transactionID sql.NullInt64
+			approvalID    sql.NullInt64
+		)
+		err := rows.Scan(&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.Status, &transactionID, &approvalID,
+			&run.Message, &run.CreatedAt)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if transactionID.Valid {
+			run.TransactionID = &transactionID.Int64
+		}
+		if approvalID.Valid {
+			run.ApprovalID = &approvalID.Int64
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func (s *Server) pauseScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+	s.setScheduleStatus(w, r, models.SchedulePaused)
+}
+
+func (s *Server) resumeScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+	s.setScheduleStatus(w, r, models.ScheduleActive)
+}
+
+func (s *Server) cancelScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+	s.setScheduleStatus(w, r, models.ScheduleCancelled)
+}
+
+// setScheduleStatus pauses, resumes or cancels the schedule in the URL.
+// Active schedules can be paused, paused ones resumed, and either
+// cancelled; cancelling is final.
+func (s *Server) setScheduleStatus(w http.ResponseWriter, r *http.Request, status string) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	st, ok := loadSchedule(w, r, tx, true)
+	if !ok {
+		return
+	}
+
+	// Pausing needs an active schedule and resuming a paused one
+	valid := st.Status == models.ScheduleActive || st.Status == models.SchedulePaused
+	if status != models.ScheduleCancelled && st.Status == status {
+		valid = false
+	}
+	if !valid {
+		http.Error(w, "Scheduled transfer is "+st.Status, http.StatusConflict)
+		return
+	}
+
+	// A resumed schedule picks up at its next run that is still to come
+	nextRunAt := st.NextRunAt
+	if status == models.ScheduleCancelled {
+		nextRunAt = nil
+	} else if status == models.ScheduleActive && st.Frequency != models.FrequencyOnce {
+		if now := time.Now().UTC(); !nextRunAt.After(now) {
+			next := nextRunAfter(st, now)
+			nextRunAt = &next
+		}
+	}
+
+	st, err = scanSchedule(tx.QueryRow(`
+		UPDATE scheduled_transfers AS st
+		SET status = $2, next_run_at = $3, updated_at = NOW()
+		WHERE st.id = $1
+		RETURNING `+scheduleColumns,
+		st.ID, status, nextRunAt))
+	if err != nil {
+		http.Error(w, "Failed to update scheduled transfer", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// runNextScheduledTransfer makes the earliest due run of any active
+// schedule and reports whether there was one. The transfer, the record of
+// the run and the move to the next run commit together, so a run is never
+// made twice. Schedules locked by another worker are skipped.
+func (s *Server) runNextScheduledTransfer(now time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	st, err := scanSchedule(tx.QueryRow(`
+		SELECT `+scheduleColumns+`
+		FROM scheduled_transfers st
+		WHERE st.status = 'active' AND st.next_run_at <= $1
+		ORDER BY st.next_run_at, st.id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`, now))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	run := models.ScheduledTransferRun{ScheduleID: st.ID, ScheduledFor: *st.NextRunAt, Status: models.RunSucceeded}
+
+	// A rejected transfer is rolled back on its own so that the failed run
+	// can still be recorded
+	if _, err = tx.Exec("SAVEPOINT scheduled_transfer"); err != nil {
+		return false, err
+	}
+	err = creatorMayDebit(tx, st)
+	var approve bool
+	if err == nil {
+		approve, err = needsApproval(st.Amount)
+	}
+	switch {
+	case err != nil:
+	case approve:
+		// The threshold may have been lowered since the schedule was made;
+		// the run then waits for an approver like any other transfer
+		var approval models.TransferApproval
+		req := models.TransferRequest{FromUserID: st.FromUserID, ToUserID: st.ToUserID, Amount: st.Amount}
+		if approval, err = insertApproval(tx, req, st.ToCurrency, *st.CreatedBy); err == nil {
+			run.Status = models.RunPendingApproval
+			run.ApprovalID = &approval.ID
+		}
+	default:
+		var result models.TransferResult
+		if result, err = transferTx(tx, st.FromUserID, st.ToUserID, st.Amount, st.ToCurrency, nil); err == nil {
+			run.TransactionID = &result.TransactionID
+		}
+	}
+
+	var (
+		pe *partyError
+		le *limitError
+	)
+	switch {
+	case err == nil:
+	case err == errInsufficientBalance:
+		run.Status = models.RunInsufficientBalance
+	case errors.As(err, &pe), errors.As(err, &le), err == errNoFXRate, err == errAmountTooSmall,
+		err == errCreatorCannotDebit:
+		run.Status = models.RunFailed
+	case isRetryable(err):
+		// Leave the run due, to be retried on the next tick
+		return false, err
+	default:
+		// Anything else fails the run too, or a schedule that can never
+		// run would hold up every one due after it
+		s.logger.Printf("Scheduled transfer %d failed: %v", st.ID, err)
+		run.Status = models.RunFailed
+	}
+	if err != nil {
+		run.Message = err.Error()
+		if _, err = tx.Exec("ROLLBACK TO SAVEPOINT scheduled_transfer"); err != nil {
+			return false, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO scheduled_transfer_runs (schedule_id, scheduled_for, status, transaction_id, approval_id, message)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))`,
+		run.ScheduleID, run.ScheduledFor, run.Status, run.TransactionID, run.ApprovalID, run.Message)
+	if err != nil {
+		return false, err
+	}
+
+	var nextRunAt *time.Time
+	status := models.ScheduleCompleted
+	if next := nextRunAfter(st, now); !next.IsZero() {
+		nextRunAt = &next
+		status = models.ScheduleActive
+	}
+	_, err = tx.Exec(`
+		UPDATE scheduled_transfers
+		SET status = $2, next_run_at = $3, last_run_at = $4, updated_at = NOW()
+		WHERE id = $1`,
+		st.ID, status, nextRunAt, run.ScheduledFor)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+var errCreatorCannotDebit = errors.New("the schedule's creator may no longer transfer from this account")
+
+// creatorMayDebit fails with errCreatorCannotDebit unless whoever created
+// the schedule could still create it: the owner of the paying account, or
+// an active admin. Runs act on the creator's authority, which may have been
+// taken away since.
+func creatorMayDebit(tx *sql.Tx, st models.ScheduledTransfer) error {
+	if st.CreatedBy == nil {
+		return errCreatorCannotDebit
+	}
+	if *st.CreatedBy == st.FromUserID {
+		return nil
+	}
+
+	var role, status string
+	err := tx.QueryRow("SELECT role, status FROM users WHERE id = $1", *st.CreatedBy).Scan(&role, &status)
+	if err == sql.ErrNoRows {
+		return errCreatorCannotDebit
+	}
+	if err != nil {
+		return err
+	}
+	if role != models.RoleAdmin || status != models.UserActive {
+		return errCreatorCannotDebit
+	}
+	return nil
+}
+
+// cancelUserSchedules cancels the schedules paying from or to a user, which
+// could never run again once the user is closed.
+func cancelUserSchedules(tx *sql.Tx, userID int64) error {
+	_, err := tx.Exec(`
+		UPDATE scheduled_transfers
+		SET status = 'cancelled', next_run_at = NULL, updated_at = NOW()
+		WHERE (from_user_id = $1 OR to_user_id = $1)
+		AND status IN ('active', 'paused')`, userID)
+	return err
+}
+
+// scheduledTransferJob makes every scheduled transfer that is due.
+func (s *Server) scheduledTransferJob(ctx context.Context) error {
+	for ctx.Err() == nil {
+		ran, err := s.runNextScheduledTransfer(time.Now().UTC())
+		if err != nil || !ran {
+			return err
+		}
+	}
+	return nil
+}
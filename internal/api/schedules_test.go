@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ledger/internal/models"
+)
+
+// createTestSchedule schedules a transfer of amount USD as the admin and
+// makes its first run due at dueAt.
+func (e *testEnv) createTestSchedule(fromUserID, toUserID int64, amount, frequency string, dueAt time.Time) models.ScheduledTransfer {
+	e.t.Helper()
+	return e.createTestScheduleAs(e.adminToken, fromUserID, toUserID, amount, frequency, dueAt)
+}
+
+// createTestScheduleAs is createTestSchedule as the holder of token.
+func (e *testEnv) createTestScheduleAs(token string, fromUserID, toUserID int64, amount, frequency string, dueAt time.Time) models.ScheduledTransfer {
+	e.t.Helper()
+	var st models.ScheduledTransfer
+	body := fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":%q,"frequency":%q,"start_at":%q}`,
+		fromUserID, toUserID, amount, frequency, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	e.expect(e.do("POST", "/api/scheduled-transfers", token, body), http.StatusCreated, &st)
+
+	if _, err := e.db.Exec("UPDATE scheduled_transfers SET next_run_at = $2 WHERE id = $1", st.ID, dueAt.UTC()); err != nil {
+		e.t.Fatalf("Failed to make schedule %d due: %v", st.ID, err)
+	}
+	return st
+}
+
+// scheduleRuns returns the runs of a schedule, latest first.
+func (e *testEnv) scheduleRuns(scheduleID int64) []models.ScheduledTransferRun {
+	e.t.Helper()
+	var runs []models.ScheduledTransferRun
+	e.expect(e.do("GET", fmt.Sprintf("/api/scheduled-transfers/%d/runs", scheduleID), e.adminToken, ""), http.StatusOK, &runs)
+	return runs
+}
+
+func TestMonthlyScheduleFirstRun(t *testing.T) {
+	e := newTestEnv(t)
+	from, _ := e.newUser(models.RoleUser)
+	to, _ := e.newUser(models.RoleUser)
+
+	year := time.Now().Year() + 1
+	start := time.Date(year, time.January, 10, 9, 0, 0, 0, time.UTC)
+	body := fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":"5.00","frequency":"monthly","day_of_month":15,"start_at":%q}`,
+		from, to, start.Format(time.RFC3339))
+
+	var st models.ScheduledTransfer
+	e.expect(e.do("POST", "/api/scheduled-transfers", e.adminToken, body), http.StatusCreated, &st)
+	if want := time.Date(year, time.January, 15, 9, 0, 0, 0, time.UTC); st.NextRunAt == nil || !st.NextRunAt.Equal(want) {
+		t.Errorf("Expected the first run on %v, got %v", want, st.NextRunAt)
+	}
+}
+
+func TestFailingScheduleDoesNotBlockOthers(t *testing.T) {
+	e := newTestEnv(t)
+	from, _ := e.newUser(models.RoleUser)
+	to, _ := e.newUser(models.RoleUser)
+	e.credit(from, "100.00", "USD")
+
+	// Reject every transaction of 13.13 the way an unexpected database
+	// error would
+	_, err := e.db.Exec(`
+		CREATE OR REPLACE FUNCTION fail_test_transfer() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'transfer rejected by test';
+		END
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS fail_test_transfer ON transactions;
+		CREATE TRIGGER fail_test_transfer BEFORE INSERT ON transactions
+		FOR EACH ROW WHEN (NEW.amount = 13.13) EXECUTE FUNCTION fail_test_transfer();`)
+	if err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+	t.Cleanup(func() {
+		e.db.Exec("DROP TRIGGER IF EXISTS fail_test_transfer ON transactions; DROP FUNCTION IF EXISTS fail_test_transfer()")
+	})
+
+	now := time.Now()
+	bad := e.createTestSchedule(from, to, "13.13", models.FrequencyDaily, now.Add(-2*time.Minute))
+	good := e.createTestSchedule(from, to, "10.00", models.FrequencyOnce, now.Add(-time.Minute))
+
+	if err := e.server.scheduledTransferJob(context.Background()); err != nil {
+		t.Fatalf("scheduledTransferJob: %v", err)
+	}
+
+	runs := e.scheduleRuns(bad.ID)
+	if len(runs) != 1 || runs[0].Status != models.RunFailed || !strings.Contains(runs[0].Message, "transfer rejected by test") {
+		t.Fatalf("Expected one failed run of the failing schedule, got %+v", runs)
+	}
+
+	st, err := scanSchedule(e.db.QueryRow("SELECT "+scheduleColumns+" FROM scheduled_transfers st WHERE st.id = $1", bad.ID))
+	if err != nil {
+		t.Fatalf("Failed to load schedule: %v", err)
+	}
+	if st.Status != models.ScheduleActive || st.NextRunAt == nil || !st.NextRunAt.After(now) {
+		t.Errorf("Expected the failing schedule to move on to a later run, got %s at %v", st.Status, st.NextRunAt)
+	}
+
+	runs = e.scheduleRuns(good.ID)
+	if len(runs) != 1 || runs[0].Status != models.RunSucceeded {
+		t.Errorf("Expected the schedule due after it to run, got %+v", runs)
+	}
+	e.expectBalance(from, "USD", "90.00", "90.00")
+	e.expectBalance(to, "USD", "10.00", "10.00")
+}
+
+func TestScheduleAboveLoweredThresholdAwaitsApproval(t *testing.T) {
+	e := newTestEnv(t)
+	from, _ := e.newUser(models.RoleUser)
+	to, _ := e.newUser(models.RoleUser)
+	e.credit(from, "200.00", "USD")
+
+	st := e.createTestSchedule(from, to, "150.00", models.FrequencyOnce, time.Now().Add(-time.Minute))
+
+	// The threshold comes in below the amount after the schedule was made
+	t.Setenv("TRANSFER_APPROVAL_THRESHOLD", "100.00")
+	if err := e.server.scheduledTransferJob(context.Background()); err != nil {
+		t.Fatalf("scheduledTransferJob: %v", err)
+	}
+
+	runs := e.scheduleRuns(st.ID)
+	if len(runs) != 1 || runs[0].Status != models.RunPendingApproval || runs[0].ApprovalID == nil {
+		t.Fatalf("Expected one run pending approval, got %+v", runs)
+	}
+	if !hasApproval(e.approvals(models.ApprovalPending), *runs[0].ApprovalID) {
+		t.Errorf("Expected approval %d to be pending", *runs[0].ApprovalID)
+	}
+	e.expectBalance(from, "USD", "200.00", "200.00")
+	e.expectBalance(to, "USD", "0.00", "0.00")
+}
+
+func TestScheduleRunsOnItsCreatorsAuthority(t *testing.T) {
+	e := newTestEnv(t)
+	from, fromToken := e.newUser(models.RoleUser)
+	to, _ := e.newUser(models.RoleUser)
+	admin, adminToken := e.newUser(models.RoleAdmin)
+	e.credit(from, "100.00", "USD")
+
+	now := time.Now()
+	byOwner := e.createTestScheduleAs(fromToken, from, to, "10.00", models.FrequencyOnce, now.Add(-2*time.Minute))
+	byAdmin := e.createTestScheduleAs(adminToken, from, to, "20.00", models.FrequencyOnce, now.Add(-time.Minute))
+	if byAdmin.CreatedBy == nil || *byAdmin.CreatedBy != admin {
+		t.Fatalf("Expected schedule %d to be created by %d, got %v", byAdmin.ID, admin, byAdmin.CreatedBy)
+	}
+
+	// The admin who made the second schedule is no longer one when it runs
+	if _, err := e.db.Exec("UPDATE users SET role = $2 WHERE id = $1", admin, models.RoleUser); err != nil {
+		t.Fatalf("Failed to demote admin: %v", err)
+	}
+	if err := e.server.scheduledTransferJob(context.Background()); err != nil {
+		t.Fatalf("scheduledTransferJob: %v", err)
+	}
+
+	if runs := e.scheduleRuns(byOwner.ID); len(runs) != 1 || runs[0].Status != models.RunSucceeded {
+		t.Errorf("Expected the owner's schedule to run, got %+v", runs)
+	}
+	runs := e.scheduleRuns(byAdmin.ID)
+	if len(runs) != 1 || runs[0].Status != models.RunFailed || runs[0].Message != errCreatorCannotDebit.Error() {
+		t.Errorf("Expected the demoted admin's schedule to fail, got %+v", runs)
+	}
+	e.expectBalance(from, "USD", "90.00", "90.00")
+	e.expectBalance(to, "USD", "10.00", "10.00")
+}
@@ -4,26 +4,31 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
+	"ledger/internal/auth"
+	"ledger/internal/db"
+	"ledger/internal/jobs"
+	"ledger/internal/middleware"
 	"ledger/internal/models"
 	"ledger/internal/utils"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Server struct {
-	db     *sql.DB
-	router *chi.Mux
-	logger *log.Logger
+	db       *sql.DB
+	router   *chi.Mux
+	logger   *log.Logger
+	tokens   *auth.TokenService
+	denylist middleware.Denylist
+	apiKeys  middleware.APIKeyStore
 }
 
 // CreateUserRequest represents the request body for creating a user
@@ -34,16 +39,19 @@ type CreateUserRequest struct {
 	Role     string `json:"role"`
 }
 
-func NewServer(db *sql.DB, logger *log.Logger) *Server {
+func NewServer(db *sql.DB, logger *log.Logger, tokens *auth.TokenService) *Server {
 	if err := db.Ping(); err != nil {
 		logger.Printf("Database connection error: %v", err)
 		return nil
 	}
 	logger.Printf("Successfully connected to database")
 	return &Server{
-		db:     db,
-		router: chi.NewRouter(),
-		logger: logger,
+		db:       db,
+		router:   chi.NewRouter(),
+		logger:   logger,
+		tokens:   tokens,
+		denylist: middleware.SQLDenylist(db),
+		apiKeys:  middleware.SQLAPIKeys(db, logger),
 	}
 }
 
@@ -53,6 +61,25 @@ func (s *Server) Start(addr string) error {
 	return http.ListenAndServe(addr, s.router)
 }
 
+// Jobs returns the background work the server relies on, to be run next to
+// it by a jobs.Runner.
+func (s *Server) Jobs() ([]jobs.Job, error) {
+	snapshotInterval, err := db.SnapshotInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	return []jobs.Job{
+		{Name: "expire-holds", Interval: time.Minute, Run: s.expireHoldsJob},
+		{Name: "balance-snapshots", Interval: time.Minute, Run: s.balanceSnapshotJob(snapshotInterval)},
+		{Name: "scheduled-transfers", Interval: time.Minute, Run: s.scheduledTransferJob},
+		{Name: "expire-approvals", Interval: time.Minute, Run: s.expireApprovalsJob},
+		{Name: "interest-accrual", Interval: time.Hour, Run: s.interestAccrualJob},
+		{Name: "interest-posting", Interval: time.Hour, Run: s.interestPostingJob},
+		{Name: "purge-tokens", Interval: time.Hour, Run: s.purgeTokensJob},
+	}, nil
+}
+
 func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	// Read and log the request body
 	body, err := io.ReadAll(r.Body)
@@ -92,6 +119,15 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Anyone can sign up, but only an admin, through the admin route, can
+	// create another admin
+	if req.Role == models.RoleAdmin {
+		if _, isAdmin, err := actingUser(r); err != nil || !isAdmin {
+			http.Error(w, "Only admins can create admin users", http.StatusForbidden)
+			return
+		}
+	}
+
 	s.logger.Printf("Attempting to create user: name=%s, email=%s, role=%s", req.Name, req.Email, req.Role)
 
 	// Hash password
@@ -113,8 +149,8 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 
 	// Insert user
 	query := `
-		INSERT INTO users (name, email, password_hash, role, balance)
-		VALUES ($1, $2, $3, $4, 0)
+		INSERT INTO users (name, email, password_hash, role)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id`
 
 	var userID int
@@ -129,6 +165,13 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Open the user's ledger account in the default currency
+	if _, err = tx.Exec("INSERT INTO accounts (user_id, currency) VALUES ($1, $2)", userID, models.DefaultCurrency); err != nil {
+		s.logger.Printf("Error creating account: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		s.logger.Printf("Error committing transaction: %v", err)
@@ -153,33 +196,74 @@ func (s *Server) addCredit(w http.ResponseWriter, r *http.Request) {
 
 	var req models.AddCreditRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
 		return
 	}
 
-	if req.Amount <= 0 {
+	currency, ok := requestCurrency(req.Currency)
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	req.Amount.Currency = currency
+
+	if !req.Amount.IsPositive() {
 		http.Error(w, "Amount must be positive", http.StatusBadRequest)
 		return
 	}
 
-	query := `
-		UPDATE users 
-		SET balance = balance + $1 
-		WHERE id = $2 
-		RETURNING balance`
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
-	var newBalance float64
-	err = s.db.QueryRow(query, req.Amount, userID).Scan(&newBalance)
+	accountID, err := movableAccountID(tx, userID, currency, false)
+	if err != nil {
+		writeAccountError(w, err, "User", currency)
+		return
+	}
+
+	settlementID, err := systemAccountID(tx, settlementAccount, currency)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "User not found", http.StatusNotFound)
-			return
-		}
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]float64{"balance": newBalance})
+	// Record the transaction
+	var transactionID int64
+	err = tx.QueryRow(`
+		INSERT INTO transactions (to_user_id, amount, currency, type)
+		VALUES ($1, $2, $3, 'credit')
+		RETURNING id`,
+		userID, req.Amount, currency).Scan(&transactionID)
+	if err != nil {
+		http.Error(w, "Failed to record transaction", http.StatusInternalServerError)
+		return
+	}
+
+	err = postEntry(tx, transactionID, "Credit", []posting{
+		{accountID: accountID, amount: req.Amount},
+		{accountID: settlementID, amount: req.Amount.Neg()},
+	})
+	if err != nil {
+		http.Error(w, "Failed to post journal entry", http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := currencyBalance(tx, accountID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(balance)
 }
 
 func (s *Server) getUserBalance(w http.ResponseWriter, r *http.Request) {
@@ -189,124 +273,294 @@ func (s *Server) getUserBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var balance float64
-	err = s.db.QueryRow("SELECT balance FROM users WHERE id = $1", userID).Scan(&balance)
+	rows, err := s.db.Query(`
+		SELECT u.id, u.name, a.currency, a.balance, `+availableBalanceSQL+`, a.overdraft_limit
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id
+		WHERE u.id = $1
+		ORDER BY a.currency`, userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	balances, err := scanUserBalances(rows)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "User not found", http.StatusNotFound)
-			return
-		}
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]float64{"balance": balance})
+	if len(balances) == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(balances[0])
 }
 
 func (s *Server) getAllBalances(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.Query("SELECT id, name, balance FROM users")
+	rows, err := s.db.Query(`
+		SELECT u.id, u.name, a.currency, a.balance, ` + availableBalanceSQL + `, a.overdraft_limit
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id
+		ORDER BY u.id, a.currency`)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
+	balances, err := scanUserBalances(rows)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(balances)
+}
+
+// scanUserBalances groups rows of (user id, name, currency, posted balance,
+// available balance, overdraft limit), ordered by user, into one UserBalance per user. Users
+// without any account are returned with an empty list of balances.
+func scanUserBalances(rows *sql.Rows) ([]models.UserBalance, error) {
 	var balances []models.UserBalance
 	for rows.Next() {
-		var balance models.UserBalance
-		if err := rows.Scan(&balance.UserID, &balance.Name, &balance.Balance); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+		var (
+			userID    int64
+			name      string
+			currency  sql.NullString
+			posted    models.Money
+			available models.Money
+			limit     models.Money
+		)
+		if err := rows.Scan(&userID, &name, &currency, &posted, &available, &limit); err != nil {
+			return nil, err
 		}
-		balances = append(balances, balance)
-	}
 
-	json.NewEncoder(w).Encode(balances)
+		if len(balances) == 0 || balances[len(balances)-1].UserID != userID {
+			balances = append(balances, models.UserBalance{
+				UserID:   userID,
+				Name:     name,
+				Balances: []models.CurrencyBalance{},
+			})
+		}
+
+		if currency.Valid {
+			last := &balances[len(balances)-1]
+			last.Balances = append(last.Balances, newCurrencyBalance(currency.String, posted, available, limit))
+		}
+	}
+	return balances, rows.Err()
 }
 
 func (s *Server) transfer(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		FromUserID int     `json:"from_user_id"`
-		ToUserID   int     `json:"to_user_id"`
-		Amount     float64 `json:"amount"`
+	var req models.TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	currency, ok := requestCurrency(req.Currency)
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
 		return
 	}
+	req.Amount.Currency = currency
 
-	// Get user_id from token
-	userID := r.Header.Get("user_id")
-	userRole := r.Header.Get("user_role")
+	toCurrency := currency
+	if req.ToCurrency != "" {
+		if toCurrency, ok = requestCurrency(req.ToCurrency); !ok {
+			http.Error(w, "Unsupported currency", http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Convert userID from string to int
-	tokenUserID, err := strconv.Atoi(userID)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	if !req.Amount.IsPositive() {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
 		return
 	}
 
 	// Check if user has permission to transfer
-	if userRole != "admin" && tokenUserID != req.FromUserID {
+	tokenUserID, isAdmin, err := actingUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !isAdmin && tokenUserID != req.FromUserID {
 		http.Error(w, "Unauthorized to transfer from this account", http.StatusForbidden)
 		return
 	}
 
-	// Start a transaction
-	tx, err := s.db.Begin()
+	// Large transfers wait for a second admin instead of executing now
+	approval, err := needsApproval(req.Amount)
 	if err != nil {
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		s.logger.Printf("Error reading approval threshold: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if approval {
+		s.requestApproval(w, req, toCurrency, tokenUserID)
 		return
 	}
-	defer tx.Rollback()
 
-	// Check if from_user has sufficient balance
-	var fromUserBalance float64
-	err = tx.QueryRow("SELECT balance FROM users WHERE id = $1", req.FromUserID).Scan(&fromUserBalance)
+	var result models.TransferResult
+	err = s.inTx(func(tx *sql.Tx) error {
+		var err error
+		result, err = transferTx(tx, req.FromUserID, req.ToUserID, req.Amount, toCurrency, nil)
+		return err
+	})
 	if err != nil {
-		http.Error(w, "Failed to get user balance", http.StatusInternalServerError)
+		if status, _ := transferErrorStatus(err, currency, toCurrency); status == http.StatusInternalServerError {
+			s.logger.Printf("Error making transfer: %v", err)
+		}
+		writeTransferError(w, err, currency, toCurrency)
 		return
 	}
 
-	if fromUserBalance < req.Amount {
-		http.Error(w, "Insufficient balance", http.StatusBadRequest)
-		return
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// transferTx moves amount from one user to another within tx, crediting the
+// recipient in toCurrency. It is the core of every transfer, whether made
+// directly, by a schedule or as part of a batch identified by batchID.
+// The sender pays the transfer fees on top of amount. Transfers it rejects
+// leave the ledger untouched.
+func transferTx(tx *sql.Tx, fromUserID, toUserID int64, amount models.Money, toCurrency string, batchID *int64) (models.TransferResult, error) {
+	currency := amount.Currency
+	result := models.TransferResult{
+		Message:        "Transfer successful",
+		Amount:         amount,
+		Currency:       currency,
+		TargetAmount:   amount,
+		TargetCurrency: toCurrency,
+		TotalFee:       models.NewMoney(0, currency),
+	}
+
+	fromAccountID, err := movableAccountID(tx, fromUserID, currency, true)
+	if err != nil {
+		return result, &partyError{subject: "Sender", currency: currency, err: err}
 	}
 
-	// Update balances
-	_, err = tx.Exec("UPDATE users SET balance = balance - $1 WHERE id = $2", req.Amount, req.FromUserID)
+	toAccountID, err := movableAccountID(tx, toUserID, toCurrency, false)
 	if err != nil {
-		http.Error(w, "Failed to update from_user balance", http.StatusInternalServerError)
-		return
+		return result, &partyError{subject: "Recipient", currency: toCurrency, err: err}
 	}
 
-	_, err = tx.Exec("UPDATE users SET balance = balance + $1 WHERE id = $2", req.Amount, req.ToUserID)
+	// Move the money between the two accounts. A cross-currency transfer
+	// goes through the FX conversion accounts so that every currency in the
+	// entry still balances on its own.
+	postings := []posting{
+		{accountID: fromAccountID, amount: amount.Neg()},
+	}
+	var fxRate *string
+	if toCurrency != currency {
+		rate, stored, err := latestRate(tx, currency, toCurrency)
+		if err != nil {
+			return result, err
+		}
+
+		if result.TargetAmount, err = amount.Convert(rate, toCurrency); err != nil {
+			return result, err
+		}
+		result.FXRate = stored
+		fxRate = &stored
+		if !result.TargetAmount.IsPositive() {
+			return result, errAmountTooSmall
+		}
+
+		fxSourceID, err := systemAccountID(tx, fxConversionAccount, currency)
+		if err != nil {
+			return result, err
+		}
+		fxTargetID, err := systemAccountID(tx, fxConversionAccount, toCurrency)
+		if err != nil {
+			return result, err
+		}
+		postings = append(postings,
+			posting{accountID: fxSourceID, amount: amount},
+			posting{accountID: fxTargetID, amount: result.TargetAmount.Neg()},
+		)
+	}
+	postings = append(postings, posting{accountID: toAccountID, amount: result.TargetAmount})
+
+	if result.Fees, result.TotalFee, err = quoteFees(tx, models.FeeTransfer, amount); err != nil {
+		return result, err
+	}
+	fees, err := feePostings(tx, fromAccountID, result.Fees)
 	if err != nil {
-		http.Error(w, "Failed to update to_user balance", http.StatusInternalServerError)
-		return
+		return result, err
+	}
+	postings = append(postings, fees...)
+
+	if err = lockAccounts(tx, postingAccountIDs(postings)...); err != nil {
+		return result, err
+	}
+
+	if err = checkLimits(tx, fromUserID, models.LimitTransfer, amount); err != nil {
+		return result, err
+	}
+
+	// Check if from_user has sufficient balance for the amount and its fees
+	if err = ensureFunds(tx, fromAccountID, amount.Add(result.TotalFee)); err != nil {
+		return result, err
 	}
 
 	// Record the transaction
-	_, err = tx.Exec(`
-		INSERT INTO transactions (from_user_id, to_user_id, amount, type)
-		VALUES ($1, $2, $3, 'transfer')
-	`, req.FromUserID, req.ToUserID, req.Amount)
+	err = tx.QueryRow(`
+		INSERT INTO transactions (
+			from_user_id, to_user_id, amount, currency, type,
+			fx_rate, target_amount, target_currency, batch_id
+		)
+		VALUES ($1, $2, $3, $4, 'transfer', $5, $6, $7, $8)
+		RETURNING id`,
+		fromUserID, toUserID, amount, currency,
+		fxRate, result.TargetAmount, toCurrency, batchID).Scan(&result.TransactionID)
 	if err != nil {
-		http.Error(w, "Failed to record transaction", http.StatusInternalServerError)
-		return
+		return result, err
 	}
 
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+	if err = postEntry(tx, result.TransactionID, "Transfer", postings); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// writeTransferError responds to a failed transferTx.
+func writeTransferError(w http.ResponseWriter, err error, currency, toCurrency string) {
+	var le *limitError
+	if errors.As(err, &le) {
+		writeLimitError(w, le)
 		return
 	}
+	status, message := transferErrorStatus(err, currency, toCurrency)
+	http.Error(w, message, status)
+}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Transfer successful",
-	})
+// transferErrorStatus maps an error from transferTx to a response status
+// and message.
+func transferErrorStatus(err error, currency, toCurrency string) (int, string) {
+	var (
+		pe *partyError
+		le *limitError
+	)
+	switch {
+	case errors.As(err, &pe):
+		return accountErrorStatus(pe.err, pe.subject, pe.currency)
+	case errors.As(err, &le):
+		return http.StatusUnprocessableEntity, le.Message
+	case err == errInsufficientBalance:
+		return http.StatusBadRequest, "Insufficient balance"
+	case err == errNoFXRate:
+		return http.StatusUnprocessableEntity, "No FX rate for " + currency + "/" + toCurrency
+	case err == errAmountTooSmall:
+		return http.StatusUnprocessableEntity, "Amount too small to convert"
+	case err == models.ErrAmountRange:
+		return http.StatusUnprocessableEntity, "Converted amount out of range"
+	default:
+		return http.StatusInternalServerError, "Failed to record transfer"
+	}
 }
 
 func (s *Server) withdrawCredit(w http.ResponseWriter, r *http.Request) {
@@ -317,11 +571,18 @@ func (s *Server) withdrawCredit(w http.ResponseWriter, r *http.Request) {
 	}
 	var req models.WithdrawRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		http.Error(w, invalidBodyMessage(err), http.StatusBadRequest)
 		return
 	}
 
-	if req.Amount <= 0 {
+	currency, ok := requestCurrency(req.Currency)
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	req.Amount.Currency = currency
+
+	if !req.Amount.IsPositive() {
 		http.Error(w, "Amount must be positive", http.StatusBadRequest)
 		return
 	}
@@ -333,42 +594,85 @@ func (s *Server) withdrawCredit(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Check balance
-	var balance float64
-	err = tx.QueryRow("SELECT balance FROM users WHERE id = $1", userID).Scan(&balance)
+	accountID, err := movableAccountID(tx, userID, currency, true)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeAccountError(w, err, "User", currency)
 		return
 	}
 
-	if balance < req.Amount {
-		http.Error(w, "Insufficient balance", http.StatusBadRequest)
+	settlementID, err := systemAccountID(tx, settlementAccount, currency)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Update balance
-	_, err = tx.Exec("UPDATE users SET balance = balance - $1 WHERE id = $2", req.Amount, userID)
+	fees, totalFee, err := quoteFees(tx, models.FeeWithdrawal, req.Amount)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	feeLegs, err := feePostings(tx, accountID, fees)
 	if err != nil {
-		http.Error(w, "Failed to update balance", http.StatusInternalServerError)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	postings := append([]posting{
+		{accountID: accountID, amount: req.Amount.Neg()},
+		{accountID: settlementID, amount: req.Amount},
+	}, feeLegs...)
+
+	if err = lockAccounts(tx, postingAccountIDs(postings)...); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = checkLimits(tx, userID, models.LimitWithdrawal, req.Amount)
+	if le, ok := err.(*limitError); ok {
+		writeLimitError(w, le)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Check balance, fees included
+	if err = ensureFunds(tx, accountID, req.Amount.Add(totalFee)); err != nil {
+		writeFundsError(w, err)
 		return
 	}
 
 	// Record withdrawal transaction
-	_, err = tx.Exec(`
-		INSERT INTO transactions (to_user_id, amount, type)
-		VALUES ($1, $2, 'withdrawal')`,
-		userID, -req.Amount)
+	var transactionID int64
+	err = tx.QueryRow(`
+		INSERT INTO transactions (to_user_id, amount, currency, type)
+		VALUES ($1, $2, $3, 'withdrawal')
+		RETURNING id`,
+		userID, req.Amount.Neg(), currency).Scan(&transactionID)
 	if err != nil {
 		http.Error(w, "Failed to record transaction", http.StatusInternalServerError)
 		return
 	}
 
+	if err = postEntry(tx, transactionID, "Withdrawal", postings); err != nil {
+		http.Error(w, "Failed to post journal entry", http.StatusInternalServerError)
+		return
+	}
+
 	if err = tx.Commit(); err != nil {
 		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.WithdrawResult{
+		TransactionID: transactionID,
+		Amount:        req.Amount,
+		Currency:      currency,
+		Fees:          fees,
+		TotalFee:      totalFee,
+	})
 }
 
 func (s *Server) getBalanceAtTime(w http.ResponseWriter, r *http.Request) {
@@ -389,37 +693,36 @@ func (s *Server) getBalanceAtTime(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get current balance and subtract all transactions after the specified time
+	currency, ok := requestCurrency(r.URL.Query().Get("currency"))
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+
 	query := `
-		SELECT COALESCE(
-			(SELECT balance + (
-				SELECT COALESCE(SUM(CASE 
-					WHEN type = 'withdrawal' THEN amount
-					WHEN from_user_id = $1 THEN -amount
-					WHEN to_user_id = $1 THEN amount
-					ELSE 0
-				END), 0)
-				FROM transactions 
-				WHERE (from_user_id = $1 OR to_user_id = $1)
-				AND created_at > $2
-			)
-			FROM users WHERE id = $1),
-			0
-		) as balance_at_time`
-
-	var balanceAtTime float64
-	err = s.db.QueryRow(query, userID, parsedTime).Scan(&balanceAtTime)
+		SELECT ` + balanceAtSQL("$2", true) + ` AS balance_at_time
+		FROM accounts a
+		WHERE a.user_id = $1 AND a.currency = $3`
+
+	balanceAtTime := models.Money{Currency: currency}
+	err = s.db.QueryRow(query, userID, parsedTime, currency).Scan(&balanceAtTime)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Account not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Failed to get balance", http.StatusInternalServerError)
 		return
 	}
 
 	response := struct {
-		UserID    int64     `json:"user_id"`
-		Balance   float64   `json:"balance"`
-		Timestamp time.Time `json:"timestamp"`
+		UserID    int64        `json:"user_id"`
+		Currency  string       `json:"currency"`
+		Balance   models.Money `json:"balance"`
+		Timestamp time.Time    `json:"timestamp"`
 	}{
 		UserID:    userID,
+		Currency:  currency,
 		Balance:   balanceAtTime,
 		Timestamp: parsedTime,
 	}
@@ -441,12 +744,13 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 
 	// Get user from database
 	var user struct {
-		ID           int
+		ID           int64
 		PasswordHash string
 		Role         string
+		Status       string
 	}
-	err := s.db.QueryRow("SELECT id, password_hash, role FROM users WHERE email = $1",
-		req.Email).Scan(&user.ID, &user.PasswordHash, &user.Role)
+	err := s.db.QueryRow("SELECT id, password_hash, role, status FROM users WHERE email = $1",
+		req.Email).Scan(&user.ID, &user.PasswordHash, &user.Role, &user.Status)
 	if err != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
@@ -458,20 +762,43 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"role":    user.Role,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
+	// Only tell the owner, once the password checked out
+	if user.Status == models.UserClosed {
+		http.Error(w, "Account is closed", http.StatusForbidden)
+		return
+	}
 
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	resp, err := s.issueSession(s.db, user.ID, user.Role, user.Status, "")
 	if err != nil {
+		s.logger.Printf("Error issuing tokens for user %d: %v", user.ID, err)
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": tokenString,
-	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+var errUnauthenticated = errors.New("request is not authenticated")
+
+// actingUser returns the authenticated user and whether they are an admin,
+// from the claims AuthMiddleware verified.
+func actingUser(r *http.Request) (int64, bool, error) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		return 0, false, errUnauthenticated
+	}
+	return claims.UserID, claims.Role == models.RoleAdmin, nil
+}
+
+// invalidBodyMessage describes a request body decoding error, calling out
+// malformed amounts so clients know why their request was refused.
+func invalidBodyMessage(err error) string {
+	switch {
+	case errors.Is(err, models.ErrAmountPrecision):
+		return "Invalid amount: at most 2 decimal places are allowed"
+	case errors.Is(err, models.ErrInvalidAmount), errors.Is(err, models.ErrAmountRange):
+		return "Invalid amount"
+	}
+	return "Invalid request body"
 }
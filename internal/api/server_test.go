@@ -5,16 +5,32 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"ledger/internal/auth"
 	"ledger/internal/models"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// testTokens returns a token service signing with a fixed test secret.
+func testTokens(t *testing.T) *auth.TokenService {
+	t.Helper()
+	tokens, err := auth.NewTokenService("ledger", "", time.Hour, auth.HMACKey("", []byte("test-secret")))
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	return tokens
+}
+
 func setupTestDB(t *testing.T) *sql.DB {
 	if err := godotenv.Load("../../.env"); err != nil {
 		t.Fatalf("Error loading .env file: %v", err)
@@ -26,6 +42,15 @@ func setupTestDB(t *testing.T) *sql.DB {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
 
+	// Only -short runs may go without the database, so that a run with no
+	// database cannot pass without testing the ledger
+	if err := db.Ping(); err != nil {
+		if testing.Short() {
+			t.Skipf("Test database not available: %v", err)
+		}
+		t.Fatalf("Test database not available: %v", err)
+	}
+
 	// Clean up test data
 	_, err = db.Exec("TRUNCATE users CASCADE")
 	if err != nil {
@@ -39,7 +64,7 @@ func TestCreateUser(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	server := NewServer(db)
+	server := NewServer(db, log.Default(), testTokens(t))
 
 	tests := []struct {
 		name           string
@@ -101,7 +126,7 @@ func TestAddCredit(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	server := NewServer(db)
+	server := NewServer(db, log.Default(), testTokens(t))
 
 	// First create a user
 	user := createTestUser(t, server)
@@ -160,7 +185,7 @@ func TestGetUserBalance(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	server := NewServer(db)
+	server := NewServer(db, log.Default(), testTokens(t))
 	user := createTestUser(t, server)
 
 	// Add some initial credit
@@ -170,21 +195,21 @@ func TestGetUserBalance(t *testing.T) {
 		name            string
 		userID          int64
 		expectedStatus  int
-		expectedBalance float64
+		expectedBalance string
 		expectedError   bool
 	}{
 		{
 			name:            "Valid User",
 			userID:          user.ID,
 			expectedStatus:  http.StatusOK,
-			expectedBalance: 100.00,
+			expectedBalance: "100.00",
 			expectedError:   false,
 		},
 		{
 			name:            "Invalid User",
 			userID:          999,
 			expectedStatus:  http.StatusNotFound,
-			expectedBalance: 0,
+			expectedBalance: "0.00",
 			expectedError:   true,
 		},
 	}
@@ -201,10 +226,13 @@ func TestGetUserBalance(t *testing.T) {
 			}
 
 			if !tt.expectedError {
-				var response map[string]float64
+				var response models.UserBalance
 				json.NewDecoder(w.Body).Decode(&response)
-				if response["balance"] != tt.expectedBalance {
-					t.Errorf("Expected balance %.2f, got %.2f", tt.expectedBalance, response["balance"])
+				if len(response.Balances) != 1 {
+					t.Fatalf("Expected 1 currency balance, got %d", len(response.Balances))
+				}
+				if response.Balances[0].Posted.String() != tt.expectedBalance {
+					t.Errorf("Expected balance %s, got %s", tt.expectedBalance, response.Balances[0].Posted)
 				}
 			}
 		})
@@ -215,7 +243,7 @@ func TestGetAllBalances(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	server := NewServer(db)
+	server := NewServer(db, log.Default(), testTokens(t))
 
 	// Create multiple users with different balances
 	user1 := createTestUser(t, server)
@@ -267,3 +295,134 @@ func addTestCredit(t *testing.T, server *Server, userID int64, amount float64) {
 
 	server.addCredit(w, req)
 }
+
+// testPassword is the password of every user testEnv signs up.
+const testPassword = "test-password"
+
+// testEnv drives a server through its router, authenticating like real
+// clients, with an admin already signed up.
+type testEnv struct {
+	t          *testing.T
+	db         *sql.DB
+	server     *Server
+	router     http.Handler
+	adminID    int64
+	adminToken string
+	users      int
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+
+	server := NewServer(db, log.New(io.Discard, "", 0), testTokens(t))
+	e := &testEnv{t: t, db: db, server: server, router: server.RegisterRoutes()}
+	e.adminID, e.adminToken = e.newUser(models.RoleAdmin)
+	return e
+}
+
+// newUser signs up a user with a USD account and returns their id and an
+// access token.
+func (e *testEnv) newUser(role string) (int64, string) {
+	e.t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		e.t.Fatalf("hashing password: %v", err)
+	}
+
+	e.users++
+	var id int64
+	err = e.db.QueryRow(`
+		INSERT INTO users (name, email, password_hash, role)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		fmt.Sprintf("Test User %d", e.users), fmt.Sprintf("user%d@example.com", e.users), string(hash), role).Scan(&id)
+	if err != nil {
+		e.t.Fatalf("creating user: %v", err)
+	}
+	e.openAccount(id, models.DefaultCurrency)
+
+	token, _, err := e.server.tokens.Issue(id, role)
+	if err != nil {
+		e.t.Fatalf("Issue: %v", err)
+	}
+	return id, token
+}
+
+// openAccount opens an account in currency for userID.
+func (e *testEnv) openAccount(userID int64, currency string) {
+	e.t.Helper()
+	if _, err := e.db.Exec("INSERT INTO accounts (user_id, currency) VALUES ($1, $2)", userID, currency); err != nil {
+		e.t.Fatalf("opening account: %v", err)
+	}
+}
+
+// do sends a request with an optional JSON body and bearer token.
+func (e *testEnv) do(method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	e.router.ServeHTTP(rr, req)
+	return rr
+}
+
+// expect fails the test unless rr has the given status, and decodes its
+// JSON body into v when v is not nil.
+func (e *testEnv) expect(rr *httptest.ResponseRecorder, status int, v interface{}) {
+	e.t.Helper()
+	if rr.Code != status {
+		e.t.Fatalf("Expected status %d, got %d: %s", status, rr.Code, strings.TrimSpace(rr.Body.String()))
+	}
+	if v != nil {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			e.t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+}
+
+// credit adds amount to the user's account in currency.
+func (e *testEnv) credit(userID int64, amount, currency string) {
+	e.t.Helper()
+	body := fmt.Sprintf(`{"amount":%q,"currency":%q}`, amount, currency)
+	e.expect(e.do("POST", fmt.Sprintf("/api/users/%d/credit", userID), e.adminToken, body), http.StatusOK, nil)
+}
+
+// balance returns the user's balance in currency.
+func (e *testEnv) balance(userID int64, currency string) models.CurrencyBalance {
+	e.t.Helper()
+	var response models.UserBalance
+	e.expect(e.do("GET", fmt.Sprintf("/api/users/%d/balance", userID), e.adminToken, ""), http.StatusOK, &response)
+	for _, b := range response.Balances {
+		if b.Currency == currency {
+			return b
+		}
+	}
+	e.t.Fatalf("User %d has no %s balance", userID, currency)
+	return models.CurrencyBalance{}
+}
+
+// expectBalance fails the test unless the user's posted and available
+// balances in currency are as given.
+func (e *testEnv) expectBalance(userID int64, currency, posted, available string) {
+	e.t.Helper()
+	b := e.balance(userID, currency)
+	if b.Posted.String() != posted || b.Available.String() != available {
+		e.t.Errorf("User %d %s balance: posted %s, available %s; want %s, %s",
+			userID, currency, b.Posted, b.Available, posted, available)
+	}
+}
+
+// transfer moves amount USD from one user to another as the admin.
+func (e *testEnv) transfer(fromUserID, toUserID int64, amount string) models.TransferResult {
+	e.t.Helper()
+	var result models.TransferResult
+	body := fmt.Sprintf(`{"from_user_id":%d,"to_user_id":%d,"amount":%q}`, fromUserID, toUserID, amount)
+	e.expect(e.do("POST", "/api/transfer", e.adminToken, body), http.StatusOK, &result)
+	return result
+}
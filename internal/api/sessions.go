@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"ledger/internal/auth"
+	"ledger/internal/middleware"
+	"ledger/internal/models"
+	"ledger/internal/utils"
+)
+
+// defaultRefreshTokenLifetime is how long a session lasts without being
+// refreshed when REFRESH_TOKEN_TTL is not set.
+const defaultRefreshTokenLifetime = 30 * 24 * time.Hour
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenExpired = errors.New("refresh token has expired")
+)
+
+// refreshTokenLifetime is how long a refresh token can be used for, set
+// with REFRESH_TOKEN_TTL (e.g. "168h").
+func refreshTokenLifetime() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultRefreshTokenLifetime
+}
+
+// hashRefreshToken is what refresh tokens are stored and looked up as, so
+// that the table cannot be used to take over sessions.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueSession issues an access token and a refresh token for a user. An
+// empty familyID starts a new session; otherwise the refresh token
+// continues the session it names.
+func (s *Server) issueSession(q queryRower, userID int64, role, status, familyID string) (models.LoginResponse, error) {
+	if familyID == "" {
+		id, err := auth.NewTokenID()
+		if err != nil {
+			return models.LoginResponse{}, err
+		}
+		familyID = id
+	}
+
+	accessToken, claims, err := s.tokens.Issue(userID, role)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return models.LoginResponse{}, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(b)
+	refreshExpiresAt := time.Now().Add(refreshTokenLifetime())
+
+	var id int64
+	err = q.QueryRow(`
+		INSERT INTO refresh_tokens (family_id, user_id, token_hash, access_jti, access_expires_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		familyID, userID, hashRefreshToken(refreshToken), claims.ID, claims.ExpiresAt.Time, refreshExpiresAt).Scan(&id)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	return models.LoginResponse{
+		Token:            accessToken,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+		Role:             role,
+		UserID:           userID,
+		Status:           status,
+	}, nil
+}
+
+// revokeSessions revokes the refresh tokens matching cond, which filters on
+// a column of refresh_tokens against $1, and denies every access token
+// issued with them that has not expired yet. It returns the number of
+// sessions that were still live.
+func revokeSessions(tx *sql.Tx, cond string, arg interface{}) (int64, error) {
+	_, err := tx.Exec(`
+		INSERT INTO revoked_tokens (jti, expires_at)
+		SELECT access_jti, access_expires_at
+		FROM refresh_tokens
+		WHERE `+cond+` AND access_expires_at > NOW()
+		ON CONFLICT (jti) DO NOTHING`, arg)
+	if err != nil {
+		return 0, err
+	}
+
+	// The one unused token of a family is its live end
+	var live int64
+	err = tx.QueryRow(`
+		WITH revoked AS (
+			UPDATE refresh_tokens
+			SET revoked_at = NOW()
+			WHERE `+cond+` AND revoked_at IS NULL
+			RETURNING used_at
+		)
+		SELECT COUNT(*) FROM revoked WHERE used_at IS NULL`, arg).Scan(&live)
+	return live, err
+}
+
+// refreshSession trades a refresh token for a new access token and refresh
+// token in the same session. Each refresh token works once: presenting one
+// that was already used means it leaked, so the whole session is revoked.
+func (s *Server) refreshSession(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		resp   models.LoginResponse
+		reused bool
+	)
+	err := s.inTx(func(tx *sql.Tx) error {
+		reused = false
+
+		var (
+			id                     int64
+			familyID               string
+			userID                 int64
+			used, revoked, expired bool
+		)
+		err := tx.QueryRow(`
+			SELECT id, family_id, user_id, used_at IS NOT NULL, revoked_at IS NOT NULL, expires_at <= NOW()
+			FROM refresh_tokens
+			WHERE token_hash = $1
+			FOR UPDATE`,
+			hashRefreshToken(req.RefreshToken)).Scan(&id, &familyID, &userID, &used, &revoked, &expired)
+		switch {
+		case err == sql.ErrNoRows, err == nil && revoked:
+			return errInvalidRefreshToken
+		case err != nil:
+			return err
+		case used:
+			// Commit the revocation and answer as for any other bad token
+			reused = true
+			_, err = revokeSessions(tx, "family_id = $1", familyID)
+			return err
+		case expired:
+			return errRefreshTokenExpired
+		}
+
+		var role, status string
+		if err := tx.QueryRow("SELECT role, status FROM users WHERE id = $1", userID).Scan(&role, &status); err != nil {
+			return err
+		}
+		if status == models.UserClosed {
+			return errAccountClosed
+		}
+
+		if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1", id); err != nil {
+			return err
+		}
+		resp, err = s.issueSession(tx, userID, role, status, familyID)
+		return err
+	})
+
+	switch {
+	case err == nil && reused:
+		s.logger.Printf("Refresh token reused, session revoked")
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	case err == errInvalidRefreshToken:
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+	case err == errRefreshTokenExpired:
+		http.Error(w, "Refresh token has expired", http.StatusUnauthorized)
+	case err == errAccountClosed:
+		http.Error(w, "Account is closed", http.StatusForbidden)
+	default:
+		s.logger.Printf("Error refreshing session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// logout ends the session the calling access token belongs to. The access
+// token itself is denied straight away.
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO revoked_tokens (jti, expires_at)
+			VALUES ($1, $2)
+			ON CONFLICT (jti) DO NOTHING`,
+			claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			return err
+		}
+		_, err = revokeSessions(tx, "family_id IN (SELECT family_id FROM refresh_tokens WHERE access_jti = $1)", claims.ID)
+		return err
+	})
+	if err != nil {
+		s.logger.Printf("Error logging out user %d: %v", claims.UserID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserSessions ends every session of the user in the URL, for
+// example after their credentials leaked.
+func (s *Server) revokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var revoked int64
+	err = s.inTx(func(tx *sql.Tx) error {
+		var err error
+		revoked, err = revokeSessions(tx, "user_id = $1", userID)
+		return err
+	})
+	if err != nil {
+		s.logger.Printf("Error revoking sessions of user %d: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"revoked_sessions": revoked})
+}
+
+// jwks publishes the public keys access tokens are signed with, so that
+// other services can verify them without the ledger's secrets.
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(s.tokens.JWKS())
+}
+
+// purgeTokensJob deletes refresh tokens and denylist entries for tokens
+// that have expired, which no longer need remembering.
+func (s *Server) purgeTokensJob(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= NOW()"); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at <= NOW()")
+	return err
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"ledger/internal/models"
+)
+
+// login starts a session for the user with their test password.
+func (e *testEnv) login(userID int64) models.LoginResponse {
+	e.t.Helper()
+	var email string
+	if err := e.db.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&email); err != nil {
+		e.t.Fatalf("Failed to load user %d: %v", userID, err)
+	}
+	var session models.LoginResponse
+	body := fmt.Sprintf(`{"email":%q,"password":%q}`, email, testPassword)
+	e.expect(e.do("POST", "/api/login", "", body), http.StatusOK, &session)
+	return session
+}
+
+// refresh trades a refresh token for the next pair, expecting status.
+func (e *testEnv) refresh(refreshToken string, status int) models.LoginResponse {
+	e.t.Helper()
+	var session models.LoginResponse
+	rr := e.do("POST", "/api/token/refresh", "", fmt.Sprintf(`{"refresh_token":%q}`, refreshToken))
+	if status != http.StatusOK {
+		e.expect(rr, status, nil)
+		return session
+	}
+	e.expect(rr, status, &session)
+	return session
+}
+
+// expectAccess fails the test unless the access token of the user gets
+// status from an authenticated route.
+func (e *testEnv) expectAccess(userID int64, token string, status int) {
+	e.t.Helper()
+	e.expect(e.do("GET", fmt.Sprintf("/api/users/%d/balance", userID), token, ""), status, nil)
+}
+
+func TestSessions(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+
+	t.Run("Refresh Rotation", func(t *testing.T) {
+		first := e.login(userID)
+		e.expectAccess(userID, first.Token, http.StatusOK)
+
+		second := e.refresh(first.RefreshToken, http.StatusOK)
+		if second.RefreshToken == first.RefreshToken || second.Token == first.Token {
+			t.Error("Expected a new token pair")
+		}
+		e.expectAccess(userID, second.Token, http.StatusOK)
+
+		third := e.refresh(second.RefreshToken, http.StatusOK)
+		e.expectAccess(userID, third.Token, http.StatusOK)
+	})
+
+	t.Run("Reuse Detection", func(t *testing.T) {
+		first := e.login(userID)
+		second := e.refresh(first.RefreshToken, http.StatusOK)
+		third := e.refresh(second.RefreshToken, http.StatusOK)
+
+		// Presenting a used token revokes the whole family
+		e.refresh(first.RefreshToken, http.StatusUnauthorized)
+		e.refresh(third.RefreshToken, http.StatusUnauthorized)
+		e.expectAccess(userID, third.Token, http.StatusUnauthorized)
+		e.expectAccess(userID, second.Token, http.StatusUnauthorized)
+
+		// Other sessions are left alone
+		other := e.login(userID)
+		e.expectAccess(userID, other.Token, http.StatusOK)
+		e.refresh(other.RefreshToken, http.StatusOK)
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		session := e.login(userID)
+		other := e.login(userID)
+
+		e.expect(e.do("POST", "/api/logout", session.Token, ""), http.StatusNoContent, nil)
+		e.expectAccess(userID, session.Token, http.StatusUnauthorized)
+		e.refresh(session.RefreshToken, http.StatusUnauthorized)
+
+		e.expectAccess(userID, other.Token, http.StatusOK)
+	})
+
+	t.Run("Admin Revoke", func(t *testing.T) {
+		userID, _ := e.newUser(models.RoleUser)
+		first := e.login(userID)
+		second := e.login(userID)
+		e.refresh(second.RefreshToken, http.StatusOK)
+
+		var revoked map[string]int64
+		rr := e.do("POST", fmt.Sprintf("/api/users/%d/sessions/revoke", userID), e.adminToken, "")
+		e.expect(rr, http.StatusOK, &revoked)
+		if revoked["revoked_sessions"] != 2 {
+			t.Errorf("Expected 2 sessions revoked, got %v", revoked)
+		}
+
+		e.expectAccess(userID, first.Token, http.StatusUnauthorized)
+		e.refresh(first.RefreshToken, http.StatusUnauthorized)
+		e.expectAccess(userID, e.adminToken, http.StatusOK)
+	})
+}
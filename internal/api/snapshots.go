@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"ledger/internal/db"
+)
+
+// balanceSnapshotJob returns a job run that checks whether a snapshot
+// period of the given interval has closed and snapshots the accounts that
+// had postings in it.
+func (s *Server) balanceSnapshotJob(interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := db.TakeBalanceSnapshots(s.db, interval)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			s.logger.Printf("Took %d balance snapshots", n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"ledger/internal/db"
+	"ledger/internal/models"
+)
+
+// backdateLastEntry moves the postings of the latest journal entry back by
+// age, as if it had been posted then.
+func (e *testEnv) backdateLastEntry(age string) {
+	e.t.Helper()
+	_, err := e.db.Exec(`
+		UPDATE postings SET created_at = created_at - $1::interval
+		WHERE entry_id = (SELECT MAX(entry_id) FROM postings)`, age)
+	if err != nil {
+		e.t.Fatalf("backdating postings: %v", err)
+	}
+}
+
+// balanceAt returns the user's USD balance at time at.
+func (e *testEnv) balanceAt(userID int64, at time.Time) string {
+	e.t.Helper()
+	var response struct {
+		Balance models.Money `json:"balance"`
+	}
+	path := fmt.Sprintf("/api/users/%d/balance-at-time?timestamp=%s", userID, url.QueryEscape(at.Format(time.RFC3339)))
+	e.expect(e.do("GET", path, e.adminToken, ""), http.StatusOK, &response)
+	return response.Balance.String()
+}
+
+func TestBalanceAtTimeAcrossSnapshots(t *testing.T) {
+	e := newTestEnv(t)
+	userID, _ := e.newUser(models.RoleUser)
+
+	e.credit(userID, "100.00", "USD")
+	e.backdateLastEntry("6 days")
+	e.credit(userID, "50.00", "USD")
+	e.backdateLastEntry("3 days")
+
+	// The daily snapshot falls at the latest midnight, after both credits
+	n, err := db.TakeBalanceSnapshots(e.db, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("TakeBalanceSnapshots: %v", err)
+	}
+	if n == 0 {
+		t.Fatal("Expected the credited account to be snapshotted")
+	}
+	e.credit(userID, "25.00", "USD")
+
+	// The margins of a day and a half keep the database's time zone out of
+	// the way
+	day := 24 * time.Hour
+	now := time.Now()
+	tests := []struct {
+		name   string
+		at     time.Time
+		expect string
+	}{
+		{"Before Any Posting", now.Add(-7*day - day/2), "0.00"},
+		{"Between Credits", now.Add(-4*day - day/2), "100.00"},
+		{"Before Snapshot", now.Add(-day - day/2), "150.00"},
+		{"After Snapshot", now.Add(day + day/2), "175.00"},
+	}
+
+	check := func(snapshots string) {
+		for _, tt := range tests {
+			if got := e.balanceAt(userID, tt.at); got != tt.expect {
+				t.Errorf("%s, %s snapshots: expected %s, got %s", tt.name, snapshots, tt.expect, got)
+			}
+		}
+	}
+
+	check("live")
+
+	// A rebuild snapshots every day with postings and must give the same
+	// answers as the live job
+	if _, err := db.RebuildBalanceSnapshots(e.db, 24*time.Hour); err != nil {
+		t.Fatalf("RebuildBalanceSnapshots: %v", err)
+	}
+	check("rebuilt")
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ledger/internal/models"
+	"ledger/internal/statement"
+	"ledger/internal/utils"
+)
+
+// parseStatementTime accepts a date such as 2026-09-01, meaning its
+// midnight, or an RFC3339 timestamp.
+func parseStatementTime(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// getStatement returns a user's statement for the period [from, to) in one
+// currency as JSON, CSV or PDF.
+//
+// Query parameters:
+//
+//	from, to  period bounds as dates or RFC3339 timestamps, to exclusive
+//	currency  account currency, default USD
+//	format    json (default), csv or pdf
+func (s *Server) getStatement(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("from") == "" || q.Get("to") == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	from, err := parseStatementTime(q.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from, use YYYY-MM-DD or RFC3339", http.StatusBadRequest)
+		return
+	}
+	to, err := parseStatementTime(q.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to, use YYYY-MM-DD or RFC3339", http.StatusBadRequest)
+		return
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	currency, ok := requestCurrency(q.Get("currency"))
+	if !ok {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "csv", "pdf":
+	default:
+		http.Error(w, "format must be json, csv or pdf", http.StatusBadRequest)
+		return
+	}
+
+	// Read the opening balance and the movements from one snapshot of the
+	// ledger so that they agree with each other
+	tx, err := s.db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	st := models.Statement{
+		UserID:   userID,
+		Currency: currency,
+		From:     from,
+		To:       to,
+		Lines:    []models.StatementLine{},
+	}
+
+	accountID, err := userAccountID(tx, userID, currency)
+	if err != nil {
+		writeAccountError(w, err, "User", currency)
+		return
+	}
+
+	// The opening balance excludes postings made at from, which belong to
+	// this period
+	st.OpeningBalance.Currency = currency
+	err = tx.QueryRow(`
+		SELECT u.name, `+balanceAtSQL("$2", false)+`
+		FROM accounts a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.id = $1`,
+		accountID, from).Scan(&st.Name, &st.OpeningBalance)
+	if err != nil {
+		http.Error(w, "Failed to get opening balance", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := tx.Query(`
+		SELECT t.id, t.type, COALESCE(je.description, ''), p.amount, p.balance_after, p.created_at
+		FROM postings p
+		JOIN journal_entries je ON je.id = p.entry_id
+		LEFT JOIN transactions t ON t.id = je.transaction_id
+		WHERE p.account_id = $1
+		AND p.created_at >= $2 AND p.created_at < $3
+		ORDER BY p.created_at, p.id`,
+		accountID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to get statement lines", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	st.ClosingBalance = st.OpeningBalance
+	for rows.Next() {
+		var (
+			line          models.StatementLine
+			transactionID sql.NullInt64
+			kind          sql.NullString
+		)
+		err := rows.Scan(&transactionID, &kind, &line.Description, &line.Amount, &line.BalanceAfter, &line.CreatedAt)
+		if err != nil {
+			http.Error(w, "Failed to get statement lines", http.StatusInternalServerError)
+			return
+		}
+		if transactionID.Valid {
+			line.TransactionID = &transactionID.Int64
+		}
+		line.Type = kind.String
+		line.Amount.Currency = currency
+		line.BalanceAfter.Currency = currency
+
+		st.Lines = append(st.Lines, line)
+		st.ClosingBalance = line.BalanceAfter
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to get statement lines", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("statement-%d-%s-%s-%s.%s",
+		userID, currency, from.Format("20060102"), to.Format("20060102"), format)
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		err = statement.WriteCSV(w, st)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		err = statement.WritePDF(w, st)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(st)
+	}
+	if err != nil {
+		s.logger.Printf("Error writing %s statement: %v", format, err)
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"ledger/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// createFundedUser creates a user with a USD account credited with balance.
+func createFundedUser(t *testing.T, server *Server, n int, balance models.Money) int64 {
+	var userID int64
+	err := server.inTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+			INSERT INTO users (name, email, password_hash, role)
+			VALUES ($1, $2, 'x', 'user')
+			RETURNING id`,
+			fmt.Sprintf("Stress %d", n), fmt.Sprintf("stress%d@example.com", n)).Scan(&userID)
+		if err != nil {
+			return err
+		}
+
+		var accountID int64
+		err = tx.QueryRow("INSERT INTO accounts (user_id) VALUES ($1) RETURNING id", userID).Scan(&accountID)
+		if err != nil {
+			return err
+		}
+		settlementID, err := systemAccountID(tx, settlementAccount, balance.Currency)
+		if err != nil {
+			return err
+		}
+
+		var transactionID int64
+		err = tx.QueryRow(`
+			INSERT INTO transactions (to_user_id, amount, currency, type)
+			VALUES ($1, $2, $3, 'credit')
+			RETURNING id`,
+			userID, balance, balance.Currency).Scan(&transactionID)
+		if err != nil {
+			return err
+		}
+		return postEntry(tx, transactionID, "Credit", []posting{
+			{accountID: settlementID, amount: balance.Neg()},
+			{accountID: accountID, amount: balance},
+		})
+	})
+	if err != nil {
+		t.Fatalf("Failed to create funded user: %v", err)
+	}
+	return userID
+}
+
+// TestConcurrentTransfers runs random transfers, in both directions
+// between the same accounts, from many goroutines at once. No balance may
+// go negative and no money may be created or lost.
+func TestConcurrentTransfers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	server := NewServer(db, log.New(io.Discard, "", 0), testTokens(t))
+
+	const (
+		users              = 4
+		workers            = 16
+		transfersPerWorker = 50
+	)
+	initial := models.NewMoney(10000, "USD")
+
+	ids := make([]int64, users)
+	for i := range ids {
+		ids[i] = createFundedUser(t, server, i, initial)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < transfersPerWorker; i++ {
+				from, to := rng.Intn(users), rng.Intn(users)
+				if from == to {
+					continue
+				}
+				// Large enough for some transfers to be refused
+				amount := models.NewMoney(int64(rng.Intn(5000)+1), "USD")
+				err := server.inTx(func(tx *sql.Tx) error {
+					_, err := transferTx(tx, ids[from], ids[to], amount, "USD", nil)
+					return err
+				})
+				if err != nil && err != errInsufficientBalance {
+					t.Errorf("Transfer failed: %v", err)
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	rows, err := db.Query("SELECT balance FROM accounts WHERE user_id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		t.Fatalf("Failed to read balances: %v", err)
+	}
+	defer rows.Close()
+
+	total := models.NewMoney(0, "USD")
+	for rows.Next() {
+		balance := models.Money{Currency: "USD"}
+		if err := rows.Scan(&balance); err != nil {
+			t.Fatalf("Failed to read balance: %v", err)
+		}
+		if balance.IsNegative() {
+			t.Errorf("Balance went negative: %s", balance)
+		}
+		total = total.Add(balance)
+	}
+	if want := models.NewMoney(initial.Units*users, "USD"); total != want {
+		t.Errorf("Expected total balance %s, got %s", want, total)
+	}
+
+	var mismatched int
+	err = db.QueryRow(`
+		SELECT COUNT(*)
+		FROM accounts a
+		WHERE a.balance <> (SELECT COALESCE(SUM(p.amount), 0) FROM postings p WHERE p.account_id = a.id)`).Scan(&mismatched)
+	if err != nil {
+		t.Fatalf("Failed to reconcile accounts: %v", err)
+	}
+	if mismatched != 0 {
+		t.Errorf("Expected cached balances to match postings, %d accounts differ", mismatched)
+	}
+}
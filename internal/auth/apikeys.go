@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// apiKeyTag starts every API key, so leaked keys are easy to recognise.
+const apiKeyTag = "lk_"
+
+const apiKeyPrefixLength = 8
+
+// GenerateAPIKey returns a new API key, lk_<prefix>_<secret>, together
+// with its prefix and the hash to store. The key itself is not kept.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	p := make([]byte, apiKeyPrefixLength/2)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(p)
+	key = apiKeyTag + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// ParseAPIKey returns the prefix of key, and false if key is not shaped
+// like an API key.
+func ParseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyTag) || len(key) < len(apiKeyTag)+apiKeyPrefixLength+2 {
+		return "", false
+	}
+	prefix := key[len(apiKeyTag) : len(apiKeyTag)+apiKeyPrefixLength]
+	if key[len(apiKeyTag)+apiKeyPrefixLength] != '_' {
+		return "", false
+	}
+	if _, err := hex.DecodeString(prefix); err != nil {
+		return "", false
+	}
+	return prefix, true
+}
+
+// HashAPIKey is what API keys are stored and compared as.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
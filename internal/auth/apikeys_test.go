@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey: %v", err)
+	}
+	if !strings.HasPrefix(key, "lk_"+prefix+"_") {
+		t.Errorf("key %q does not start with lk_%s_", key, prefix)
+	}
+	if got, ok := ParseAPIKey(key); !ok || got != prefix {
+		t.Errorf("ParseAPIKey = %q, %v, want %q, true", got, ok, prefix)
+	}
+	if hash != HashAPIKey(key) || strings.Contains(hash, key) {
+		t.Errorf("hash = %q", hash)
+	}
+
+	other, _, _, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey: %v", err)
+	}
+	if other == key {
+		t.Error("two keys are the same")
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"lk_",
+		"lk_0123abcd",
+		"lk_0123abcd_",
+		"lk_0123abcdXsecret",
+		"lk_0123abcz_secret",
+		"sk_0123abcd_secret",
+		"Bearer lk_0123abcd_secret",
+	} {
+		if prefix, ok := ParseAPIKey(key); ok {
+			t.Errorf("ParseAPIKey(%q) = %q, want no match", key, prefix)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// Package auth issues and verifies the access tokens the API is called
+// with.
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ledger/internal/models"
+	"ledger/pkg/ledgerjwt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultAccessTokenLifetime is how long an access token is valid for when
+// ACCESS_TOKEN_TTL is not set. Sessions outlive it through refresh tokens.
+const defaultAccessTokenLifetime = 15 * time.Minute
+
+// defaultIssuer is the iss claim of the tokens the ledger issues when
+// JWT_ISSUER is not set.
+const defaultIssuer = "ledger"
+
+var (
+	ErrNoKeys         = errors.New("no signing keys configured")
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrInvalidToken   = errors.New("invalid token")
+	ErrInvalidClaims  = errors.New("invalid token claims")
+	ErrCannotSign     = errors.New("signing key has no private key")
+	ErrAlgMismatch    = errors.New("token algorithm does not match its key")
+	ErrDuplicateKeyID = errors.New("duplicate key ID")
+	ErrUnknownIssuer  = errors.New("unknown token issuer")
+)
+
+// Key is one key tokens can be signed or verified with. Tokens name the key
+// they were signed with in their kid header; the legacy key, whose ID is
+// empty, signs and verifies tokens without one.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	// Sign is the secret or private key, and nil for a key that is only
+	// kept to verify tokens it signed before. Verify is the secret or
+	// public key.
+	Sign   interface{}
+	Verify interface{}
+}
+
+// HMACKey returns an HS256 key with a shared secret.
+func HMACKey(id string, secret []byte) Key {
+	return Key{ID: id, Method: jwt.SigningMethodHS256, Sign: secret, Verify: secret}
+}
+
+// RSAKey returns an RS256 key. priv may be nil for a verify-only key.
+func RSAKey(id string, priv *rsa.PrivateKey, pub *rsa.PublicKey) Key {
+	k := Key{ID: id, Method: jwt.SigningMethodRS256, Verify: pub}
+	if priv != nil {
+		k.Sign = priv
+		k.Verify = &priv.PublicKey
+	}
+	return k
+}
+
+// Ed25519Key returns an EdDSA key. priv may be nil for a verify-only key.
+func Ed25519Key(id string, priv ed25519.PrivateKey, pub ed25519.PublicKey) Key {
+	k := Key{ID: id, Method: jwt.SigningMethodEdDSA, Verify: pub}
+	if priv != nil {
+		k.Sign = priv
+		k.Verify = priv.Public()
+	}
+	return k
+}
+
+// TokenService signs access tokens with its active key and verifies tokens
+// signed with any of its keys, so a new key can take over signing while
+// tokens signed with the old one stay valid until they expire. It also
+// verifies tokens from the external issuers it trusts.
+type TokenService struct {
+	issuer   string
+	keys     map[string]Key
+	active   Key
+	lifetime time.Duration
+	trusted  map[string]*ledgerjwt.Verifier
+}
+
+// NewTokenService returns a service that issues tokens as issuer, signs them
+// with the key named active and verifies with all of keys. Tokens it issues
+// are valid for lifetime.
+func NewTokenService(issuer, active string, lifetime time.Duration, keys ...Key) (*TokenService, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	s := &TokenService{
+		issuer:   issuer,
+		keys:     make(map[string]Key, len(keys)),
+		lifetime: lifetime,
+		trusted:  make(map[string]*ledgerjwt.Verifier),
+	}
+	for _, k := range keys {
+		if _, ok := s.keys[k.ID]; ok {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateKeyID, k.ID)
+		}
+		s.keys[k.ID] = k
+	}
+
+	k, ok := s.keys[active]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, active)
+	}
+	if k.Sign == nil {
+		return nil, fmt.Errorf("%w: %q", ErrCannotSign, active)
+	}
+	s.active = k
+	return s, nil
+}
+
+// TrustIssuer makes the service accept tokens whose iss claim is issuer,
+// verified with v. Such tokens must carry the same claims as the service's
+// own.
+func (s *TokenService) TrustIssuer(issuer string, v *ledgerjwt.Verifier) error {
+	if issuer == "" || issuer == s.issuer {
+		return fmt.Errorf("%w: %q cannot be trusted as an external issuer", ErrUnknownIssuer, issuer)
+	}
+	s.trusted[issuer] = v
+	return nil
+}
+
+// JWKS returns the public keys of the service's RS256 and EdDSA keys, for
+// others to verify its tokens with. Shared secrets are never published.
+func (s *TokenService) JWKS() ledgerjwt.JWKS {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := ledgerjwt.JWKS{Keys: []ledgerjwt.JWK{}}
+	for _, id := range ids {
+		k := s.keys[id]
+		if id == "" || k.Method == jwt.SigningMethodHS256 {
+			continue
+		}
+		if jwk, err := ledgerjwt.NewJWK(id, k.Verify); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// Lifetime is how long the tokens the service issues are valid for.
+func (s *TokenService) Lifetime() time.Duration {
+	return s.lifetime
+}
+
+// Issue signs a new access token for a user with the active key. The
+// claims are returned too, so the caller can record the token's ID and
+// expiry.
+func (s *TokenService) Issue(userID int64, role string) (string, *models.Claims, error) {
+	jti, err := NewTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &models.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.issuer,
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.lifetime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	if s.active.ID != "" {
+		token.Header["kid"] = s.active.ID
+	}
+	signed, err := token.SignedString(s.active.Sign)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// Verify checks a token's signature against the key its kid header names
+// and returns its claims. The token must use that key's algorithm, must not
+// have expired, and must name a user, a known role and a token ID. Tokens
+// from a trusted issuer are checked against that issuer's keys; tokens
+// without an issuer are taken to be the service's own.
+func (s *TokenService) Verify(token string) (*models.Claims, error) {
+	claims := &models.Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if v, ok := s.trusted[claims.Issuer]; ok {
+			return v.Keyfunc(t)
+		}
+		if claims.Issuer != "" && claims.Issuer != s.issuer {
+			return nil, ErrUnknownIssuer
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		k, ok := s.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if t.Method.Alg() != k.Method.Alg() {
+			return nil, ErrAlgMismatch
+		}
+		return k.Verify, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.UserID == 0 || claims.ID == "" || (claims.Role != models.RoleUser && claims.Role != models.RoleAdmin) {
+		return nil, ErrInvalidClaims
+	}
+	return claims, nil
+}
+
+// NewTokenID returns a random identifier for a token or token family.
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// LoadTokenService builds the token service from the environment.
+//
+// JWT_KEYS lists keys as comma-separated kid:alg:value entries. For HS256
+// the value is the secret itself; for RS256 and EdDSA it is the path to a
+// PEM file holding a private key, or a public key for a key that only
+// verifies. JWT_ACTIVE_KID names the key new tokens are signed with and
+// defaults to the first one listed.
+//
+// JWT_SECRET, if set, is kept as the legacy HS256 key for tokens without a
+// kid header, and is the only key when JWT_KEYS is not set.
+// ACCESS_TOKEN_TTL (e.g. "10m") sets how long tokens are valid for, and
+// JWT_ISSUER (default "ledger") the iss claim they carry.
+//
+// JWT_EXTERNAL_ISSUER and JWT_EXTERNAL_JWKS_URL, set together, trust tokens
+// from another issuer, verified with the keys it publishes.
+func LoadTokenService() (*TokenService, error) {
+	var keys []Key
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keys = append(keys, HMACKey("", []byte(secret)))
+	}
+
+	var configured []Key
+	if spec := strings.TrimSpace(os.Getenv("JWT_KEYS")); spec != "" {
+		for _, entry := range strings.Split(spec, ",") {
+			k, err := parseKey(strings.TrimSpace(entry))
+			if err != nil {
+				return nil, err
+			}
+			configured = append(configured, k)
+		}
+	}
+	keys = append(keys, configured...)
+
+	active := os.Getenv("JWT_ACTIVE_KID")
+	if active == "" && len(configured) > 0 {
+		active = configured[0].ID
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: set JWT_KEYS or JWT_SECRET", ErrNoKeys)
+	}
+
+	lifetime := defaultAccessTokenLifetime
+	if d, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && d > 0 {
+		lifetime = d
+	}
+
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = defaultIssuer
+	}
+
+	s, err := NewTokenService(issuer, active, lifetime, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	external, jwksURL := os.Getenv("JWT_EXTERNAL_ISSUER"), os.Getenv("JWT_EXTERNAL_JWKS_URL")
+	if (external == "") != (jwksURL == "") {
+		return nil, errors.New("JWT_EXTERNAL_ISSUER and JWT_EXTERNAL_JWKS_URL must be set together")
+	}
+	if external != "" {
+		if err := s.TrustIssuer(external, ledgerjwt.NewVerifier(jwksURL, ledgerjwt.WithIssuer(external))); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseKey parses one kid:alg:value entry of JWT_KEYS.
+func parseKey(entry string) (Key, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return Key{}, fmt.Errorf("JWT_KEYS entry %q is not kid:alg:value", entry)
+	}
+	id, alg, value := parts[0], parts[1], parts[2]
+
+	if alg == jwt.SigningMethodHS256.Alg() {
+		return HMACKey(id, []byte(value)), nil
+	}
+
+	pem, err := os.ReadFile(value)
+	if err != nil {
+		return Key{}, fmt.Errorf("reading key %q: %w", id, err)
+	}
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		if priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+			return RSAKey(id, priv, nil), nil
+		}
+		pub, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q is not an RSA key in PEM form", id)
+		}
+		return RSAKey(id, nil, pub), nil
+
+	case jwt.SigningMethodEdDSA.Alg():
+		if priv, err := jwt.ParseEdPrivateKeyFromPEM(pem); err == nil {
+			if priv, ok := priv.(ed25519.PrivateKey); ok {
+				return Ed25519Key(id, priv, nil), nil
+			}
+		}
+		if pub, err := jwt.ParseEdPublicKeyFromPEM(pem); err == nil {
+			if pub, ok := pub.(ed25519.PublicKey); ok {
+				return Ed25519Key(id, nil, pub), nil
+			}
+		}
+		return Key{}, fmt.Errorf("key %q is not an Ed25519 key in PEM form", id)
+	}
+	return Key{}, fmt.Errorf("key %q has unsupported algorithm %q; use HS256, RS256 or EdDSA", id, alg)
+}
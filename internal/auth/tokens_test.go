@@ -0,0 +1,406 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ledger/internal/models"
+	"ledger/pkg/ledgerjwt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func rsaTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return priv
+}
+
+func ed25519TestKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating Ed25519 key: %v", err)
+	}
+	return priv
+}
+
+func newService(t *testing.T, active string, keys ...Key) *TokenService {
+	t.Helper()
+	s, err := NewTokenService("ledger", active, time.Hour, keys...)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	return s
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	rsaKey := rsaTestKey(t)
+	edKey := ed25519TestKey(t)
+
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{"HS256", HMACKey("hs", []byte("secret"))},
+		{"RS256", RSAKey("rs", rsaKey, nil)},
+		{"EdDSA", Ed25519Key("ed", edKey, nil)},
+		{"legacy", HMACKey("", []byte("secret"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newService(t, tt.key.ID, tt.key)
+			token, issued, err := s.Issue(7, models.RoleAdmin)
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &models.Claims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified: %v", err)
+			}
+			kid, hasKid := parsed.Header["kid"]
+			if tt.key.ID == "" && hasKid {
+				t.Errorf("legacy token has kid %v", kid)
+			}
+			if tt.key.ID != "" && kid != tt.key.ID {
+				t.Errorf("kid = %v, want %q", kid, tt.key.ID)
+			}
+			if parsed.Method.Alg() != tt.key.Method.Alg() {
+				t.Errorf("alg = %s, want %s", parsed.Method.Alg(), tt.key.Method.Alg())
+			}
+
+			claims, err := s.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if claims.UserID != 7 || claims.Role != models.RoleAdmin || claims.ID != issued.ID {
+				t.Errorf("claims = %+v, want user 7, admin, jti %s", claims, issued.ID)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey := HMACKey("2024-01", []byte("old secret"))
+	newKey := Ed25519Key("2024-06", ed25519TestKey(t), nil)
+
+	before := newService(t, oldKey.ID, oldKey)
+	during := newService(t, newKey.ID, oldKey, newKey)
+	after := newService(t, newKey.ID, newKey)
+
+	oldToken, _, err := before.Issue(7, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	newToken, _, err := during.Issue(7, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if _, err := during.Verify(oldToken); err != nil {
+		t.Errorf("token signed with the previous key rejected during rotation: %v", err)
+	}
+	if _, err := after.Verify(newToken); err != nil {
+		t.Errorf("token signed with the new key rejected after rotation: %v", err)
+	}
+	if _, err := after.Verify(oldToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token signed with a retired key: err = %v, want ErrInvalidToken", err)
+	}
+	if _, err := before.Verify(newToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token signed with an unknown key: err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	rsaKey := rsaTestKey(t)
+	s := newService(t, "hs", HMACKey("hs", []byte("secret")), RSAKey("rs", rsaKey, nil))
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims models.Claims) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("signing: %v", err)
+		}
+		return signed
+	}
+	claims := func(userID int64, role, jti string, expiresIn time.Duration) models.Claims {
+		c := models.Claims{UserID: userID, Role: role, RegisteredClaims: jwt.RegisteredClaims{ID: jti}}
+		if expiresIn != 0 {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(expiresIn))
+		}
+		return c
+	}
+	good := claims(7, models.RoleUser, "jti", time.Hour)
+
+	// The RSA public key, used as an HMAC secret, must not pass for the RSA key
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"wrong secret", sign(jwt.SigningMethodHS256, "hs", []byte("other"), good), ErrInvalidToken},
+		{"unknown kid", sign(jwt.SigningMethodHS256, "nope", []byte("secret"), good), ErrInvalidToken},
+		{"no kid without a legacy key", sign(jwt.SigningMethodHS256, "", []byte("secret"), good), ErrInvalidToken},
+		{"algorithm confusion", sign(jwt.SigningMethodHS256, "rs", pubPEM, good), ErrInvalidToken},
+		{"unsigned", sign(jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType, good), ErrInvalidToken},
+		{"expired", sign(jwt.SigningMethodHS256, "hs", []byte("secret"),
+			claims(7, models.RoleUser, "jti", -time.Hour)), ErrInvalidToken},
+		{"no expiry", sign(jwt.SigningMethodHS256, "hs", []byte("secret"),
+			claims(7, models.RoleUser, "jti", 0)), ErrInvalidToken},
+		{"no user", sign(jwt.SigningMethodHS256, "hs", []byte("secret"),
+			claims(0, models.RoleUser, "jti", time.Hour)), ErrInvalidClaims},
+		{"unknown role", sign(jwt.SigningMethodHS256, "hs", []byte("secret"),
+			claims(7, "root", "jti", time.Hour)), ErrInvalidClaims},
+		{"no token ID", sign(jwt.SigningMethodHS256, "hs", []byte("secret"),
+			claims(7, models.RoleUser, "", time.Hour)), ErrInvalidClaims},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Verify(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTokenServiceErrors(t *testing.T) {
+	rsaKey := rsaTestKey(t)
+	hs := HMACKey("hs", []byte("secret"))
+
+	tests := []struct {
+		name   string
+		active string
+		keys   []Key
+		want   error
+	}{
+		{"no keys", "", nil, ErrNoKeys},
+		{"unknown active key", "other", []Key{hs}, ErrUnknownKey},
+		{"verify-only active key", "rs", []Key{hs, RSAKey("rs", nil, &rsaKey.PublicKey)}, ErrCannotSign},
+		{"duplicate key ID", "hs", []Key{hs, hs}, ErrDuplicateKeyID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTokenService("ledger", tt.active, time.Hour, tt.keys...); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadTokenService(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey := rsaTestKey(t)
+	rsaPath := writePEM(t, dir, "rs.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	edKey := ed25519TestKey(t)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	edPath := writePEM(t, dir, "ed.pem", "PRIVATE KEY", edDER)
+
+	retired := ed25519TestKey(t)
+	retiredDER, err := x509.MarshalPKIXPublicKey(retired.Public())
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	retiredPath := writePEM(t, dir, "retired.pem", "PUBLIC KEY", retiredDER)
+
+	t.Run("JWT_SECRET only", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "legacy")
+		t.Setenv("JWT_KEYS", "")
+		t.Setenv("JWT_ACTIVE_KID", "")
+		t.Setenv("ACCESS_TOKEN_TTL", "5m")
+
+		s, err := LoadTokenService()
+		if err != nil {
+			t.Fatalf("LoadTokenService: %v", err)
+		}
+		if s.Lifetime() != 5*time.Minute {
+			t.Errorf("lifetime = %s, want 5m", s.Lifetime())
+		}
+		token := sign(t, HMACKey("", []byte("legacy")))
+		if _, err := s.Verify(token); err != nil {
+			t.Errorf("legacy token rejected: %v", err)
+		}
+	})
+
+	t.Run("JWT_KEYS", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "legacy")
+		t.Setenv("JWT_KEYS", "hs:HS256:a:secret:with:colons, rs:RS256:"+rsaPath+", ed:EdDSA:"+edPath+
+			", old:EdDSA:"+retiredPath)
+		t.Setenv("JWT_ACTIVE_KID", "ed")
+		t.Setenv("ACCESS_TOKEN_TTL", "")
+
+		s, err := LoadTokenService()
+		if err != nil {
+			t.Fatalf("LoadTokenService: %v", err)
+		}
+		if s.active.ID != "ed" || s.Lifetime() != defaultAccessTokenLifetime {
+			t.Errorf("active = %q, lifetime = %s", s.active.ID, s.Lifetime())
+		}
+
+		for _, k := range []Key{
+			HMACKey("", []byte("legacy")),
+			HMACKey("hs", []byte("a:secret:with:colons")),
+			RSAKey("rs", rsaKey, nil),
+			Ed25519Key("ed", edKey, nil),
+			Ed25519Key("old", retired, nil),
+		} {
+			if _, err := s.Verify(sign(t, k)); err != nil {
+				t.Errorf("token signed with %q rejected: %v", k.ID, err)
+			}
+		}
+	})
+
+	t.Run("active key defaults to the first listed", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "")
+		t.Setenv("JWT_KEYS", "rs:RS256:"+rsaPath+",ed:EdDSA:"+edPath)
+		t.Setenv("JWT_ACTIVE_KID", "")
+
+		s, err := LoadTokenService()
+		if err != nil {
+			t.Fatalf("LoadTokenService: %v", err)
+		}
+		if s.active.ID != "rs" {
+			t.Errorf("active = %q, want rs", s.active.ID)
+		}
+	})
+
+	for name, env := range map[string]map[string]string{
+		"nothing configured":           {"JWT_SECRET": "", "JWT_KEYS": ""},
+		"malformed entry":              {"JWT_KEYS": "hs:HS256"},
+		"unsupported alg":              {"JWT_KEYS": "es:ES256:" + edPath},
+		"missing file":                 {"JWT_KEYS": "rs:RS256:" + filepath.Join(dir, "missing.pem")},
+		"wrong key type":               {"JWT_KEYS": "rs:RS256:" + edPath},
+		"retired active key":           {"JWT_KEYS": "old:EdDSA:" + retiredPath},
+		"unknown active key":           {"JWT_KEYS": "ed:EdDSA:" + edPath, "JWT_ACTIVE_KID": "nope"},
+		"duplicate key ID":             {"JWT_KEYS": "ed:EdDSA:" + edPath + ",ed:HS256:secret"},
+		"external issuer without keys": {"JWT_KEYS": "ed:EdDSA:" + edPath, "JWT_EXTERNAL_ISSUER": "identity"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "")
+			t.Setenv("JWT_ACTIVE_KID", "")
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := LoadTokenService(); err == nil {
+				t.Error("LoadTokenService succeeded, want an error")
+			}
+		})
+	}
+}
+
+// sign issues a token with a service that has only k, as the server that
+// last signed with k would have.
+func sign(t *testing.T, k Key) string {
+	t.Helper()
+	token, _, err := newService(t, k.ID, k).Issue(7, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	return token
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := rsaTestKey(t)
+	edKey := ed25519TestKey(t)
+	s := newService(t, "ed",
+		HMACKey("", []byte("legacy")),
+		HMACKey("hs", []byte("secret")),
+		RSAKey("rs", rsaKey, nil),
+		Ed25519Key("ed", edKey, nil),
+	)
+
+	set := s.JWKS()
+	var kids []string
+	for _, k := range set.Keys {
+		kids = append(kids, k.Kid+"/"+k.Alg)
+	}
+	if len(kids) != 2 || kids[0] != "ed/EdDSA" || kids[1] != "rs/RS256" {
+		t.Errorf("published keys = %v, want [ed/EdDSA rs/RS256]", kids)
+	}
+}
+
+func TestTrustedIssuer(t *testing.T) {
+	external := newService(t, "ext", Ed25519Key("ext", ed25519TestKey(t), nil))
+	external.issuer = "identity"
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(external.JWKS())
+	}))
+	defer jwks.Close()
+
+	s := newService(t, "hs", HMACKey("hs", []byte("secret")))
+	token, _, err := external.Issue(7, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if _, err := s.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token from an untrusted issuer: err = %v, want ErrInvalidToken", err)
+	}
+
+	if err := s.TrustIssuer("identity", ledgerjwt.NewVerifier(jwks.URL, ledgerjwt.WithIssuer("identity"))); err != nil {
+		t.Fatalf("TrustIssuer: %v", err)
+	}
+	claims, err := s.Verify(token)
+	if err != nil {
+		t.Fatalf("token from a trusted issuer: %v", err)
+	}
+	if claims.UserID != 7 || claims.Issuer != "identity" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// Its own tokens still verify with its own keys
+	own, _, err := s.Issue(7, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := s.Verify(own); err != nil {
+		t.Errorf("own token: %v", err)
+	}
+
+	for _, iss := range []string{"", "ledger"} {
+		if err := s.TrustIssuer(iss, ledgerjwt.NewVerifier(jwks.URL)); !errors.Is(err, ErrUnknownIssuer) {
+			t.Errorf("TrustIssuer(%q): err = %v, want ErrUnknownIssuer", iss, err)
+		}
+	}
+}
@@ -51,10 +51,30 @@ func createTables(db *sql.DB) error {
 			email VARCHAR(255) UNIQUE NOT NULL,
 			password_hash VARCHAR(255) NOT NULL,
 			role VARCHAR(20) NOT NULL CHECK (role IN ('user', 'admin')),
-			balance DECIMAL(10,2) DEFAULT 0.00,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);
 		
+		-- Account lifecycle. Frozen users cannot be debited, nor credited
+		-- when block_credits is set; closed users can do neither.
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS block_credits BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE users DROP CONSTRAINT IF EXISTS valid_user_status;
+		ALTER TABLE users ADD CONSTRAINT valid_user_status CHECK (status IN ('active', 'frozen', 'closed'));
+
+		CREATE TABLE IF NOT EXISTS user_status_changes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			old_status VARCHAR(20) NOT NULL,
+			new_status VARCHAR(20) NOT NULL,
+			block_credits BOOLEAN NOT NULL DEFAULT false,
+			reason TEXT NOT NULL,
+			sweep_to_user_id INTEGER REFERENCES users(id),
+			changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_user_status_changes_user ON user_status_changes(user_id, created_at);
+
 		CREATE TABLE IF NOT EXISTS transactions (
 			id SERIAL PRIMARY KEY,
 			from_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
@@ -72,6 +92,392 @@ func createTables(db *sql.DB) error {
 
 		CREATE INDEX IF NOT EXISTS idx_transactions_users ON transactions(from_user_id, to_user_id);
 		CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions(created_at);
+
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+
+		-- Widen amount to the precision of every other money column.
+		ALTER TABLE transactions ALTER COLUMN amount TYPE DECIMAL(12,2);
+
+		-- Cross-currency transfers keep the rate they were converted at and
+		-- what the recipient received; amount and currency are the source side.
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fx_rate NUMERIC(20,10);
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS target_amount DECIMAL(12,2);
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS target_currency VARCHAR(3);
+
+		-- Reversals and refunds point back at the transaction they
+		-- compensate. Their amount and currency are on the original's source
+		-- side, and the original row itself is never touched.
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_transaction_id INTEGER REFERENCES transactions(id);
+		CREATE INDEX IF NOT EXISTS idx_transactions_original ON transactions(original_transaction_id);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_single_reversal
+			ON transactions(original_transaction_id) WHERE type = 'reversal';
+
+		-- Transfers made together by the batch endpoint share a batch_id.
+		CREATE TABLE IF NOT EXISTS transfer_batches (
+			id SERIAL PRIMARY KEY,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS batch_id INTEGER REFERENCES transfer_batches(id);
+		CREATE INDEX IF NOT EXISTS idx_transactions_batch ON transactions(batch_id);
+
+		-- Transaction types added since the table was first created. Types
+		-- that concern a single user leave from_user_id empty, like credits.
+		ALTER TABLE transactions DROP CONSTRAINT IF EXISTS valid_transaction_type;
+		ALTER TABLE transactions ADD CONSTRAINT valid_transaction_type CHECK (
+			type IN ('credit', 'transfer', 'withdrawal', 'capture', 'reversal', 'refund')
+		);
+		ALTER TABLE transactions DROP CONSTRAINT IF EXISTS valid_transaction;
+		ALTER TABLE transactions ADD CONSTRAINT valid_transaction CHECK (
+			(type = 'transfer' AND from_user_id IS NOT NULL) OR
+			(type IN ('credit', 'withdrawal', 'capture') AND from_user_id IS NULL) OR
+			(type IN ('reversal', 'refund') AND original_transaction_id IS NOT NULL)
+		);
+
+		-- Double-entry core. Every account holds a single currency and is
+		-- either owned by a user or is a system account identified by its
+		-- code. Balances are cached on the account row and must always equal
+		-- the sum of its postings.
+		CREATE TABLE IF NOT EXISTS accounts (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			code VARCHAR(50),
+			currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			balance DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+			overdraft_limit DECIMAL(12,2) NOT NULL DEFAULT 0.00 CHECK (overdraft_limit >= 0),
+			account_type VARCHAR(20) NOT NULL DEFAULT 'checking' CHECK (account_type IN ('checking', 'savings')),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT account_owner CHECK ((user_id IS NULL) <> (code IS NULL))
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_user_currency ON accounts(user_id, currency);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_code_currency ON accounts(code, currency);
+
+		-- Audit trail of overdraft limits: every change, who made it and when.
+		CREATE TABLE IF NOT EXISTS overdraft_limit_changes (
+			id SERIAL PRIMARY KEY,
+			account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+			old_limit DECIMAL(12,2) NOT NULL,
+			new_limit DECIMAL(12,2) NOT NULL,
+			reason TEXT,
+			changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_overdraft_limit_changes_account ON overdraft_limit_changes(account_id, created_at);
+
+		CREATE TABLE IF NOT EXISTS journal_entries (
+			id SERIAL PRIMARY KEY,
+			transaction_id INTEGER REFERENCES transactions(id) ON DELETE CASCADE,
+			description TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		-- balance_after is the account balance right after the posting. The
+		-- account row is locked while a posting is written, so per account
+		-- both id and the wall-clock created_at follow posting order.
+		CREATE TABLE IF NOT EXISTS postings (
+			id SERIAL PRIMARY KEY,
+			entry_id INTEGER NOT NULL REFERENCES journal_entries(id) ON DELETE CASCADE,
+			account_id INTEGER NOT NULL REFERENCES accounts(id),
+			amount DECIMAL(12,2) NOT NULL,
+			balance_after DECIMAL(12,2) NOT NULL,
+			created_at TIMESTAMP DEFAULT clock_timestamp()
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_postings_entry ON postings(entry_id);
+		CREATE INDEX IF NOT EXISTS idx_postings_account_created_at ON postings(account_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_postings_created_at ON postings(created_at);
+
+		-- A balance snapshot is an account's balance after every posting made
+		-- before taken_at. Accounts are only snapshotted for periods in which
+		-- they had postings.
+		CREATE TABLE IF NOT EXISTS balance_snapshots (
+			id SERIAL PRIMARY KEY,
+			account_id INTEGER NOT NULL REFERENCES accounts(id),
+			taken_at TIMESTAMP NOT NULL,
+			balance DECIMAL(12,2) NOT NULL,
+			UNIQUE (account_id, taken_at)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_balance_snapshots_taken_at ON balance_snapshots(taken_at);
+
+		-- FX rates are append-only; the latest row for a pair is the one in
+		-- force. A rate is the price of one unit of base in quote.
+		CREATE TABLE IF NOT EXISTS fx_rates (
+			id SERIAL PRIMARY KEY,
+			base_currency VARCHAR(3) NOT NULL,
+			quote_currency VARCHAR(3) NOT NULL,
+			rate NUMERIC(20,10) NOT NULL CHECK (rate > 0),
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT distinct_currencies CHECK (base_currency <> quote_currency)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_fx_rates_pair ON fx_rates(base_currency, quote_currency, id DESC);
+
+		-- Authorization holds reserve part of an account's balance. A pending
+		-- hold reduces the available balance until it is captured, voided or
+		-- passes expires_at; only a capture posts to the journal.
+		CREATE TABLE IF NOT EXISTS holds (
+			id SERIAL PRIMARY KEY,
+			account_id INTEGER NOT NULL REFERENCES accounts(id),
+			amount DECIMAL(12,2) NOT NULL CHECK (amount > 0),
+			captured_amount DECIMAL(12,2),
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			description TEXT,
+			transaction_id INTEGER REFERENCES transactions(id),
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT valid_hold_status CHECK (status IN ('pending', 'captured', 'voided', 'expired'))
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_holds_pending ON holds(account_id, expires_at) WHERE status = 'pending';
+
+		-- Responses to money-moving requests, keyed by the caller's
+		-- Idempotency-Key. status_code stays NULL while the request runs.
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			id SERIAL PRIMARY KEY,
+			scope VARCHAR(100) NOT NULL,
+			key VARCHAR(255) NOT NULL,
+			request_hash CHAR(64) NOT NULL,
+			status_code INTEGER,
+			content_type VARCHAR(255),
+			response_body BYTEA,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (scope, key)
+		);
+
+		-- Refresh tokens, stored as SHA-256 hashes. A login starts a family
+		-- and every refresh uses up its token for the next one in the family.
+		-- access_jti is the access token issued alongside, so that revoking
+		-- the family can deny it as well.
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id SERIAL PRIMARY KEY,
+			family_id CHAR(32) NOT NULL,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash CHAR(64) UNIQUE NOT NULL,
+			access_jti CHAR(32) NOT NULL,
+			access_expires_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+		CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(user_id) WHERE revoked_at IS NULL;
+		CREATE INDEX IF NOT EXISTS idx_refresh_tokens_access_jti ON refresh_tokens(access_jti);
+
+		-- Access tokens revoked before they expire. A row can go once its
+		-- token would have expired anyway.
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti CHAR(32) PRIMARY KEY,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		-- API keys machine clients authenticate with. Only a hash of each
+		-- key is kept; the prefix, which is part of the key, finds its row.
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			prefix CHAR(8) UNIQUE NOT NULL,
+			key_hash CHAR(64) NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			revoked_by INTEGER REFERENCES users(id),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		-- Transfers above the approval threshold wait here for a decision by
+		-- an admin other than the one who requested them.
+		CREATE TABLE IF NOT EXISTS transfer_approvals (
+			id SERIAL PRIMARY KEY,
+			from_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			to_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			amount DECIMAL(12,2) NOT NULL CHECK (amount > 0),
+			currency VARCHAR(3) NOT NULL,
+			to_currency VARCHAR(3) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			requested_by INTEGER NOT NULL REFERENCES users(id),
+			decided_by INTEGER REFERENCES users(id),
+			decision_reason TEXT,
+			transaction_id INTEGER REFERENCES transactions(id),
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			decided_at TIMESTAMP,
+			CONSTRAINT valid_approval_status CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+			CONSTRAINT four_eyes CHECK (decided_by IS NULL OR decided_by <> requested_by)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_transfer_approvals_pending ON transfer_approvals(expires_at) WHERE status = 'pending';
+
+		-- Fees charged on transfers and withdrawals. Every active schedule of
+		-- an operation and currency is charged and posted to the fees account.
+		CREATE TABLE IF NOT EXISTS fee_schedules (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			operation VARCHAR(20) NOT NULL,
+			currency VARCHAR(3) NOT NULL,
+			kind VARCHAR(20) NOT NULL,
+			flat_amount DECIMAL(12,2),
+			percent DECIMAL(7,4),
+			tiers JSONB,
+			min_fee DECIMAL(12,2),
+			max_fee DECIMAL(12,2),
+			active BOOLEAN NOT NULL DEFAULT true,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT valid_fee_operation CHECK (operation IN ('transfer', 'withdrawal')),
+			CONSTRAINT valid_fee_kind CHECK (kind IN ('flat', 'percentage', 'tiered'))
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_fee_schedules_active ON fee_schedules(operation, currency) WHERE active;
+
+		-- Annual interest rates per account type and currency. Earlier rates
+		-- are kept; the latest one effective on a day applies to that day.
+		CREATE TABLE IF NOT EXISTS interest_rates (
+			id SERIAL PRIMARY KEY,
+			account_type VARCHAR(20) NOT NULL,
+			currency VARCHAR(3) NOT NULL,
+			annual_rate DECIMAL(7,4) NOT NULL CHECK (annual_rate >= 0),
+			day_count VARCHAR(10) NOT NULL,
+			effective_from DATE NOT NULL,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT valid_day_count CHECK (day_count IN ('ACT/365', '30/360'))
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_interest_rates_lookup ON interest_rates(account_type, currency, effective_from);
+
+		-- One row per day interest has been accrued for, so that a day is
+		-- never accrued twice.
+		CREATE TABLE IF NOT EXISTS interest_accrual_runs (
+			accrual_date DATE PRIMARY KEY,
+			accounts INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		-- Interest earned by an account on a day, kept unrounded until the
+		-- month's accruals are posted together as a credit.
+		CREATE TABLE IF NOT EXISTS interest_accruals (
+			id SERIAL PRIMARY KEY,
+			account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+			accrual_date DATE NOT NULL,
+			balance DECIMAL(12,2) NOT NULL,
+			annual_rate DECIMAL(7,4) NOT NULL,
+			day_count VARCHAR(10) NOT NULL,
+			amount DECIMAL(20,10) NOT NULL,
+			transaction_id INTEGER REFERENCES transactions(id),
+			posted_at TIMESTAMP,
+			UNIQUE (account_id, accrual_date)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_interest_accruals_unposted ON interest_accruals(accrual_date) WHERE posted_at IS NULL;
+
+		-- Limits on transfers and withdrawals, per role or per user. A rule for
+		-- a user overrides the rule for their role with the same kind,
+		-- operation, currency and window.
+		CREATE TABLE IF NOT EXISTS limit_rules (
+			id SERIAL PRIMARY KEY,
+			kind VARCHAR(30) NOT NULL,
+			operation VARCHAR(20) NOT NULL,
+			role VARCHAR(20),
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			currency VARCHAR(3),
+			max_amount DECIMAL(12,2) CHECK (max_amount > 0),
+			max_count INTEGER CHECK (max_count > 0),
+			window_seconds INTEGER CHECK (window_seconds > 0),
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT valid_limit_kind CHECK (kind IN ('max_single_amount', 'max_total_amount', 'max_count')),
+			CONSTRAINT valid_limit_operation CHECK (operation IN ('transfer', 'withdrawal')),
+			CONSTRAINT limit_subject CHECK ((role IS NULL) <> (user_id IS NULL))
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_limit_rules_user ON limit_rules(user_id);
+		CREATE INDEX IF NOT EXISTS idx_limit_rules_role ON limit_rules(role);
+
+		-- Transfers to be made later, once or on a recurring schedule. The
+		-- worker runs active schedules whose next_run_at has passed and
+		-- records the outcome of every run in scheduled_transfer_runs.
+		CREATE TABLE IF NOT EXISTS scheduled_transfers (
+			id SERIAL PRIMARY KEY,
+			from_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			to_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			amount DECIMAL(12,2) NOT NULL CHECK (amount > 0),
+			currency VARCHAR(3) NOT NULL,
+			to_currency VARCHAR(3) NOT NULL,
+			frequency VARCHAR(20) NOT NULL,
+			day_of_month INTEGER CHECK (day_of_month BETWEEN 1 AND 31),
+			status VARCHAR(20) NOT NULL DEFAULT 'active',
+			next_run_at TIMESTAMP,
+			last_run_at TIMESTAMP,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT valid_schedule_frequency CHECK (frequency IN ('once', 'daily', 'weekly', 'monthly')),
+			CONSTRAINT valid_schedule_status CHECK (status IN ('active', 'paused', 'cancelled', 'completed'))
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_due ON scheduled_transfers(next_run_at) WHERE status = 'active';
+		CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_from_user ON scheduled_transfers(from_user_id);
+
+		CREATE TABLE IF NOT EXISTS scheduled_transfer_runs (
+			id SERIAL PRIMARY KEY,
+			schedule_id INTEGER NOT NULL REFERENCES scheduled_transfers(id) ON DELETE CASCADE,
+			scheduled_for TIMESTAMP NOT NULL,
+			status VARCHAR(30) NOT NULL,
+			transaction_id INTEGER REFERENCES transactions(id),
+			message TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (schedule_id, scheduled_for),
+			CONSTRAINT valid_run_status CHECK (status IN ('succeeded', 'insufficient_balance', 'failed'))
+		);
+
+		-- Runs above the approval threshold wait for a transfer approval
+		ALTER TABLE scheduled_transfer_runs ADD COLUMN IF NOT EXISTS approval_id INTEGER REFERENCES transfer_approvals(id);
+		ALTER TABLE scheduled_transfer_runs DROP CONSTRAINT IF EXISTS valid_run_status;
+		ALTER TABLE scheduled_transfer_runs ADD CONSTRAINT valid_run_status CHECK (
+			status IN ('succeeded', 'insufficient_balance', 'failed', 'pending_approval')
+		);
+
+		-- Reject any journal entry whose postings do not sum to zero in every
+		-- currency. The check is deferred to commit so that the legs can be
+		-- inserted one by one.
+		CREATE OR REPLACE FUNCTION check_entry_balanced() RETURNS trigger AS $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1
+				FROM postings p
+				JOIN accounts a ON a.id = p.account_id
+				WHERE p.entry_id = NEW.entry_id
+				GROUP BY a.currency
+				HAVING SUM(p.amount) <> 0
+			) THEN
+				RAISE EXCEPTION 'journal entry % is unbalanced', NEW.entry_id;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'postings_balanced') THEN
+				CREATE CONSTRAINT TRIGGER postings_balanced
+					AFTER INSERT OR UPDATE ON postings
+					DEFERRABLE INITIALLY DEFERRED
+					FOR EACH ROW EXECUTE FUNCTION check_entry_balanced();
+			END IF;
+		END $$;
 	`
 
 	_, err := db.Exec(query)
@@ -80,6 +486,69 @@ func createTables(db *sql.DB) error {
 		return err
 	}
 
+	if err = migrateUserBalances(db); err != nil {
+		log.Printf("Error migrating user balances: %v", err)
+		return err
+	}
+
 	log.Printf("Tables created successfully")
 	return nil
 }
+
+// migrateUserBalances moves databases created before the double-entry core
+// off the users.balance column. Every user gets an account in the default
+// currency, and any existing balance is booked as an opening entry against
+// the opening_balance account before the column is dropped. It is a no-op
+// once the column is gone.
+func migrateUserBalances(db *sql.DB) error {
+	query := `
+		DO $$
+		DECLARE
+			opening INTEGER;
+			entry INTEGER;
+			user_balance DECIMAL(12,2);
+			opening_balance DECIMAL(12,2);
+			r RECORD;
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'users' AND column_name = 'balance'
+			) THEN
+				RETURN;
+			END IF;
+
+			INSERT INTO accounts (user_id)
+			SELECT u.id FROM users u
+			WHERE NOT EXISTS (SELECT 1 FROM accounts a WHERE a.user_id = u.id);
+
+			INSERT INTO accounts (code) VALUES ('opening_balance')
+			ON CONFLICT (code, currency) DO NOTHING;
+
+			SELECT id INTO opening FROM accounts WHERE code = 'opening_balance' AND currency = 'USD';
+
+			FOR r IN
+				SELECT a.id AS account_id, u.balance
+				FROM users u JOIN accounts a ON a.user_id = u.id
+				WHERE u.balance <> 0
+			LOOP
+				INSERT INTO journal_entries (description)
+				VALUES ('Opening balance migrated from users.balance')
+				RETURNING id INTO entry;
+
+				UPDATE accounts SET balance = balance + r.balance WHERE id = r.account_id
+				RETURNING balance INTO user_balance;
+				UPDATE accounts SET balance = balance - r.balance WHERE id = opening
+				RETURNING balance INTO opening_balance;
+
+				INSERT INTO postings (entry_id, account_id, amount, balance_after)
+				VALUES (entry, r.account_id, r.balance, user_balance),
+					(entry, opening, -r.balance, opening_balance);
+			END LOOP;
+
+			ALTER TABLE users DROP COLUMN balance;
+		END $$;
+	`
+
+	_, err := db.Exec(query)
+	return err
+}
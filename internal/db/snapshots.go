@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultSnapshotInterval = time.Hour
+
+// snapshotSettleDelay is how far behind the clock snapshots are taken.
+// Postings are stamped when they are written, not when their transaction
+// commits, so a period is only snapshotted once in-flight transactions that
+// wrote into it have had time to commit.
+const snapshotSettleDelay = time.Minute
+
+// snapshotBoundarySQL is the latest period boundary that has settled.
+// Periods are aligned to the start of 2000-01-01, so hourly and daily
+// snapshots fall on the hour and at midnight.
+const snapshotBoundarySQL = `date_bin($1::interval, LOCALTIMESTAMP - $2::interval, TIMESTAMP '2000-01-01')`
+
+// SnapshotInterval returns the period between balance snapshots, set with
+// BALANCE_SNAPSHOT_INTERVAL (e.g. "1h" or "24h").
+func SnapshotInterval() (time.Duration, error) {
+	v := os.Getenv("BALANCE_SNAPSHOT_INTERVAL")
+	if v == "" {
+		return defaultSnapshotInterval, nil
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval < time.Minute {
+		return 0, fmt.Errorf("invalid BALANCE_SNAPSHOT_INTERVAL %q: must be a duration of at least 1m", v)
+	}
+	return interval, nil
+}
+
+// TakeBalanceSnapshots snapshots every account with postings since the
+// last snapshot at the latest settled period boundary. Running it again
+// within the same period does nothing.
+func TakeBalanceSnapshots(db *sql.DB, interval time.Duration) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO balance_snapshots (account_id, taken_at, balance)
+		SELECT DISTINCT ON (p.account_id) p.account_id, b.at, p.balance_after
+		FROM postings p, (SELECT `+snapshotBoundarySQL+` AS at) b
+		WHERE p.created_at < b.at
+		AND p.created_at >= COALESCE((SELECT MAX(taken_at) FROM balance_snapshots), '-infinity')
+		ORDER BY p.account_id, p.created_at DESC, p.id DESC
+		ON CONFLICT (account_id, taken_at) DO NOTHING`,
+		pgInterval(interval), pgInterval(snapshotSettleDelay))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RebuildBalanceSnapshots replaces all snapshots with ones computed from
+// the full posting history: one per account for every period in which it
+// had postings, up to the latest settled boundary. Balances are summed from
+// the posting amounts rather than taken from their running balances.
+func RebuildBalanceSnapshots(db *sql.DB, interval time.Duration) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Keep the snapshot job out until the rebuild commits
+	if _, err = tx.Exec("LOCK TABLE balance_snapshots IN EXCLUSIVE MODE"); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec("DELETE FROM balance_snapshots"); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO balance_snapshots (account_id, taken_at, balance)
+		SELECT account_id, taken_at, SUM(amount) OVER (PARTITION BY account_id ORDER BY taken_at)
+		FROM (
+			SELECT p.account_id,
+				date_bin($1::interval, p.created_at, TIMESTAMP '2000-01-01') + $1::interval AS taken_at,
+				SUM(p.amount) AS amount
+			FROM postings p, (SELECT `+snapshotBoundarySQL+` AS at) b
+			WHERE p.created_at < b.at
+			GROUP BY 1, 2
+		) periods`,
+		pgInterval(interval), pgInterval(snapshotSettleDelay))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// pgInterval formats d as a PostgreSQL interval literal.
+func pgInterval(d time.Duration) string {
+	return fmt.Sprintf("%d microseconds", d.Microseconds())
+}
@@ -0,0 +1,61 @@
+// Package jobs runs the ledger's background work - expiring holds, taking
+// balance snapshots, running scheduled transfers, accruing interest - next
+// to the HTTP server.
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a piece of background work run once every Interval. Runs of the
+// same job never overlap, so Run only has to be safe against other
+// processes running it at the same time.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Runner runs a set of jobs, each on its own schedule.
+type Runner struct {
+	logger *log.Logger
+	jobs   []Job
+}
+
+func NewRunner(logger *log.Logger, jobs ...Job) *Runner {
+	return &Runner{logger: logger, jobs: jobs}
+}
+
+// Run runs every job once per interval, starting one interval from now,
+// until ctx is cancelled. It returns once the runs in progress have
+// finished. Failed runs are logged and retried at the next interval.
+func (r *Runner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range r.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			r.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (r *Runner) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := job.Run(ctx); err != nil {
+				r.logger.Printf("Error running job %s: %v", job.Name, err)
+			}
+		}
+	}
+}
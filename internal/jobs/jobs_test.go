@@ -0,0 +1,55 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunnerRunsJobsUntilCancelled(t *testing.T) {
+	var ok, failing int32
+	runner := NewRunner(log.New(io.Discard, "", 0),
+		Job{Name: "ok", Interval: time.Millisecond, Run: func(context.Context) error {
+			atomic.AddInt32(&ok, 1)
+			return nil
+		}},
+		Job{Name: "failing", Interval: time.Millisecond, Run: func(context.Context) error {
+			atomic.AddInt32(&failing, 1)
+			return errors.New("boom")
+		}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runner.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for atomic.LoadInt32(&ok) < 3 || atomic.LoadInt32(&failing) < 3 {
+		select {
+		case <-deadline:
+			t.Fatalf("Jobs did not keep running: ok ran %d times, failing %d",
+				atomic.LoadInt32(&ok), atomic.LoadInt32(&failing))
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+
+	stopped := atomic.LoadInt32(&ok)
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&ok); n != stopped {
+		t.Errorf("Job ran %d more times after Run returned", n-stopped)
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"ledger/internal/auth"
+	"ledger/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// APIKeyHeader is the header machine clients send their API key in,
+// instead of a bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// WithAPIKey returns a copy of ctx carrying the API key the caller
+// authenticated with.
+func WithAPIKey(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey, key)
+}
+
+// APIKeyFromContext returns the API key the request was authenticated with,
+// and false if it was not made with one.
+func APIKeyFromContext(ctx context.Context) (*models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyKey).(*models.APIKey)
+	return key, ok && key != nil
+}
+
+// Scope returns a middleware that lets API keys holding scope call the
+// route. Routes without one cannot be called with an API key at all. It has
+// to run before AuthMiddleware, which enforces it.
+func Scope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopeKey, scope)))
+		})
+	}
+}
+
+// APIKeyStore looks up the API key a request was sent with. It returns nil
+// for keys that do not exist or can no longer be used.
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+type sqlAPIKeys struct {
+	db     *sql.DB
+	logger *log.Logger
+}
+
+// SQLAPIKeys returns the APIKeyStore kept in the api_keys table. Failures to
+// record when a key was last used are logged to logger.
+func SQLAPIKeys(db *sql.DB, logger *log.Logger) APIKeyStore {
+	return sqlAPIKeys{db: db, logger: logger}
+}
+
+// LookupAPIKey finds a key by its prefix and checks the rest of it against
+// the stored hash. Keys stop working once revoked or expired, and when the
+// admin who created them is no longer an admin or has been closed.
+func (s sqlAPIKeys) LookupAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	prefix, ok := auth.ParseAPIKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	var (
+		k         models.APIKey
+		hash      string
+		expiresAt sql.NullTime
+		lastUsed  sql.NullTime
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT k.id, k.name, k.prefix, k.key_hash, k.scopes, k.created_by, k.expires_at, k.last_used_at, k.created_at
+		FROM api_keys k
+		JOIN users u ON u.id = k.created_by
+		WHERE k.prefix = $1
+		AND k.revoked_at IS NULL
+		AND (k.expires_at IS NULL OR k.expires_at > NOW())
+		AND u.role = 'admin' AND u.status <> 'closed'`,
+		prefix).Scan(&k.ID, &k.Name, &k.Prefix, &hash, pq.Array(&k.Scopes), &k.CreatedBy,
+		&expiresAt, &lastUsed, &k.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(auth.HashAPIKey(key))) != 1 {
+		return nil, nil
+	}
+
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+
+	// Record use at most once a minute, to spare busy keys a write per
+	// request. The write does not depend on the request, so it is not
+	// cancelled with it.
+	if !lastUsed.Valid || time.Since(lastUsed.Time) > time.Minute {
+		_, err := s.db.ExecContext(context.WithoutCancel(ctx), "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", k.ID)
+		if err != nil {
+			s.logger.Printf("Error recording use of API key %d: %v", k.ID, err)
+		}
+	}
+	return &k, nil
+}
@@ -1,85 +1,153 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
-	"time"
 
+	"ledger/internal/auth"
 	"ledger/internal/models"
 	"ledger/internal/utils"
+)
+
+// contextKey is the type of the keys this package stores request values
+// under, so they cannot collide with anyone else's.
+type contextKey int
 
-	"github.com/golang-jwt/jwt/v5"
+const (
+	claimsKey contextKey = iota
+	apiKeyKey
+	scopeKey
 )
 
-const secretKey = "your-secret-key-change-in-production" // In production, use environment variable
-
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(userID int64, role string) (string, error) {
-	claims := models.Claims{
-		UserID: userID,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	}
+// WithClaims returns a copy of ctx carrying the claims of the authenticated
+// caller.
+func WithClaims(ctx context.Context, claims *models.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secretKey))
+// ClaimsFromContext returns the claims AuthMiddleware verified for the
+// request, and false if the request was not authenticated.
+func ClaimsFromContext(ctx context.Context) (*models.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*models.Claims)
+	return claims, ok && claims != nil
 }
 
-// AuthMiddleware returns a chi-compatible middleware
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
-			return
-		}
+// Denylist tells AuthMiddleware whether an access token was revoked before
+// it expired.
+type Denylist interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
 
-		bearerToken := strings.Split(authHeader, " ")
-		if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
-			http.Error(w, "Invalid token format", http.StatusUnauthorized)
-			return
-		}
+type sqlDenylist struct {
+	db *sql.DB
+}
+
+// SQLDenylist returns the Denylist kept in the revoked_tokens table.
+func SQLDenylist(db *sql.DB) Denylist {
+	return sqlDenylist{db: db}
+}
+
+func (d sqlDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := d.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+// AuthMiddleware returns a middleware that verifies the bearer token with
+// tokens, turns away tokens on the denylist, and stores the token's claims
+// in the request context, where ClaimsFromContext finds them.
+//
+// Requests may send an API key in the X-API-Key header instead. The key
+// must hold the scope the route was given with Scope, and then acts as an
+// admin on behalf of the admin who created it.
+func AuthMiddleware(tokens *auth.TokenService, denylist Denylist, apiKeys APIKeyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				authenticateAPIKey(w, r, next, apiKeys, key)
+				return
+			}
+
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				return
+			}
+
+			bearerToken := strings.Split(authHeader, " ")
+			if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
+				http.Error(w, "Invalid token format", http.StatusUnauthorized)
+				return
+			}
 
-		tokenString := bearerToken[1]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			claims, err := tokens.Verify(bearerToken[1])
+			if errors.Is(err, auth.ErrInvalidClaims) {
+				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+				return
 			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			revoked, err := denylist.IsRevoked(r.Context(), claims.ID)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
 		})
+	}
+}
 
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, apiKeys APIKeyStore, key string) {
+	if r.Header.Get("Authorization") != "" {
+		http.Error(w, "Send either a bearer token or an API key, not both", http.StatusUnauthorized)
+		return
+	}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Add claims to request context
-			r.Header.Set("user_id", fmt.Sprintf("%v", claims["user_id"]))
-			r.Header.Set("user_role", fmt.Sprintf("%v", claims["role"]))
-			next.ServeHTTP(w, r)
-		} else {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
-			return
-		}
-	})
+	apiKey, err := apiKeys.LookupAPIKey(r.Context(), key)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if apiKey == nil {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	scope, _ := r.Context().Value(scopeKey).(string)
+	if scope == "" {
+		http.Error(w, "API keys cannot be used for this route", http.StatusForbidden)
+		return
+	}
+	if !apiKey.HasScope(scope) {
+		http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	claims := &models.Claims{UserID: apiKey.CreatedBy, Role: models.RoleAdmin}
+	next.ServeHTTP(w, r.WithContext(WithAPIKey(WithClaims(r.Context(), claims), apiKey)))
 }
 
 // AdminOnly middleware restricts access to admin users
 func AdminOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims, ok := r.Context().Value("user").(*models.Claims)
+		claims, ok := ClaimsFromContext(r.Context())
 		if !ok {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		if claims.Role != "admin" {
+		if claims.Role != models.RoleAdmin {
 			http.Error(w, "Admin access required", http.StatusForbidden)
 			return
 		}
@@ -91,14 +159,14 @@ func AdminOnly(next http.Handler) http.Handler {
 // OwnerOrAdmin middleware allows access to resource owner or admin
 func OwnerOrAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims, ok := r.Context().Value("user").(*models.Claims)
+		claims, ok := ClaimsFromContext(r.Context())
 		if !ok {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		// Admins can access everything
-		if claims.Role == "admin" {
+		if claims.Role == models.RoleAdmin {
 			next.ServeHTTP(w, r)
 			return
 		}
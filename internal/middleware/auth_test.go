@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ledger/internal/auth"
+	"ledger/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+func testTokens(t *testing.T) *auth.TokenService {
+	t.Helper()
+	tokens, err := auth.NewTokenService("ledger", "", time.Hour, auth.HMACKey("", []byte(testSecret)))
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	return tokens
+}
+
+func signedToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims models.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return token
+}
+
+func claimsFor(userID int64, role string, expiresIn time.Duration) models.Claims {
+	return models.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "0123456789abcdef0123456789abcdef",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		},
+	}
+}
+
+// fakeDenylist revokes the token IDs in its map, and fails for "broken".
+type fakeDenylist map[string]bool
+
+func (d fakeDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "broken" {
+		return false, errors.New("denylist unavailable")
+	}
+	return d[jti], nil
+}
+
+// fakeAPIKeys knows the API keys in its map, and fails for "broken".
+type fakeAPIKeys map[string]*models.APIKey
+
+func (k fakeAPIKeys) LookupAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	if key == "broken" {
+		return nil, errors.New("api keys unavailable")
+	}
+	return k[key], nil
+}
+
+// echoClaims responds 200 with the role AuthMiddleware put in the context.
+var echoClaims = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "no claims", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(claims.Role))
+})
+
+func TestAuthMiddleware(t *testing.T) {
+	tokens := testTokens(t)
+	valid, _, err := tokens.Issue(7, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	revoked, claims, err := tokens.Issue(7, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	denylist := fakeDenylist{claims.ID: true}
+
+	noID := claimsFor(7, models.RoleUser, time.Hour)
+	noID.ID = ""
+	broken := claimsFor(7, models.RoleUser, time.Hour)
+	broken.ID = "broken"
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"not a bearer token", "Basic " + valid, http.StatusUnauthorized},
+		{"malformed token", "Bearer not.a.jwt", http.StatusUnauthorized},
+		{"wrong secret", "Bearer " + signedToken(t, jwt.SigningMethodHS256, []byte("other"),
+			claimsFor(7, models.RoleUser, time.Hour)), http.StatusUnauthorized},
+		{"unsigned", "Bearer " + signedToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType,
+			claimsFor(7, models.RoleAdmin, time.Hour)), http.StatusUnauthorized},
+		{"expired", "Bearer " + signedToken(t, jwt.SigningMethodHS256, []byte(testSecret),
+			claimsFor(7, models.RoleUser, -time.Hour)), http.StatusUnauthorized},
+		{"no user", "Bearer " + signedToken(t, jwt.SigningMethodHS256, []byte(testSecret),
+			claimsFor(0, models.RoleUser, time.Hour)), http.StatusUnauthorized},
+		{"unknown role", "Bearer " + signedToken(t, jwt.SigningMethodHS256, []byte(testSecret),
+			claimsFor(7, "root", time.Hour)), http.StatusUnauthorized},
+		{"no token ID", "Bearer " + signedToken(t, jwt.SigningMethodHS256, []byte(testSecret), noID),
+			http.StatusUnauthorized},
+		{"revoked", "Bearer " + revoked, http.StatusUnauthorized},
+		{"denylist unavailable", "Bearer " + signedToken(t, jwt.SigningMethodHS256, []byte(testSecret), broken),
+			http.StatusInternalServerError},
+		{"valid", "Bearer " + valid, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			AuthMiddleware(tokens, denylist, fakeAPIKeys{})(echoClaims).ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rr.Code, tt.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	tokens := testTokens(t)
+	apiKeys := fakeAPIKeys{
+		"lk_reader": {ID: 3, CreatedBy: 1, Scopes: []string{models.ScopeBalancesRead}},
+		"lk_writer": {ID: 4, CreatedBy: 1, Scopes: []string{models.ScopeCreditsWrite, models.ScopeTransfersWrite}},
+	}
+	bearer, _, err := tokens.Issue(1, models.RoleAdmin)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	r := chi.NewRouter()
+	auth := AuthMiddleware(tokens, fakeDenylist{}, apiKeys)
+	r.With(Scope(models.ScopeBalancesRead), auth, AdminOnly).Get("/balances", echoClaims)
+	r.With(Scope(models.ScopeCreditsWrite), auth, AdminOnly).Post("/credit", echoClaims)
+	r.With(auth, AdminOnly).Post("/admin", echoClaims)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		bearer bool
+		status int
+	}{
+		{"scoped route", http.MethodGet, "/balances", "lk_reader", false, http.StatusOK},
+		{"other scope", http.MethodPost, "/credit", "lk_writer", false, http.StatusOK},
+		{"missing scope", http.MethodPost, "/credit", "lk_reader", false, http.StatusForbidden},
+		{"unscoped route", http.MethodPost, "/admin", "lk_writer", false, http.StatusForbidden},
+		{"unknown key", http.MethodGet, "/balances", "lk_unknown", false, http.StatusUnauthorized},
+		{"store unavailable", http.MethodGet, "/balances", "broken", false, http.StatusInternalServerError},
+		{"key and bearer token", http.MethodGet, "/balances", "lk_reader", true, http.StatusUnauthorized},
+		{"bearer token on scoped route", http.MethodGet, "/balances", "", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+bearer)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rr.Code, tt.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	tokens := testTokens(t)
+	r := chi.NewRouter()
+	r.Use(AuthMiddleware(tokens, fakeDenylist{}, fakeAPIKeys{}))
+	r.With(AdminOnly).Get("/admin", echoClaims)
+	r.With(OwnerOrAdmin).Get("/users/{id}", echoClaims)
+
+	token := func(userID int64, role string) string {
+		tok, _, err := tokens.Issue(userID, role)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		return tok
+	}
+	user := token(7, models.RoleUser)
+	admin := token(1, models.RoleAdmin)
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		forged map[string]string
+		status int
+	}{
+		{"admin route as admin", "/admin", admin, nil, http.StatusOK},
+		{"admin route as user", "/admin", user, nil, http.StatusForbidden},
+		{"admin route with forged role header", "/admin", user,
+			map[string]string{"user_role": "admin", "user_id": "1"}, http.StatusForbidden},
+		{"admin route unauthenticated", "/admin", "", nil, http.StatusUnauthorized},
+		{"own user", "/users/7", user, nil, http.StatusOK},
+		{"other user", "/users/8", user, nil, http.StatusForbidden},
+		{"other user with forged id header", "/users/8", user,
+			map[string]string{"user_id": "8"}, http.StatusForbidden},
+		{"other user as admin", "/users/8", admin, nil, http.StatusOK},
+		{"bad user id", "/users/abc", user, nil, http.StatusBadRequest},
+		{"user route unauthenticated", "/users/7", "", nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			for k, v := range tt.forged {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rr.Code, tt.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestPoliciesWithoutAuthMiddleware(t *testing.T) {
+	for name, policy := range map[string]func(http.Handler) http.Handler{
+		"AdminOnly":    AdminOnly,
+		"OwnerOrAdmin": OwnerOrAdmin,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("user_role", "admin")
+		rr := httptest.NewRecorder()
+		policy(echoClaims).ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", name, rr.Code, http.StatusUnauthorized)
+		}
+	}
+}
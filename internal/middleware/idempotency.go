@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// IdempotencyKeyHeader is the header clients set to make a request safe to
+// retry.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency returns a middleware that honours the Idempotency-Key header.
+// The first request with a given key runs normally and its response is
+// stored alongside a hash of the request. A repeat with the same request
+// gets the stored response back instead of running again; a repeat with a
+// different request is refused with 422, and a repeat that arrives while the
+// first is still running is refused with 409. Keys are scoped to the caller
+// AuthMiddleware verified, so Idempotency must run after it, and expire
+// after 24 hours.
+func Idempotency(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Error reading request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+			// Keys are scoped to the verified caller, never to anything the
+			// client sends. API keys get their own scope, apart from the
+			// admin they act for.
+			var scope string
+			if key, ok := APIKeyFromContext(r.Context()); ok {
+				scope = "key:" + strconv.FormatInt(key.ID, 10)
+			} else if claims, ok := ClaimsFromContext(r.Context()); ok {
+				scope = strconv.FormatInt(claims.UserID, 10)
+			} else {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			hash := requestHash(r, body)
+
+			_, err = db.Exec(`
+				DELETE FROM idempotency_keys
+				WHERE scope = $1 AND key = $2
+				AND created_at < NOW() - INTERVAL '24 hours'`,
+				scope, key)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			// Claim the key. Only one request can insert the row; everyone
+			// else falls through to the stored outcome.
+			var id int64
+			err = db.QueryRow(`
+				INSERT INTO idempotency_keys (scope, key, request_hash)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (scope, key) DO NOTHING
+				RETURNING id`,
+				scope, key, hash).Scan(&id)
+			if err == sql.ErrNoRows {
+				replayIdempotentResponse(db, w, scope, key, hash)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			// A handler that panics never finishes: release the key on the
+			// way out, or retries would be refused until it expires
+			finished := false
+			defer func() {
+				if !finished {
+					db.Exec("DELETE FROM idempotency_keys WHERE id = $1", id)
+				}
+			}()
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			finished = true
+
+			// Server errors are not final: release the key so that the
+			// client can retry.
+			if rec.status >= http.StatusInternalServerError {
+				db.Exec("DELETE FROM idempotency_keys WHERE id = $1", id)
+				return
+			}
+
+			db.Exec(`
+				UPDATE idempotency_keys
+				SET status_code = $1, content_type = $2, response_body = $3
+				WHERE id = $4`,
+				rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(), id)
+		})
+	}
+}
+
+// requestHash fingerprints the parts of a request that must match for a
+// retry to be treated as the same request.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayIdempotentResponse(db *sql.DB, w http.ResponseWriter, scope, key, hash string) {
+	var (
+		storedHash  string
+		status      sql.NullInt64
+		contentType sql.NullString
+		body        []byte
+	)
+	err := db.QueryRow(`
+		SELECT request_hash, status_code, content_type, response_body
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2`,
+		scope, key).Scan(&storedHash, &status, &contentType, &body)
+	if err == sql.ErrNoRows {
+		// The original request failed and released the key in between.
+		http.Error(w, "Request with this Idempotency-Key failed, please retry", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if storedHash != hash {
+		http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !status.Valid {
+		http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+		return
+	}
+
+	if contentType.Valid && contentType.String != "" {
+		w.Header().Set("Content-Type", contentType.String)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(status.Int64))
+	w.Write(body)
+}
+
+// responseRecorder passes a response through to the client while keeping a
+// copy of its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"ledger/internal/models"
+
+	_ "github.com/lib/pq"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	connStr := "host=localhost port=5432 user=tolgahan.feyizoglu dbname=ledger_db sslmode=disable"
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+
+	// Only -short runs may go without the database
+	if err := db.Ping(); err != nil {
+		if testing.Short() {
+			t.Skipf("Test database not available: %v", err)
+		}
+		t.Fatalf("Test database not available: %v", err)
+	}
+
+	if _, err := db.Exec("TRUNCATE idempotency_keys"); err != nil {
+		t.Fatalf("Failed to clean test database: %v", err)
+	}
+	return db
+}
+
+// idempotentRequest sends body to handler as userID with the given key.
+func idempotentRequest(handler http.Handler, userID int64, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/transfer", strings.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	if userID != 0 {
+		req = req.WithContext(WithClaims(req.Context(), &models.Claims{UserID: userID, Role: models.RoleUser}))
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotency(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var calls int32
+	handler := Idempotency(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	}))
+
+	first := idempotentRequest(handler, 7, "replay", `{"amount":"1.00"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first: status = %d, want %d", first.Code, http.StatusCreated)
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		rr := idempotentRequest(handler, 7, "replay", `{"amount":"1.00"}`)
+		if rr.Code != http.StatusCreated || rr.Body.String() != first.Body.String() {
+			t.Errorf("got %d %q, want %d %q", rr.Code, rr.Body.String(), first.Code, first.Body.String())
+		}
+		if rr.Header().Get("Idempotent-Replayed") != "true" {
+			t.Error("Expected Idempotent-Replayed header")
+		}
+		if got := atomic.LoadInt32(&calls); got != 1 {
+			t.Errorf("handler ran %d times, want 1", got)
+		}
+	})
+
+	t.Run("Different Body", func(t *testing.T) {
+		rr := idempotentRequest(handler, 7, "replay", `{"amount":"2.00"}`)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("status = %d, want %d", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Other Caller", func(t *testing.T) {
+		before := atomic.LoadInt32(&calls)
+		rr := idempotentRequest(handler, 8, "replay", `{"amount":"1.00"}`)
+		if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("Expected a fresh response for another caller, got %d %q", rr.Code, rr.Body.String())
+		}
+		if atomic.LoadInt32(&calls) != before+1 {
+			t.Error("Expected the handler to run for another caller")
+		}
+	})
+}
+
+func TestIdempotencyWithoutAuthMiddleware(t *testing.T) {
+	// Without a verified caller there is no scope to keep the key in, so
+	// the database is never reached.
+	handler := Idempotency(nil)(echoClaims)
+	rr := idempotentRequest(handler, 0, "shared", `{"amount":"1.00"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := Idempotency(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- idempotentRequest(handler, 7, "in-flight", `{}`)
+	}()
+	<-started
+
+	rr := idempotentRequest(handler, 7, "in-flight", `{}`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusConflict)
+	}
+
+	close(release)
+	if first := <-done; first.Code != http.StatusOK {
+		t.Errorf("first: status = %d, want %d", first.Code, http.StatusOK)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnServerError(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var calls int32
+	handler := Idempotency(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	if rr := idempotentRequest(handler, 7, "retry", `{}`); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("first: status = %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+	rr := idempotentRequest(handler, 7, "retry", `{}`)
+	if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry: status = %d, want a fresh %d", rr.Code, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("handler ran %d times, want 2", got)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var calls int32
+	handler := Idempotency(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected the handler's panic to propagate")
+			}
+		}()
+		idempotentRequest(handler, 7, "panic", `{}`)
+	}()
+
+	rr := idempotentRequest(handler, 7, "panic", `{}`)
+	if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry: status = %d, want a fresh %d", rr.Code, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("handler ran %d times, want 2", got)
+	}
+}
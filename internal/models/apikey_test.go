@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKeyRequestValidate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		req        CreateAPIKeyRequest
+		wantErr    bool
+		wantScopes []string
+	}{
+		{name: "One Scope", req: CreateAPIKeyRequest{Name: "payroll", Scopes: []string{ScopeTransfersWrite}}, wantScopes: []string{ScopeTransfersWrite}},
+		{name: "Duplicate Scopes", req: CreateAPIKeyRequest{Name: "reporting", Scopes: []string{ScopeBalancesRead, ScopeBalancesRead, ScopeCreditsWrite}}, wantScopes: []string{ScopeBalancesRead, ScopeCreditsWrite}},
+		{name: "Expiring", req: CreateAPIKeyRequest{Name: "payroll", Scopes: []string{ScopeTransfersWrite}, ExpiresAt: &later}, wantScopes: []string{ScopeTransfersWrite}},
+		{name: "Blank Name", req: CreateAPIKeyRequest{Name: "  ", Scopes: []string{ScopeBalancesRead}}, wantErr: true},
+		{name: "No Scopes", req: CreateAPIKeyRequest{Name: "payroll"}, wantErr: true},
+		{name: "Unknown Scope", req: CreateAPIKeyRequest{Name: "payroll", Scopes: []string{"users:admin"}}, wantErr: true},
+		{name: "Already Expired", req: CreateAPIKeyRequest{Name: "payroll", Scopes: []string{ScopeBalancesRead}, ExpiresAt: &earlier}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate(now)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(tt.req.Scopes) != len(tt.wantScopes) {
+				t.Fatalf("Scopes = %v, want %v", tt.req.Scopes, tt.wantScopes)
+			}
+			for i := range tt.wantScopes {
+				if tt.req.Scopes[i] != tt.wantScopes[i] {
+					t.Errorf("Scopes = %v, want %v", tt.req.Scopes, tt.wantScopes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Approval statuses. A pending approval that is neither approved nor
+// rejected before it expires can no longer be approved.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+)
+
+// TransferApproval is a transfer above the approval threshold waiting for,
+// or having had, a decision by an admin other than the requester.
+type TransferApproval struct {
+	ID             int64      `json:"id"`
+	FromUserID     int64      `json:"from_user_id"`
+	ToUserID       int64      `json:"to_user_id"`
+	Amount         Money      `json:"amount"`
+	Currency       string     `json:"currency"`
+	ToCurrency     string     `json:"to_currency"`
+	Status         string     `json:"status"`
+	RequestedBy    int64      `json:"requested_by"`
+	DecidedBy      *int64     `json:"decided_by,omitempty"`
+	DecisionReason string     `json:"decision_reason,omitempty"`
+	TransactionID  *int64     `json:"transaction_id,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+}
+
+// ApprovalDecisionRequest carries the optional reason for approving or
+// rejecting a transfer.
+type ApprovalDecisionRequest struct {
+	Reason string `json:"reason"`
+}
@@ -1,21 +1,40 @@
 package models
 
-import "github.com/golang-jwt/jwt/v5"
+import (
+	"time"
+
+	"ledger/pkg/ledgerjwt"
+)
 
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+// LoginResponse is returned by login and by refreshing a session. Token is
+// the short-lived access token; RefreshToken can be used once to get the
+// next pair.
 type LoginResponse struct {
-	Token  string `json:"token"`
-	Role   string `json:"role"`
-	UserID int64  `json:"user_id"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	Role             string    `json:"role"`
+	UserID           int64     `json:"user_id"`
+	Status           string    `json:"status"`
 }
 
-// Claims defines the JWT claims structure
-type Claims struct {
-	UserID int64  `json:"user_id"`
-	Role   string `json:"role"`
-	jwt.RegisteredClaims
+// RefreshTokenRequest is the body of a session refresh.
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
+
+// Roles a user can have.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Claims defines the JWT claims structure. It is defined in ledgerjwt so
+// that other services verifying ledger tokens share it.
+type Claims = ledgerjwt.Claims
@@ -0,0 +1,36 @@
+package models
+
+// Statuses of a batch and of its legs. A batch is applied only when every
+// leg is valid; otherwise nothing is and the failing legs say why.
+const (
+	BatchApplied  = "applied"
+	BatchRejected = "rejected"
+
+	LegApplied = "applied"
+	LegValid   = "valid"
+	LegFailed  = "failed"
+)
+
+// BatchTransferRequest is a list of transfers to be made together.
+type BatchTransferRequest struct {
+	Transfers []TransferRequest `json:"transfers"`
+}
+
+// BatchTransferResult reports the outcome of a batch leg by leg. BatchID
+// links the transactions of an applied batch.
+type BatchTransferResult struct {
+	BatchID *int64           `json:"batch_id,omitempty"`
+	Status  string           `json:"status"`
+	Legs    []BatchLegResult `json:"legs"`
+}
+
+// BatchLegResult is the outcome of one transfer of a batch, identified by
+// its position in the request. Code is set when the leg broke a limit
+// rule. Result is only set once the batch applied.
+type BatchLegResult struct {
+	Index  int             `json:"index"`
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	Code   string          `json:"code,omitempty"`
+	Result *TransferResult `json:"result,omitempty"`
+}
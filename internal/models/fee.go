@@ -0,0 +1,239 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Kinds of fee. A flat fee is a fixed amount, a percentage fee a share of
+// the amount moved, and a tiered fee a flat part plus a percentage that both
+// depend on the tier the amount falls in.
+const (
+	FeeFlat       = "flat"
+	FeePercentage = "percentage"
+	FeeTiered     = "tiered"
+)
+
+// Operations fees are charged on.
+const (
+	FeeTransfer   = "transfer"
+	FeeWithdrawal = "withdrawal"
+)
+
+// maxPercentDigits is the number of decimal places a fee percentage may
+// carry, matching the DECIMAL(7,4) column it is stored in.
+const maxPercentDigits = 4
+
+var ErrInvalidPercent = errors.New("percent must be a decimal between 0 and 100 with at most 4 decimal places")
+
+// FeeTier is one band of a tiered fee. It applies to amounts up to and
+// including UpTo; the last tier has no UpTo and covers everything above.
+type FeeTier struct {
+	UpTo    *Money `json:"up_to,omitempty"`
+	Flat    Money  `json:"flat"`
+	Percent string `json:"percent,omitempty"`
+}
+
+// FeeSchedule is a fee charged on every transfer or withdrawal in Currency
+// while it is active. All active schedules of an operation and currency are
+// charged, each as its own posting. MinFee and MaxFee bound what the
+// schedule charges.
+type FeeSchedule struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Operation string    `json:"operation"`
+	Currency  string    `json:"currency"`
+	Kind      string    `json:"kind"`
+	Flat      *Money    `json:"flat,omitempty"`
+	Percent   string    `json:"percent,omitempty"`
+	Tiers     []FeeTier `json:"tiers,omitempty"`
+	MinFee    *Money    `json:"min_fee,omitempty"`
+	MaxFee    *Money    `json:"max_fee,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Charge returns the fee the schedule charges on amount, rounded half away
+// from zero to the nearest minor unit.
+func (f FeeSchedule) Charge(amount Money) Money {
+	var fee int64
+	switch f.Kind {
+	case FeeFlat:
+		if f.Flat != nil {
+			fee = f.Flat.Units
+		}
+	case FeePercentage:
+		fee = percentOf(amount, f.Percent).Units
+	case FeeTiered:
+		for _, tier := range f.Tiers {
+			if tier.UpTo == nil || amount.Units <= tier.UpTo.Units {
+				fee = tier.Flat.Units + percentOf(amount, tier.Percent).Units
+				break
+			}
+		}
+	}
+
+	if f.MinFee != nil && fee < f.MinFee.Units {
+		fee = f.MinFee.Units
+	}
+	if f.MaxFee != nil && fee > f.MaxFee.Units {
+		fee = f.MaxFee.Units
+	}
+	return NewMoney(fee, amount.Currency)
+}
+
+// percentOf returns percent per cent of amount. An empty or invalid percent
+// is zero; schedules are validated before they are stored.
+func percentOf(amount Money, percent string) Money {
+	rate, err := ParsePercent(percent)
+	if err != nil {
+		return NewMoney(0, amount.Currency)
+	}
+	fee, err := amount.Convert(rate.Quo(rate, big.NewRat(100, 1)), amount.Currency)
+	if err != nil {
+		return NewMoney(0, amount.Currency)
+	}
+	return fee
+}
+
+// ParsePercent parses a percentage such as "1.5" for one and a half per
+// cent.
+func ParsePercent(s string) (*big.Rat, error) {
+	if _, frac, ok := strings.Cut(s, "."); ok && len(frac) > maxPercentDigits {
+		return nil, ErrInvalidPercent
+	}
+	p, ok := new(big.Rat).SetString(s)
+	if !ok || p.Sign() < 0 || p.Cmp(big.NewRat(100, 1)) > 0 || strings.ContainsAny(s, "eE/") {
+		return nil, ErrInvalidPercent
+	}
+	return p, nil
+}
+
+// FeeScheduleRequest creates or replaces a fee schedule. Flat fees need
+// Flat, percentage fees Percent and tiered fees Tiers in increasing order of
+// UpTo. Schedules are active unless Active is false.
+type FeeScheduleRequest struct {
+	Name      string    `json:"name"`
+	Operation string    `json:"operation"`
+	Currency  string    `json:"currency"`
+	Kind      string    `json:"kind"`
+	Flat      *Money    `json:"flat,omitempty"`
+	Percent   string    `json:"percent,omitempty"`
+	Tiers     []FeeTier `json:"tiers,omitempty"`
+	MinFee    *Money    `json:"min_fee,omitempty"`
+	MaxFee    *Money    `json:"max_fee,omitempty"`
+	Active    *bool     `json:"active,omitempty"`
+}
+
+// Validate checks that the request describes a complete schedule and
+// normalises its currency.
+func (r *FeeScheduleRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+
+	if r.Operation != FeeTransfer && r.Operation != FeeWithdrawal {
+		return errors.New("operation must be transfer or withdrawal")
+	}
+
+	r.Currency = strings.ToUpper(r.Currency)
+	if !IsSupportedCurrency(r.Currency) {
+		return errors.New("unsupported currency")
+	}
+
+	switch r.Kind {
+	case FeeFlat:
+		if r.Flat == nil || !r.Flat.IsPositive() {
+			return errors.New("flat must be positive")
+		}
+		if r.Percent != "" || len(r.Tiers) > 0 {
+			return errors.New("flat fees take neither percent nor tiers")
+		}
+	case FeePercentage:
+		if _, err := ParsePercent(r.Percent); err != nil {
+			return err
+		}
+		if r.Flat != nil || len(r.Tiers) > 0 {
+			return errors.New("percentage fees take neither flat nor tiers")
+		}
+	case FeeTiered:
+		if err := r.validateTiers(); err != nil {
+			return err
+		}
+		if r.Flat != nil || r.Percent != "" {
+			return errors.New("tiered fees set flat and percent per tier")
+		}
+	default:
+		return errors.New("kind must be flat, percentage or tiered")
+	}
+
+	for _, m := range []*Money{r.MinFee, r.MaxFee} {
+		if m != nil {
+			if m.IsNegative() {
+				return errors.New("min_fee and max_fee may not be negative")
+			}
+			m.Currency = r.Currency
+		}
+	}
+	if r.MinFee != nil && r.MaxFee != nil && r.MaxFee.LessThan(*r.MinFee) {
+		return errors.New("min_fee may not exceed max_fee")
+	}
+	if r.Flat != nil {
+		r.Flat.Currency = r.Currency
+	}
+
+	return nil
+}
+
+func (r *FeeScheduleRequest) validateTiers() error {
+	if len(r.Tiers) == 0 {
+		return errors.New("tiered fees need at least one tier")
+	}
+
+	for i := range r.Tiers {
+		tier := &r.Tiers[i]
+		last := i == len(r.Tiers)-1
+		switch {
+		case last && tier.UpTo != nil:
+			return errors.New("the last tier must have no up_to")
+		case !last && (tier.UpTo == nil || !tier.UpTo.IsPositive()):
+			return errors.New("every tier but the last needs a positive up_to")
+		case !last && i > 0 && !r.Tiers[i-1].UpTo.LessThan(*tier.UpTo):
+			return errors.New("tiers must be in increasing order of up_to")
+		case tier.Flat.IsNegative():
+			return errors.New("tier flat may not be negative")
+		}
+		if tier.Percent != "" {
+			if _, err := ParsePercent(tier.Percent); err != nil {
+				return err
+			}
+		}
+
+		tier.Flat.Currency = r.Currency
+		if tier.UpTo != nil {
+			tier.UpTo.Currency = r.Currency
+		}
+	}
+	return nil
+}
+
+// FeeCharge is one fee charged on an operation.
+type FeeCharge struct {
+	ScheduleID int64  `json:"schedule_id"`
+	Name       string `json:"name"`
+	Amount     Money  `json:"amount"`
+}
+
+// FeeQuote is the fee an operation would be charged, and the total the
+// paying account would be debited with.
+type FeeQuote struct {
+	Operation string      `json:"operation"`
+	Amount    Money       `json:"amount"`
+	Currency  string      `json:"currency"`
+	Fees      []FeeCharge `json:"fees"`
+	TotalFee  Money       `json:"total_fee"`
+	Total     Money       `json:"total"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import "testing"
+
+func TestFeeScheduleCharge(t *testing.T) {
+	money := func(units int64) *Money {
+		m := NewMoney(units, "USD")
+		return &m
+	}
+
+	tiered := FeeSchedule{Kind: FeeTiered, Tiers: []FeeTier{
+		{UpTo: money(10000), Flat: NewMoney(50, "USD")},
+		{UpTo: money(100000), Flat: NewMoney(100, "USD"), Percent: "0.5"},
+		{Percent: "0.25"},
+	}}
+
+	tests := []struct {
+		name     string
+		schedule FeeSchedule
+		amount   int64
+		want     int64
+	}{
+		{name: "Flat", schedule: FeeSchedule{Kind: FeeFlat, Flat: money(150)}, amount: 123456, want: 150},
+		{name: "Percentage", schedule: FeeSchedule{Kind: FeePercentage, Percent: "1.5"}, amount: 10000, want: 150},
+		{name: "Percentage Rounds Half Away From Zero", schedule: FeeSchedule{Kind: FeePercentage, Percent: "1"}, amount: 50, want: 1},
+		{name: "Percentage Below Minimum", schedule: FeeSchedule{Kind: FeePercentage, Percent: "1", MinFee: money(100)}, amount: 1000, want: 100},
+		{name: "Percentage Above Maximum", schedule: FeeSchedule{Kind: FeePercentage, Percent: "2", MaxFee: money(500)}, amount: 100000, want: 500},
+		{name: "First Tier", schedule: tiered, amount: 10000, want: 50},
+		{name: "Middle Tier", schedule: tiered, amount: 20000, want: 200},
+		{name: "Last Tier", schedule: tiered, amount: 200000, want: 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.schedule.Charge(NewMoney(tt.amount, "USD"))
+			if got.Units != tt.want || got.Currency != "USD" {
+				t.Errorf("Expected %d USD, got %d %s", tt.want, got.Units, got.Currency)
+			}
+		})
+	}
+}
+
+func TestFeeScheduleRequestValidate(t *testing.T) {
+	money := func(units int64) *Money {
+		m := NewMoney(units, "")
+		return &m
+	}
+
+	tests := []struct {
+		name    string
+		req     FeeScheduleRequest
+		wantErr bool
+	}{
+		{name: "Flat", req: FeeScheduleRequest{Name: "Wire", Operation: FeeTransfer, Currency: "usd", Kind: FeeFlat, Flat: money(100)}},
+		{name: "Percentage With Bounds", req: FeeScheduleRequest{Name: "FX", Operation: FeeWithdrawal, Currency: "EUR", Kind: FeePercentage, Percent: "1.25", MinFee: money(50), MaxFee: money(1000)}},
+		{name: "Tiered", req: FeeScheduleRequest{Name: "Tiers", Operation: FeeTransfer, Currency: "USD", Kind: FeeTiered, Tiers: []FeeTier{{UpTo: money(1000), Flat: NewMoney(10, "")}, {Percent: "0.1"}}}},
+		{name: "Missing Name", req: FeeScheduleRequest{Operation: FeeTransfer, Currency: "USD", Kind: FeeFlat, Flat: money(100)}, wantErr: true},
+		{name: "Unknown Operation", req: FeeScheduleRequest{Name: "Fee", Operation: "credit", Currency: "USD", Kind: FeeFlat, Flat: money(100)}, wantErr: true},
+		{name: "Flat With Percent", req: FeeScheduleRequest{Name: "Fee", Operation: FeeTransfer, Currency: "USD", Kind: FeeFlat, Flat: money(100), Percent: "1"}, wantErr: true},
+		{name: "Percent Too Precise", req: FeeScheduleRequest{Name: "Fee", Operation: FeeTransfer, Currency: "USD", Kind: FeePercentage, Percent: "0.12345"}, wantErr: true},
+		{name: "Percent Over 100", req: FeeScheduleRequest{Name: "Fee", Operation: FeeTransfer, Currency: "USD", Kind: FeePercentage, Percent: "101"}, wantErr: true},
+		{name: "Minimum Above Maximum", req: FeeScheduleRequest{Name: "Fee", Operation: FeeTransfer, Currency: "USD", Kind: FeePercentage, Percent: "1", MinFee: money(500), MaxFee: money(100)}, wantErr: true},
+		{name: "Bounded Last Tier", req: FeeScheduleRequest{Name: "Fee", Operation: FeeTransfer, Currency: "USD", Kind: FeeTiered, Tiers: []FeeTier{{UpTo: money(1000)}}}, wantErr: true},
+		{name: "Tiers Out Of Order", req: FeeScheduleRequest{Name: "Fee", Operation: FeeTransfer, Currency: "USD", Kind: FeeTiered, Tiers: []FeeTier{{UpTo: money(1000)}, {UpTo: money(500)}, {}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.wantErr && err == nil {
+				t.Error("Expected an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// maxRateDigits is the number of digits an FX rate may carry on either side
+// of the decimal point, matching the NUMERIC(20,10) column it is stored in.
+const maxRateDigits = 10
+
+var ErrInvalidRate = errors.New("rate must be a positive decimal with at most 10 digits before and after the point")
+
+// FXRate is the price of one unit of Base expressed in Quote. Rates are kept
+// as decimal strings so they survive the round trip to the database exactly.
+type FXRate struct {
+	Base      string    `json:"base"`
+	Quote     string    `json:"quote"`
+	Rate      string    `json:"rate"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetFXRateRequest is the body admins send to publish a new rate.
+type SetFXRateRequest struct {
+	Base  string `json:"base"`
+	Quote string `json:"quote"`
+	Rate  string `json:"rate"`
+}
+
+// ParseRate parses a positive decimal FX rate such as "1.0834". Only plain
+// digits are accepted; the other notations big.Rat understands, such as
+// "0x10" or "1_000", are not.
+func ParseRate(s string) (*big.Rat, error) {
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" || !isDigits(whole) || hasPoint && (frac == "" || !isDigits(frac)) {
+		return nil, ErrInvalidRate
+	}
+	if len(strings.TrimLeft(whole, "0")) > maxRateDigits || len(frac) > maxRateDigits {
+		return nil, ErrInvalidRate
+	}
+
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, ErrInvalidRate
+	}
+	return rate, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert returns m expressed in currency to at rate, the price of one unit
+// of m's currency in to. The result is rounded half away from zero to the
+// nearest minor unit. A result too large for the amount columns is
+// ErrAmountRange.
+func (m Money) Convert(rate *big.Rat, to string) (Money, error) {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Units), rate)
+
+	num := new(big.Int).Abs(product.Num())
+	den := product.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+
+	if quo.Cmp(big.NewInt(maxUnits)) > 0 {
+		return Money{}, ErrAmountRange
+	}
+	units := quo.Int64()
+	if product.Sign() < 0 {
+		units = -units
+	}
+	return Money{Units: units, Currency: to}, nil
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Hold statuses. Only a pending hold reserves funds.
+const (
+	HoldPending  = "pending"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+// Hold is an authorization that reserves funds on a user's account without
+// posting them.
+type Hold struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"user_id"`
+	Currency       string    `json:"currency"`
+	Amount         Money     `json:"amount"`
+	CapturedAmount *Money    `json:"captured_amount,omitempty"`
+	Status         string    `json:"status"`
+	Description    string    `json:"description,omitempty"`
+	TransactionID  *int64    `json:"transaction_id,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type CreateHoldRequest struct {
+	Amount      Money      `json:"amount"`
+	Currency    string     `json:"currency"`
+	Description string     `json:"description"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// CaptureHoldRequest captures all of a hold, or only Amount when it is set.
+type CaptureHoldRequest struct {
+	Amount *Money `json:"amount"`
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Account types. Interest rates are set per account type and currency.
+const (
+	AccountChecking = "checking"
+	AccountSavings  = "savings"
+)
+
+// AccountTypes lists the types an account can be opened as.
+var AccountTypes = []string{AccountChecking, AccountSavings}
+
+// IsAccountType reports whether t is one of AccountTypes.
+func IsAccountType(t string) bool {
+	for _, known := range AccountTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Day-count conventions. ACT/365 counts every calendar day against a 365-day
+// year; 30/360 (the European variant) treats every month as 30 days and the
+// year as 360, so the 31st earns nothing and the last day of February earns
+// the days up to the 30th.
+const (
+	DayCountACT365 = "ACT/365"
+	DayCount30360  = "30/360"
+)
+
+// InterestRate is the annual rate, in per cent, paid on positive balances of
+// accounts of AccountType in Currency from EffectiveFrom until a later rate
+// takes over.
+type InterestRate struct {
+	ID            int64     `json:"id"`
+	AccountType   string    `json:"account_type"`
+	Currency      string    `json:"currency"`
+	AnnualRate    string    `json:"annual_rate"`
+	DayCount      string    `json:"day_count"`
+	EffectiveFrom string    `json:"effective_from"`
+	CreatedBy     *int64    `json:"created_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// SetInterestRateRequest is the body admins send to set a new interest
+// rate. EffectiveFrom is a date such as "2024-01-31" and defaults to today.
+type SetInterestRateRequest struct {
+	AccountType   string `json:"account_type"`
+	Currency      string `json:"currency"`
+	AnnualRate    string `json:"annual_rate"`
+	DayCount      string `json:"day_count"`
+	EffectiveFrom string `json:"effective_from"`
+}
+
+// Validate checks the request and normalises its currency and date.
+func (r *SetInterestRateRequest) Validate(today time.Time) error {
+	if !IsAccountType(r.AccountType) {
+		return errors.New("account_type must be checking or savings")
+	}
+
+	r.Currency = strings.ToUpper(r.Currency)
+	if !IsSupportedCurrency(r.Currency) {
+		return errors.New("unsupported currency")
+	}
+
+	if _, err := ParsePercent(r.AnnualRate); err != nil {
+		return errors.New("annual_rate must be a percentage between 0 and 100 with at most 4 decimal places")
+	}
+
+	if r.DayCount != DayCountACT365 && r.DayCount != DayCount30360 {
+		return errors.New("day_count must be ACT/365 or 30/360")
+	}
+
+	if r.EffectiveFrom == "" {
+		r.EffectiveFrom = today.Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", r.EffectiveFrom); err != nil {
+		return errors.New("effective_from must be a date such as 2024-01-31")
+	}
+	return nil
+}
+
+// DayCountFraction returns the fraction of a year that the single day
+// starting at day counts for under convention.
+func DayCountFraction(convention string, day time.Time) *big.Rat {
+	if convention != DayCount30360 {
+		return big.NewRat(1, 365)
+	}
+
+	days := int64(1)
+	switch {
+	case day.Day() == 31:
+		days = 0
+	case day.AddDate(0, 0, 1).Month() != day.Month():
+		// The last day of a short month makes up the days to the 30th
+		days = int64(30 - day.Day() + 1)
+	}
+	return big.NewRat(days, 360)
+}
+
+// DailyInterest returns the exact interest, in major units, that balance
+// earns on day at an annual rate given in per cent.
+func DailyInterest(balance Money, annualRate *big.Rat, convention string, day time.Time) *big.Rat {
+	interest := new(big.Rat).SetFrac64(balance.Units, minorScale)
+	interest.Mul(interest, annualRate)
+	interest.Quo(interest, big.NewRat(100, 1))
+	return interest.Mul(interest, DayCountFraction(convention, day))
+}
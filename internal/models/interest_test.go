@@ -0,0 +1,82 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestDayCountFractionSumsToMonth(t *testing.T) {
+	tests := []struct {
+		name       string
+		convention string
+		year       int
+		month      time.Month
+		want       *big.Rat
+	}{
+		{name: "ACT/365 January", convention: DayCountACT365, year: 2023, month: time.January, want: big.NewRat(31, 365)},
+		{name: "ACT/365 Leap February", convention: DayCountACT365, year: 2024, month: time.February, want: big.NewRat(29, 365)},
+		{name: "30/360 January", convention: DayCount30360, year: 2023, month: time.January, want: big.NewRat(30, 360)},
+		{name: "30/360 February", convention: DayCount30360, year: 2023, month: time.February, want: big.NewRat(30, 360)},
+		{name: "30/360 Leap February", convention: DayCount30360, year: 2024, month: time.February, want: big.NewRat(30, 360)},
+		{name: "30/360 December", convention: DayCount30360, year: 2023, month: time.December, want: big.NewRat(30, 360)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := new(big.Rat)
+			day := time.Date(tt.year, tt.month, 1, 0, 0, 0, 0, time.UTC)
+			for ; day.Month() == tt.month; day = day.AddDate(0, 0, 1) {
+				sum.Add(sum, DayCountFraction(tt.convention, day))
+			}
+			if sum.Cmp(tt.want) != 0 {
+				t.Errorf("Expected %s, got %s", tt.want.RatString(), sum.RatString())
+			}
+		})
+	}
+}
+
+func TestDailyInterest(t *testing.T) {
+	day := time.Date(2023, time.March, 15, 0, 0, 0, 0, time.UTC)
+	rate := big.NewRat(365, 100) // 3.65%
+
+	got := DailyInterest(NewMoney(100000, "USD"), rate, DayCountACT365, day)
+	if want := big.NewRat(1, 10); got.Cmp(want) != 0 {
+		t.Errorf("Expected 0.1, got %s", got.FloatString(10))
+	}
+
+	got = DailyInterest(NewMoney(100000, "USD"), big.NewRat(36, 10), DayCount30360, day)
+	if want := big.NewRat(1, 10); got.Cmp(want) != 0 {
+		t.Errorf("Expected 0.1, got %s", got.FloatString(10))
+	}
+
+	// The 31st earns nothing under 30/360
+	got = DailyInterest(NewMoney(100000, "USD"), big.NewRat(36, 10), DayCount30360, time.Date(2023, time.March, 31, 0, 0, 0, 0, time.UTC))
+	if got.Sign() != 0 {
+		t.Errorf("Expected no interest on the 31st, got %s", got.FloatString(10))
+	}
+}
+
+func TestSetInterestRateRequestValidate(t *testing.T) {
+	today := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	req := SetInterestRateRequest{AccountType: AccountSavings, Currency: "eur", AnnualRate: "2.5", DayCount: DayCount30360}
+	if err := req.Validate(today); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Currency != "EUR" || req.EffectiveFrom != "2024-05-01" {
+		t.Errorf("Expected EUR from 2024-05-01, got %s from %s", req.Currency, req.EffectiveFrom)
+	}
+
+	invalid := []SetInterestRateRequest{
+		{AccountType: "loan", Currency: "USD", AnnualRate: "1", DayCount: DayCountACT365},
+		{AccountType: AccountSavings, Currency: "USD", AnnualRate: "-1", DayCount: DayCountACT365},
+		{AccountType: AccountSavings, Currency: "USD", AnnualRate: "1", DayCount: "ACT/360"},
+		{AccountType: AccountSavings, Currency: "USD", AnnualRate: "1", DayCount: DayCountACT365, EffectiveFrom: "01/05/2024"},
+	}
+	for _, req := range invalid {
+		if err := req.Validate(today); err == nil {
+			t.Errorf("Expected an error for %+v", req)
+		}
+	}
+}
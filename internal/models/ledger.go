@@ -0,0 +1,26 @@
+package models
+
+// LedgerCheck is the result of reconciling the journal against the cached
+// account balances.
+type LedgerCheck struct {
+	Balanced           bool              `json:"balanced"`
+	UnbalancedEntries  []UnbalancedEntry `json:"unbalanced_entries"`
+	MismatchedAccounts []AccountMismatch `json:"mismatched_accounts"`
+}
+
+// UnbalancedEntry is a journal entry whose postings in Currency do not sum
+// to zero.
+type UnbalancedEntry struct {
+	EntryID  int64  `json:"entry_id"`
+	Currency string `json:"currency"`
+	Sum      Money  `json:"sum"`
+}
+
+// AccountMismatch is an account whose cached balance differs from the sum
+// of its postings.
+type AccountMismatch struct {
+	AccountID     int64  `json:"account_id"`
+	Currency      string `json:"currency"`
+	CachedBalance Money  `json:"cached_balance"`
+	PostedBalance Money  `json:"posted_balance"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// User statuses. A frozen user's accounts cannot be debited, nor credited
+// if frozen with BlockCredits; a closed user's can do neither, and closing
+// cannot be undone.
+const (
+	UserActive = "active"
+	UserFrozen = "frozen"
+	UserClosed = "closed"
+)
+
+// UserStatusRequest is the body of a freeze, unfreeze or close. Reason is
+// required. BlockCredits only applies to freezing; SweepToUserID only to
+// closing, naming the user whose accounts take over any remaining balances.
+type UserStatusRequest struct {
+	Reason        string `json:"reason"`
+	BlockCredits  bool   `json:"block_credits,omitempty"`
+	SweepToUserID *int64 `json:"sweep_to_user_id,omitempty"`
+}
+
+// UserStatusChange is one entry of a user's status history. Sweeps lists
+// the transfers that emptied the accounts of a user being closed.
+type UserStatusChange struct {
+	ID            int64     `json:"id"`
+	UserID        int64     `json:"user_id"`
+	OldStatus     string    `json:"old_status"`
+	NewStatus     string    `json:"new_status"`
+	BlockCredits  bool      `json:"block_credits"`
+	Reason        string    `json:"reason"`
+	SweepToUserID *int64    `json:"sweep_to_user_id,omitempty"`
+	ChangedBy     *int64    `json:"changed_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Sweeps        []int64   `json:"sweep_transaction_ids,omitempty"`
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Kinds of limit rule. MaxSingleAmount caps one operation, MaxTotalAmount
+// the sum and MaxCount the number of operations within a rolling window.
+const (
+	LimitMaxSingleAmount = "max_single_amount"
+	LimitMaxTotalAmount  = "max_total_amount"
+	LimitMaxCount        = "max_count"
+)
+
+// Operations limit rules apply to.
+const (
+	LimitTransfer   = "transfer"
+	LimitWithdrawal = "withdrawal"
+)
+
+// Roles limit rules can be defined for.
+var LimitRoles = []string{"user", "admin"}
+
+// LimitRule restricts the transfers or withdrawals of every user with Role,
+// or of one user. A rule for a user overrides the rule for their role with
+// the same kind, operation, currency and window.
+type LimitRule struct {
+	ID            int64     `json:"id"`
+	Kind          string    `json:"kind"`
+	Operation     string    `json:"operation"`
+	Role          string    `json:"role,omitempty"`
+	UserID        *int64    `json:"user_id,omitempty"`
+	Currency      string    `json:"currency,omitempty"`
+	MaxAmount     *Money    `json:"max_amount,omitempty"`
+	MaxCount      *int64    `json:"max_count,omitempty"`
+	WindowSeconds *int64    `json:"window_seconds,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// LimitRuleRequest creates or replaces a limit rule. Exactly one of Role
+// and UserID must be set. Amount rules need a Currency and MaxAmount; count
+// rules need MaxCount and apply to all currencies unless Currency is set.
+// Total and count rules need a WindowSeconds, e.g. 86400 for a day.
+type LimitRuleRequest struct {
+	Kind          string `json:"kind"`
+	Operation     string `json:"operation"`
+	Role          string `json:"role,omitempty"`
+	UserID        *int64 `json:"user_id,omitempty"`
+	Currency      string `json:"currency,omitempty"`
+	MaxAmount     *Money `json:"max_amount,omitempty"`
+	MaxCount      *int64 `json:"max_count,omitempty"`
+	WindowSeconds *int64 `json:"window_seconds,omitempty"`
+}
+
+// Validate checks that the request describes a complete rule and
+// normalises its currency.
+func (r *LimitRuleRequest) Validate() error {
+	switch r.Kind {
+	case LimitMaxSingleAmount, LimitMaxTotalAmount, LimitMaxCount:
+	default:
+		return errors.New("kind must be max_single_amount, max_total_amount or max_count")
+	}
+
+	if r.Operation != LimitTransfer && r.Operation != LimitWithdrawal {
+		return errors.New("operation must be transfer or withdrawal")
+	}
+
+	if (r.Role == "") == (r.UserID == nil) {
+		return errors.New("exactly one of role and user_id is required")
+	}
+	if r.Role != "" {
+		known := false
+		for _, role := range LimitRoles {
+			known = known || r.Role == role
+		}
+		if !known {
+			return errors.New("role must be user or admin")
+		}
+	}
+
+	if r.Currency != "" {
+		r.Currency = strings.ToUpper(r.Currency)
+		if !IsSupportedCurrency(r.Currency) {
+			return errors.New("unsupported currency")
+		}
+	}
+
+	if r.Kind == LimitMaxCount {
+		if r.MaxCount == nil || *r.MaxCount < 1 {
+			return errors.New("max_count must be at least 1")
+		}
+		if r.MaxAmount != nil {
+			return errors.New("max_amount does not apply to count rules")
+		}
+	} else {
+		if r.Currency == "" {
+			return errors.New("currency is required for amount rules")
+		}
+		if r.MaxAmount == nil || !r.MaxAmount.IsPositive() {
+			return errors.New("max_amount must be positive")
+		}
+		if r.MaxCount != nil {
+			return errors.New("max_count only applies to count rules")
+		}
+		r.MaxAmount.Currency = r.Currency
+	}
+
+	if r.Kind == LimitMaxSingleAmount {
+		if r.WindowSeconds != nil {
+			return errors.New("window_seconds does not apply to single amount rules")
+		}
+	} else if r.WindowSeconds == nil || *r.WindowSeconds < 1 {
+		return errors.New("window_seconds must be at least 1")
+	}
+
+	return nil
+}
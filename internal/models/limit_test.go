@@ -0,0 +1,40 @@
+package models
+
+import "testing"
+
+func TestLimitRuleRequestValidate(t *testing.T) {
+	userID := int64(3)
+	day := int64(86400)
+	five := int64(5)
+	amount := NewMoney(100000, "")
+
+	tests := []struct {
+		name    string
+		req     LimitRuleRequest
+		wantErr bool
+	}{
+		{name: "Single Amount For Role", req: LimitRuleRequest{Kind: LimitMaxSingleAmount, Operation: LimitTransfer, Role: "user", Currency: "usd", MaxAmount: &amount}},
+		{name: "Daily Total For User", req: LimitRuleRequest{Kind: LimitMaxTotalAmount, Operation: LimitTransfer, UserID: &userID, Currency: "EUR", MaxAmount: &amount, WindowSeconds: &day}},
+		{name: "Hourly Count Any Currency", req: LimitRuleRequest{Kind: LimitMaxCount, Operation: LimitWithdrawal, Role: "admin", MaxCount: &five, WindowSeconds: &day}},
+		{name: "Unknown Kind", req: LimitRuleRequest{Kind: "max_speed", Operation: LimitTransfer, Role: "user"}, wantErr: true},
+		{name: "Role And User", req: LimitRuleRequest{Kind: LimitMaxCount, Operation: LimitTransfer, Role: "user", UserID: &userID, MaxCount: &five, WindowSeconds: &day}, wantErr: true},
+		{name: "Neither Role Nor User", req: LimitRuleRequest{Kind: LimitMaxCount, Operation: LimitTransfer, MaxCount: &five, WindowSeconds: &day}, wantErr: true},
+		{name: "Unknown Role", req: LimitRuleRequest{Kind: LimitMaxCount, Operation: LimitTransfer, Role: "owner", MaxCount: &five, WindowSeconds: &day}, wantErr: true},
+		{name: "Amount Without Currency", req: LimitRuleRequest{Kind: LimitMaxSingleAmount, Operation: LimitTransfer, Role: "user", MaxAmount: &amount}, wantErr: true},
+		{name: "Total Without Window", req: LimitRuleRequest{Kind: LimitMaxTotalAmount, Operation: LimitTransfer, Role: "user", Currency: "USD", MaxAmount: &amount}, wantErr: true},
+		{name: "Single With Window", req: LimitRuleRequest{Kind: LimitMaxSingleAmount, Operation: LimitTransfer, Role: "user", Currency: "USD", MaxAmount: &amount, WindowSeconds: &day}, wantErr: true},
+		{name: "Count With Amount", req: LimitRuleRequest{Kind: LimitMaxCount, Operation: LimitTransfer, Role: "user", MaxCount: &five, MaxAmount: &amount, WindowSeconds: &day}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.wantErr && err == nil {
+				t.Error("Expected an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultCurrency is the currency of amounts that do not name one.
+const DefaultCurrency = "USD"
+
+// SupportedCurrencies lists the ISO 4217 codes accounts can be held in.
+var SupportedCurrencies = []string{"EUR", "TRY", "USD"}
+
+// IsSupportedCurrency reports whether code is one of SupportedCurrencies.
+func IsSupportedCurrency(code string) bool {
+	for _, c := range SupportedCurrencies {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// minorDigits is the number of decimal places of the supported currencies,
+// matching the DECIMAL(12,2) columns the amounts are stored in. maxUnits is
+// the largest amount those columns hold, 9,999,999,999.99.
+const (
+	minorDigits = 2
+	minorScale  = 100
+	maxUnits    = 999999999999
+)
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrAmountPrecision = errors.New("amount has more than 2 decimal places")
+	ErrAmountRange     = errors.New("amount out of range")
+)
+
+// Money is an exact monetary amount stored as an integer number of minor
+// units (cents) together with its currency. On the wire and in the database
+// it is represented as a decimal string such as "12.34".
+type Money struct {
+	Units    int64
+	Currency string
+}
+
+// NewMoney returns an amount of units minor units in currency.
+func NewMoney(units int64, currency string) Money {
+	return Money{Units: units, Currency: currency}
+}
+
+// ParseMoney parses a decimal string such as "12.34" or "-0.5". More than
+// two decimal places is an error rather than being rounded away.
+func ParseMoney(s, currency string) (Money, error) {
+	units, err := parseUnits(s)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Units: units, Currency: currency}, nil
+}
+
+func parseUnits(s string) (int64, error) {
+	if s == "" {
+		return 0, ErrInvalidAmount
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && frac == "") {
+		return 0, ErrInvalidAmount
+	}
+
+	// Trailing zeros carry no value, so "1.500" is accepted as 1.50.
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > minorDigits {
+		return 0, ErrAmountPrecision
+	}
+	frac += strings.Repeat("0", minorDigits-len(frac))
+
+	var units int64
+	for _, c := range whole + frac {
+		if c < '0' || c > '9' {
+			return 0, ErrInvalidAmount
+		}
+		if units > (1<<63-1-int64(c-'0'))/10 {
+			return 0, ErrAmountRange
+		}
+		units = units*10 + int64(c-'0')
+	}
+
+	if units > maxUnits {
+		return 0, ErrAmountRange
+	}
+
+	if negative {
+		units = -units
+	}
+	return units, nil
+}
+
+// String formats the amount as a decimal string with two decimal places.
+func (m Money) String() string {
+	units := m.Units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, units/minorScale, units%minorScale)
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool { return m.Units == 0 }
+
+// IsPositive reports whether the amount is greater than zero.
+func (m Money) IsPositive() bool { return m.Units > 0 }
+
+// IsNegative reports whether the amount is less than zero.
+func (m Money) IsNegative() bool { return m.Units < 0 }
+
+// Neg returns the amount with its sign flipped.
+func (m Money) Neg() Money {
+	return Money{Units: -m.Units, Currency: m.Currency}
+}
+
+// Add returns m + o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) Money {
+	m.mustMatch(o)
+	return Money{Units: m.Units + o.Units, Currency: m.Currency}
+}
+
+// Sub returns m - o. Both amounts must be in the same currency.
+func (m Money) Sub(o Money) Money {
+	m.mustMatch(o)
+	return Money{Units: m.Units - o.Units, Currency: m.Currency}
+}
+
+// LessThan reports whether m < o. Both amounts must be in the same currency.
+func (m Money) LessThan(o Money) bool {
+	m.mustMatch(o)
+	return m.Units < o.Units
+}
+
+func (m Money) mustMatch(o Money) {
+	if m.Currency != "" && o.Currency != "" && m.Currency != o.Currency {
+		panic(fmt.Sprintf("models: mixing currencies %s and %s", m.Currency, o.Currency))
+	}
+}
+
+// MarshalJSON encodes the amount as a decimal string.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a bare JSON number. Numbers are
+// parsed from their literal text, so no float rounding takes place.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return ErrInvalidAmount
+		}
+	}
+
+	units, err := parseUnits(s)
+	if err != nil {
+		return err
+	}
+	m.Units = units
+	return nil
+}
+
+// Value implements driver.Valuer so amounts are written to DECIMAL columns
+// as exact decimal strings.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan implements sql.Scanner for DECIMAL columns. The currency is left
+// untouched; callers set it from the row the amount belongs to.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		m.Units = 0
+		return nil
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case int64:
+		m.Units = v * minorScale
+		return nil
+	default:
+		return fmt.Errorf("models: cannot scan %T into Money", src)
+	}
+}
+
+func (m *Money) scanString(s string) error {
+	units, err := parseUnits(s)
+	if err != nil {
+		return fmt.Errorf("models: scanning %q: %w", s, err)
+	}
+	m.Units = units
+	return nil
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		units   int64
+		wantErr error
+	}{
+		{name: "Whole", input: "12", units: 1200},
+		{name: "Two Decimals", input: "12.34", units: 1234},
+		{name: "One Decimal", input: "0.5", units: 50},
+		{name: "Trailing Zeros", input: "1.500", units: 150},
+		{name: "Negative", input: "-0.05", units: -5},
+		{name: "Too Precise", input: "1.005", wantErr: ErrAmountPrecision},
+		{name: "Empty", input: "", wantErr: ErrInvalidAmount},
+		{name: "Missing Fraction", input: "1.", wantErr: ErrInvalidAmount},
+		{name: "Not A Number", input: "1a", wantErr: ErrInvalidAmount},
+		{name: "Exponent", input: "1e2", wantErr: ErrInvalidAmount},
+		{name: "Largest", input: "9999999999.99", units: 999999999999},
+		{name: "Above Column Precision", input: "10000000000.00", wantErr: ErrAmountRange},
+		{name: "Overflow", input: "92233720368547758.08", wantErr: ErrAmountRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMoney(tt.input, "USD")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if m.Units != tt.units {
+				t.Errorf("Expected %d units, got %d", tt.units, m.Units)
+			}
+		})
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var req struct {
+		A Money `json:"a"`
+		B Money `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": 0.1, "b": "0.2"}`), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sum := req.A.Add(req.B)
+	if sum.Units != 30 {
+		t.Errorf("Expected 0.1 + 0.2 to be exactly 30 units, got %d", sum.Units)
+	}
+
+	out, err := json.Marshal(sum)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != `"0.30"` {
+		t.Errorf("Expected \"0.30\", got %s", out)
+	}
+
+	if err := json.Unmarshal([]byte(`{"a": "10.001"}`), &req); !errors.Is(err, ErrAmountPrecision) {
+		t.Errorf("Expected precision error, got %v", err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	m := Money{Currency: "EUR"}
+	if err := m.Scan([]byte("-42.10")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m.Units != -4210 || m.Currency != "EUR" {
+		t.Errorf("Expected -4210 EUR, got %d %s", m.Units, m.Currency)
+	}
+	if m.String() != "-42.10" {
+		t.Errorf("Expected -42.10, got %s", m.String())
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		units  int64
+		rate   string
+		expect int64
+	}{
+		{name: "Exact", units: 10000, rate: "1.25", expect: 12500},
+		{name: "Round Down", units: 100, rate: "1.0834", expect: 108},
+		{name: "Round Half Up", units: 50, rate: "1.01", expect: 51},
+		{name: "Negative", units: -50, rate: "1.01", expect: -51},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := ParseRate(tt.rate)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := NewMoney(tt.units, "EUR").Convert(rate, "USD")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got.Units != tt.expect || got.Currency != "USD" {
+				t.Errorf("Expected %d USD, got %d %s", tt.expect, got.Units, got.Currency)
+			}
+		})
+	}
+
+	for _, bad := range []string{"0", "-1.2", "1e3", "1/3", "1.00000000001", "abc",
+		"0x10", "0b11", "1_000", "+1", " 1", "1.", ".5", "10000000000"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+
+	for _, good := range []string{"9999999999.9999999999", "0001.5", "0.0000000001"} {
+		if _, err := ParseRate(good); err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", good, err)
+		}
+	}
+
+	rate, _ := ParseRate("9999999999")
+	if _, err := NewMoney(maxUnits, "EUR").Convert(rate, "USD"); err != ErrAmountRange {
+		t.Errorf("Expected ErrAmountRange for an overflowing conversion, got %v", err)
+	}
+}
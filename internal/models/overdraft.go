@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// SetOverdraftLimitRequest sets how far below zero one of a user's accounts
+// may go. A limit of zero turns the overdraft off.
+type SetOverdraftLimitRequest struct {
+	Limit    Money  `json:"limit"`
+	Currency string `json:"currency"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// OverdraftLimitChange is an entry in the audit trail of an account's
+// overdraft limit.
+type OverdraftLimitChange struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Currency  string    `json:"currency"`
+	OldLimit  Money     `json:"old_limit"`
+	NewLimit  Money     `json:"new_limit"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedBy *int64    `json:"changed_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,116 @@
+package models
+
+import "time"
+
+// Schedule frequencies. A monthly schedule runs on DayOfMonth, or on the
+// last day of months too short to have it.
+const (
+	FrequencyOnce    = "once"
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+// Schedule statuses. Only active schedules are run; a once schedule is
+// completed after its run.
+const (
+	ScheduleActive    = "active"
+	SchedulePaused    = "paused"
+	ScheduleCancelled = "cancelled"
+	ScheduleCompleted = "completed"
+)
+
+// Outcomes of a scheduled transfer run. A run above the approval threshold
+// is pending approval and made, if at all, when its approval is decided.
+const (
+	RunSucceeded           = "succeeded"
+	RunInsufficientBalance = "insufficient_balance"
+	RunFailed              = "failed"
+	RunPendingApproval     = "pending_approval"
+)
+
+// ScheduledTransfer is a transfer to be made later, once or repeatedly.
+type ScheduledTransfer struct {
+	ID         int64      `json:"id"`
+	FromUserID int64      `json:"from_user_id"`
+	ToUserID   int64      `json:"to_user_id"`
+	Amount     Money      `json:"amount"`
+	Currency   string     `json:"currency"`
+	ToCurrency string     `json:"to_currency"`
+	Frequency  string     `json:"frequency"`
+	DayOfMonth *int       `json:"day_of_month,omitempty"`
+	Status     string     `json:"status"`
+	NextRunAt  *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	CreatedBy  *int64     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// CreateScheduledTransferRequest schedules a transfer. The first run is at
+// StartAt, or for monthly schedules on the first DayOfMonth from StartAt on;
+// DayOfMonth is only used by monthly schedules and defaults to the day of
+// StartAt.
+type CreateScheduledTransferRequest struct {
+	FromUserID int64     `json:"from_user_id"`
+	ToUserID   int64     `json:"to_user_id"`
+	Amount     Money     `json:"amount"`
+	Currency   string    `json:"currency"`
+	ToCurrency string    `json:"to_currency,omitempty"`
+	Frequency  string    `json:"frequency"`
+	DayOfMonth int       `json:"day_of_month,omitempty"`
+	StartAt    time.Time `json:"start_at"`
+}
+
+// ScheduledTransferRun records the outcome of one run of a schedule.
+type ScheduledTransferRun struct {
+	ID            int64     `json:"id"`
+	ScheduleID    int64     `json:"schedule_id"`
+	ScheduledFor  time.Time `json:"scheduled_for"`
+	Status        string    `json:"status"`
+	TransactionID *int64    `json:"transaction_id,omitempty"`
+	ApprovalID    *int64    `json:"approval_id,omitempty"`
+	Message       string    `json:"message,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// NextRun returns the run of a schedule that follows the one at prev, or
+// the zero time for a schedule that runs only once.
+func NextRun(frequency string, dayOfMonth int, prev time.Time) time.Time {
+	switch frequency {
+	case FrequencyDaily:
+		return prev.AddDate(0, 0, 1)
+	case FrequencyWeekly:
+		return prev.AddDate(0, 0, 7)
+	case FrequencyMonthly:
+		// Step to the first of the next month before picking the day, so
+		// that e.g. the 31st does not overflow into the month after
+		y, m, _ := prev.Date()
+		return dayInMonth(time.Date(y, m+1, 1, prev.Hour(), prev.Minute(), prev.Second(), prev.Nanosecond(), prev.Location()), dayOfMonth)
+	}
+	return time.Time{}
+}
+
+// FirstRun returns the first run of a schedule starting at start. That is
+// start itself, except for monthly schedules, which first run on the first
+// DayOfMonth on or after it.
+func FirstRun(frequency string, dayOfMonth int, start time.Time) time.Time {
+	if frequency != FrequencyMonthly {
+		return start
+	}
+	y, m, _ := start.Date()
+	run := dayInMonth(time.Date(y, m, 1, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location()), dayOfMonth)
+	if run.Before(start) {
+		return NextRun(frequency, dayOfMonth, start)
+	}
+	return run
+}
+
+// dayInMonth returns day of the month starting at first, or the month's
+// last day if it is too short to have it.
+func dayInMonth(first time.Time, day int) time.Time {
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
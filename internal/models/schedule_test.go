@@ -0,0 +1,70 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		frequency  string
+		dayOfMonth int
+		prev       time.Time
+		expect     time.Time
+	}{
+		{name: "Once", frequency: FrequencyOnce, prev: at(2026, 1, 15), expect: time.Time{}},
+		{name: "Daily", frequency: FrequencyDaily, prev: at(2026, 2, 28), expect: at(2026, 3, 1)},
+		{name: "Weekly", frequency: FrequencyWeekly, prev: at(2026, 12, 29), expect: at(2027, 1, 5)},
+		{name: "Monthly", frequency: FrequencyMonthly, dayOfMonth: 15, prev: at(2026, 1, 15), expect: at(2026, 2, 15)},
+		{name: "Monthly Short Month", frequency: FrequencyMonthly, dayOfMonth: 31, prev: at(2026, 1, 31), expect: at(2026, 2, 28)},
+		{name: "Monthly After Short Month", frequency: FrequencyMonthly, dayOfMonth: 31, prev: at(2026, 2, 28), expect: at(2026, 3, 31)},
+		{name: "Monthly Leap Year", frequency: FrequencyMonthly, dayOfMonth: 30, prev: at(2028, 1, 30), expect: at(2028, 2, 29)},
+		{name: "Monthly Year End", frequency: FrequencyMonthly, dayOfMonth: 1, prev: at(2026, 12, 1), expect: at(2027, 1, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NextRun(tt.frequency, tt.dayOfMonth, tt.prev)
+			if !got.Equal(tt.expect) {
+				t.Errorf("Expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestFirstRun(t *testing.T) {
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		frequency  string
+		dayOfMonth int
+		start      time.Time
+		expect     time.Time
+	}{
+		{name: "Once", frequency: FrequencyOnce, start: at(2026, 1, 10), expect: at(2026, 1, 10)},
+		{name: "Weekly", frequency: FrequencyWeekly, start: at(2026, 1, 10), expect: at(2026, 1, 10)},
+		{name: "Monthly Later This Month", frequency: FrequencyMonthly, dayOfMonth: 15, start: at(2026, 1, 10), expect: at(2026, 1, 15)},
+		{name: "Monthly On Start", frequency: FrequencyMonthly, dayOfMonth: 10, start: at(2026, 1, 10), expect: at(2026, 1, 10)},
+		{name: "Monthly Next Month", frequency: FrequencyMonthly, dayOfMonth: 5, start: at(2026, 1, 10), expect: at(2026, 2, 5)},
+		{name: "Monthly Short Month", frequency: FrequencyMonthly, dayOfMonth: 31, start: at(2026, 2, 10), expect: at(2026, 2, 28)},
+		{name: "Monthly On Clamped Day", frequency: FrequencyMonthly, dayOfMonth: 30, start: at(2026, 2, 28), expect: at(2026, 2, 28)},
+		{name: "Monthly Year End", frequency: FrequencyMonthly, dayOfMonth: 1, start: at(2026, 12, 2), expect: at(2027, 1, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FirstRun(tt.frequency, tt.dayOfMonth, tt.start)
+			if !got.Equal(tt.expect) {
+				t.Errorf("Expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Statement lists a user's movements in one currency over the half-open
+// period [From, To). ClosingBalance is therefore the OpeningBalance of the
+// statement starting at To.
+type Statement struct {
+	UserID         int64           `json:"user_id"`
+	Name           string          `json:"name"`
+	Currency       string          `json:"currency"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance Money           `json:"opening_balance"`
+	ClosingBalance Money           `json:"closing_balance"`
+	Lines          []StatementLine `json:"lines"`
+}
+
+// StatementLine is one posting to the user's account. Amount is signed:
+// positive amounts are money in, negative amounts money out.
+type StatementLine struct {
+	TransactionID *int64    `json:"transaction_id,omitempty"`
+	Type          string    `json:"type,omitempty"`
+	Description   string    `json:"description"`
+	Amount        Money     `json:"amount"`
+	BalanceAfter  Money     `json:"balance_after"`
+	CreatedAt     time.Time `json:"created_at"`
+}
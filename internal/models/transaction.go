@@ -4,10 +4,77 @@ import (
 	"time"
 )
 
+// Transaction is one row of a user's transaction history. UserID is the
+// user whose history the row was read from; FromUserID and ToUserID are the
+// parties of the transaction itself.
 type Transaction struct {
-	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`
-	Amount      float64   `json:"amount"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                    int64   `json:"id"`
+	UserID                int64   `json:"user_id"`
+	FromUserID            *int64  `json:"from_user_id"`
+	ToUserID              int64   `json:"to_user_id"`
+	Type                  string  `json:"type"`
+	Amount                Money   `json:"amount"`
+	Currency              string  `json:"currency"`
+	TargetAmount          *Money  `json:"target_amount,omitempty"`
+	TargetCurrency        string  `json:"target_currency,omitempty"`
+	FXRate                *string `json:"fx_rate,omitempty"`
+	OriginalTransactionID *int64  `json:"original_transaction_id,omitempty"`
+	BatchID               *int64  `json:"batch_id,omitempty"`
+	Description           string  `json:"description"`
+	// BalanceAfter is UserID's balance right after the transaction, in the
+	// currency of the account it touched.
+	BalanceAfter *Money    `json:"balance_after,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TransactionPage is one page of a transaction history. NextCursor is empty
+// on the last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
+
+// Transaction types.
+const (
+	TransactionCredit     = "credit"
+	TransactionTransfer   = "transfer"
+	TransactionWithdrawal = "withdrawal"
+	TransactionCapture    = "capture"
+	TransactionReversal   = "reversal"
+	TransactionRefund     = "refund"
+)
+
+// TransactionTypes lists every transaction type in the order they were added.
+var TransactionTypes = []string{
+	TransactionCredit,
+	TransactionTransfer,
+	TransactionWithdrawal,
+	TransactionCapture,
+	TransactionReversal,
+	TransactionRefund,
+}
+
+type ReverseRequest struct {
+	Reason string `json:"reason"`
+}
+
+type RefundRequest struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// CompensationResult describes a reversal or refund. Amount is in the
+// original transaction's source currency and TargetAmount in the currency
+// its recipient was credited in; Refundable is what is left to refund.
+// ReturnedFee is the fee given back to the payer, which only reversals do.
+type CompensationResult struct {
+	TransactionID         int64  `json:"transaction_id"`
+	OriginalTransactionID int64  `json:"original_transaction_id"`
+	Type                  string `json:"type"`
+	Amount                Money  `json:"amount"`
+	Currency              string `json:"currency"`
+	TargetAmount          Money  `json:"target_amount"`
+	TargetCurrency        string `json:"target_currency"`
+	Refundable            Money  `json:"refundable"`
+	ReturnedFee           Money  `json:"returned_fee"`
 }
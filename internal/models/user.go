@@ -1,11 +1,11 @@
 package models
 
 type User struct {
-	ID      int64   `json:"id"`
-	Name    string  `json:"name"`
-	Email   string  `json:"email"`
-	Role    string  `json:"role"`
-	Balance float64 `json:"balance"`
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	Balance Money  `json:"balance"`
 }
 
 type CreateUserRequest struct {
@@ -16,21 +16,75 @@ type CreateUserRequest struct {
 }
 
 type UserBalance struct {
-	UserID  int64   `json:"user_id"`
-	Name    string  `json:"name"`
-	Balance float64 `json:"balance"`
+	UserID   int64             `json:"user_id"`
+	Name     string            `json:"name"`
+	Balances []CurrencyBalance `json:"balances"`
+}
+
+// CurrencyBalance is the balance of one of a user's currency accounts.
+// Posted is the sum of the account's postings; Available is what can still
+// be spent once pending holds are taken off, and may be negative down to
+// the account's overdraft limit. OverdraftAvailable is the part of the
+// limit not yet used. Balance repeats Posted for clients written before
+// holds, when it was the only balance.
+type CurrencyBalance struct {
+	Currency           string `json:"currency"`
+	Balance            Money  `json:"balance"`
+	Posted             Money  `json:"posted"`
+	Available          Money  `json:"available"`
+	OverdraftLimit     Money  `json:"overdraft_limit"`
+	OverdraftAvailable Money  `json:"overdraft_available"`
+}
+
+// OpenAccountRequest opens an account in Currency. Type is checking unless
+// given.
+type OpenAccountRequest struct {
+	Currency string `json:"currency"`
+	Type     string `json:"type,omitempty"`
 }
 
 type AddCreditRequest struct {
-	Amount float64 `json:"amount"`
+	Amount   Money  `json:"amount"`
+	Currency string `json:"currency"`
 }
 
 type TransferRequest struct {
-	FromUserID int64   `json:"from_user_id"`
-	ToUserID   int64   `json:"to_user_id"`
-	Amount     float64 `json:"amount"`
+	FromUserID int64  `json:"from_user_id"`
+	ToUserID   int64  `json:"to_user_id"`
+	Amount     Money  `json:"amount"`
+	Currency   string `json:"currency"`
+	// ToCurrency is the currency the recipient is credited in. It defaults
+	// to Currency; when it differs the amount is converted at the latest
+	// FX rate.
+	ToCurrency string `json:"to_currency,omitempty"`
+}
+
+// TransferResult describes a completed transfer, including the conversion
+// applied when the two sides are in different currencies and the fees the
+// sender paid on top of Amount.
+type TransferResult struct {
+	Message        string      `json:"message"`
+	TransactionID  int64       `json:"transaction_id"`
+	Amount         Money       `json:"amount"`
+	Currency       string      `json:"currency"`
+	TargetAmount   Money       `json:"target_amount"`
+	TargetCurrency string      `json:"target_currency"`
+	FXRate         string      `json:"fx_rate,omitempty"`
+	Fees           []FeeCharge `json:"fees"`
+	TotalFee       Money       `json:"total_fee"`
 }
 
 type WithdrawRequest struct {
-	Amount float64 `json:"amount"`
+	Amount   Money  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// WithdrawResult describes a completed withdrawal and the fees paid on top
+// of Amount.
+type WithdrawResult struct {
+	TransactionID int64       `json:"transaction_id"`
+	Amount        Money       `json:"amount"`
+	Currency      string      `json:"currency"`
+	Fees          []FeeCharge `json:"fees"`
+	TotalFee      Money       `json:"total_fee"`
 }
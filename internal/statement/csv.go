@@ -0,0 +1,42 @@
+// Package statement renders account statements as CSV and PDF documents.
+package statement
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"ledger/internal/models"
+)
+
+var csvHeader = []string{"date", "transaction_id", "type", "description", "amount", "balance"}
+
+// WriteCSV writes st as CSV: one row per movement between an opening and a
+// closing balance row.
+func WriteCSV(w io.Writer, st models.Statement) error {
+	cw := csv.NewWriter(w)
+
+	records := [][]string{
+		csvHeader,
+		{st.From.Format(time.RFC3339), "", "opening_balance", "Opening balance", "", st.OpeningBalance.String()},
+	}
+	for _, line := range st.Lines {
+		var transactionID string
+		if line.TransactionID != nil {
+			transactionID = strconv.FormatInt(*line.TransactionID, 10)
+		}
+		records = append(records, []string{
+			line.CreatedAt.Format(time.RFC3339),
+			transactionID,
+			line.Type,
+			line.Description,
+			line.Amount.String(),
+			line.BalanceAfter.String(),
+		})
+	}
+	records = append(records,
+		[]string{st.To.Format(time.RFC3339), "", "closing_balance", "Closing balance", "", st.ClosingBalance.String()})
+
+	return cw.WriteAll(records)
+}
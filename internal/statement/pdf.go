@@ -0,0 +1,124 @@
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"ledger/internal/models"
+)
+
+// Page layout of PDF statements: US Letter in points, set in 9pt Courier so
+// that the columns line up.
+const (
+	pageWidth    = 612
+	pageHeight   = 792
+	margin       = 40
+	fontSize     = 9
+	leading      = 12
+	linesPerPage = (pageHeight - 2*margin) / leading
+)
+
+// WritePDF writes st as a PDF document listing the opening balance, every
+// movement and the closing balance.
+func WritePDF(w io.Writer, st models.Statement) error {
+	text := []string{
+		fmt.Sprintf("Statement for %s (user %d)", st.Name, st.UserID),
+		fmt.Sprintf("Period %s to %s, %s", st.From.Format("2006-01-02 15:04"), st.To.Format("2006-01-02 15:04"), st.Currency),
+		"",
+		fmt.Sprintf("%-16s  %-10s  %-36s  %14s  %14s", "Date", "Type", "Description", "Amount", "Balance"),
+		fmt.Sprintf("%-16s  %-10s  %-36s  %14s  %14s", "", "", "Opening balance", "", st.OpeningBalance.String()),
+	}
+	for _, line := range st.Lines {
+		text = append(text, fmt.Sprintf("%-16s  %-10s  %-36s  %14s  %14s",
+			line.CreatedAt.Format("2006-01-02 15:04"),
+			truncate(line.Type, 10),
+			truncate(line.Description, 36),
+			line.Amount.String(),
+			line.BalanceAfter.String()))
+	}
+	text = append(text, fmt.Sprintf("%-16s  %-10s  %-36s  %14s  %14s", "", "", "Closing balance", "", st.ClosingBalance.String()))
+
+	var pages [][]string
+	for len(text) > linesPerPage {
+		pages = append(pages, text[:linesPerPage])
+		text = text[linesPerPage:]
+	}
+	pages = append(pages, text)
+
+	return writePDF(w, pages)
+}
+
+// writePDF writes a minimal PDF 1.4 document with one page of text per
+// element of pages. Objects are numbered as follows: 1 is the catalog, 2
+// the page tree, 3 the font, and each page n (from 0) is object 4+2n with
+// its content stream in 5+2n.
+func writePDF(w io.Writer, pages [][]string) error {
+	var (
+		buf     bytes.Buffer
+		offsets []int
+	)
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	for i, lines := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, leading, margin, pageHeight-margin-fontSize)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) '\n", escapePDFText(line))
+		}
+		content.WriteString("ET")
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 5+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// escapePDFText makes s safe inside a PDF string literal. Characters outside
+// printable ASCII are replaced, as the standard fonts cannot show them.
+func escapePDFText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "~"
+	}
+	return s
+}
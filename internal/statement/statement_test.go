@@ -0,0 +1,102 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ledger/internal/models"
+)
+
+func testStatement(lines int) models.Statement {
+	id := int64(7)
+	from := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	st := models.Statement{
+		UserID:         1,
+		Name:           "Ada (test)",
+		Currency:       "USD",
+		From:           from,
+		To:             from.AddDate(0, 1, 0),
+		OpeningBalance: models.NewMoney(10000, "USD"),
+	}
+	balance := st.OpeningBalance
+	for i := 0; i < lines; i++ {
+		amount := models.NewMoney(-250, "USD")
+		balance = balance.Add(amount)
+		st.Lines = append(st.Lines, models.StatementLine{
+			TransactionID: &id,
+			Type:          models.TransactionWithdrawal,
+			Description:   "Withdrawal, café",
+			Amount:        amount,
+			BalanceAfter:  balance,
+			CreatedAt:     from.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	st.ClosingBalance = balance
+	return st
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, testStatement(2)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("Expected 5 records, got %d", len(records))
+	}
+	if got := records[1][5]; got != "100.00" {
+		t.Errorf("Expected opening balance 100.00, got %s", got)
+	}
+	if got := records[2][3]; got != "Withdrawal, café" {
+		t.Errorf("Expected description to survive quoting, got %q", got)
+	}
+	if got := records[4][5]; got != "95.00" {
+		t.Errorf("Expected closing balance 95.00, got %s", got)
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, testStatement(2*linesPerPage)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pdf := buf.Bytes()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("Expected a PDF header and trailer")
+	}
+	if !bytes.Contains(pdf, []byte("/Count 3")) {
+		t.Error("Expected the statement to span 3 pages")
+	}
+	if !bytes.Contains(pdf, []byte(`Ada \(test\)`)) {
+		t.Error("Expected parentheses in text to be escaped")
+	}
+
+	// Every xref entry must point at the start of its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("Expected startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := strings.Split(string(pdf[xref:]), "\n")[3:]
+	for i, entry := range entries {
+		if strings.HasPrefix(entry, "trailer") {
+			break
+		}
+		off, _ := strconv.Atoi(entry[:10])
+		want := fmt.Sprintf("%d 0 obj", i+1)
+		if !bytes.HasPrefix(pdf[off:], []byte(want)) {
+			t.Errorf("xref entry %d does not point at %q", i+1, want)
+		}
+	}
+}
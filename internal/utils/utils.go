@@ -9,7 +9,12 @@ import (
 )
 
 func GetUserIDFromPath(r *http.Request) (int64, error) {
-	id := chi.URLParam(r, "id")
+	return GetIDFromPath(r, "id")
+}
+
+// GetIDFromPath parses the numeric URL parameter named param.
+func GetIDFromPath(r *http.Request, param string) (int64, error) {
+	id := chi.URLParam(r, param)
 	return strconv.ParseInt(id, 10, 64)
 }
 
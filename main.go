@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"ledger/internal/api"
+	"ledger/internal/auth"
 	"ledger/internal/db"
+	"ledger/internal/jobs"
 
 	"github.com/joho/godotenv"
 )
@@ -21,7 +24,22 @@ func main() {
 	}
 	defer database.Close()
 
-	server := api.NewServer(database)
+	tokens, err := auth.LoadTokenService()
+	if err != nil {
+		log.Fatal("Error loading signing keys:", err)
+	}
+
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	server := api.NewServer(database, logger, tokens)
+
+	serverJobs, err := server.Jobs()
+	if err != nil {
+		log.Fatal("Error configuring jobs:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.NewRunner(logger, serverJobs...).Run(ctx)
+
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
 		port = "8080"
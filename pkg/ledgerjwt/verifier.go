@@ -0,0 +1,218 @@
+package ledgerjwt
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrAlgMismatch  = errors.New("token algorithm does not match its key")
+	ErrFetchingKeys = errors.New("fetching signing keys")
+)
+
+const (
+	// defaultMaxAge is how long fetched keys are used before they are
+	// fetched again.
+	defaultMaxAge = time.Hour
+
+	// defaultMinRefresh is how soon after a fetch a token with an unknown
+	// kid may make the verifier fetch again, so that such tokens cannot be
+	// used to flood the key endpoint.
+	defaultMinRefresh = time.Minute
+)
+
+// Verifier verifies tokens against the keys published at a JWKS URL. Keys
+// are cached, and fetched again when they get old or a token names a key
+// that is not known yet, which is how new keys are picked up after the
+// issuer rotates. It is safe for concurrent use.
+type Verifier struct {
+	url        string
+	issuer     string
+	client     *http.Client
+	maxAge     time.Duration
+	minRefresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]verifyKey
+	fetchedAt time.Time
+	fetchErr  error
+	// refreshing is closed when the fetch under way ends, and nil when
+	// there is none
+	refreshing chan struct{}
+}
+
+type verifyKey struct {
+	alg string
+	pub crypto.PublicKey
+}
+
+// Option configures a Verifier.
+type Option func(*Verifier)
+
+// WithIssuer makes the verifier require tokens to carry iss as their issuer.
+func WithIssuer(iss string) Option {
+	return func(v *Verifier) { v.issuer = iss }
+}
+
+// WithHTTPClient sets the client keys are fetched with.
+func WithHTTPClient(c *http.Client) Option {
+	return func(v *Verifier) { v.client = c }
+}
+
+// WithRefresh sets how long fetched keys are used for, and how soon after
+// a fetch an unknown kid may cause another.
+func WithRefresh(maxAge, minInterval time.Duration) Option {
+	return func(v *Verifier) {
+		v.maxAge = maxAge
+		v.minRefresh = minInterval
+	}
+}
+
+// NewVerifier returns a verifier for tokens signed with the keys published
+// at jwksURL. Keys are fetched on first use.
+func NewVerifier(jwksURL string, opts ...Option) *Verifier {
+	v := &Verifier{
+		url:        jwksURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		maxAge:     defaultMaxAge,
+		minRefresh: defaultMinRefresh,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify checks the token's signature, expiry and, if one is configured,
+// issuer, and returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(token, claims, v.Keyfunc, opts...); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Keyfunc returns the key a token's kid header names, for use with the
+// jwt package's parsers. It only checks that the key's algorithm matches
+// the token's; the caller's parser checks the rest.
+func (v *Verifier) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownKey
+	}
+
+	k, err := v.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if t.Method.Alg() != k.alg {
+		return nil, ErrAlgMismatch
+	}
+	return k.pub, nil
+}
+
+// key returns the key named kid, fetching the keys first if they are old
+// or do not have it. Only one fetch runs at a time: meanwhile, keys already
+// cached are served as they are, and callers after a kid not cached wait
+// for the fetch to see whether it brings it.
+func (v *Verifier) key(kid string) (verifyKey, error) {
+	v.mu.Lock()
+	k, ok := v.keys[kid]
+	age := time.Since(v.fetchedAt)
+	done := v.refreshing
+	switch {
+	case ok && (age < v.maxAge || done != nil):
+		v.mu.Unlock()
+		return k, nil
+	case done != nil:
+		v.mu.Unlock()
+		<-done
+	case !ok && !v.fetchedAt.IsZero() && age < v.minRefresh:
+		err := v.fetchErr
+		v.mu.Unlock()
+		if err != nil {
+			return verifyKey{}, err
+		}
+		return verifyKey{}, ErrUnknownKey
+	default:
+		done = make(chan struct{})
+		v.refreshing = done
+		v.fetchedAt = time.Now()
+		v.mu.Unlock()
+		v.refresh(done)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	if v.fetchErr != nil {
+		return verifyKey{}, v.fetchErr
+	}
+	return verifyKey{}, ErrUnknownKey
+}
+
+// refresh fetches the keys without holding v.mu and then replaces the
+// cached ones, waking the callers waiting on done. If the fetch fails the
+// cached keys are kept, to keep going on them until the endpoint is back.
+func (v *Verifier) refresh(done chan struct{}) {
+	keys, err := v.fetch()
+
+	v.mu.Lock()
+	v.fetchErr = err
+	if err == nil {
+		v.keys = keys
+	}
+	v.refreshing = nil
+	v.mu.Unlock()
+	close(done)
+}
+
+// fetch returns the keys currently published. Keys of a kind the verifier
+// does not support are skipped.
+func (v *Verifier) fetch() (map[string]verifyKey, error) {
+	resp, err := v.client.Get(v.url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchingKeys, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s returned %s", ErrFetchingKeys, v.url, resp.Status)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchingKeys, err)
+	}
+
+	keys := make(map[string]verifyKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kid == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = verifyKey{alg: jwk.Alg, pub: pub}
+	}
+	return keys, nil
+}
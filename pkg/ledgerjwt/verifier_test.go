@@ -0,0 +1,280 @@
+package ledgerjwt_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ledger/internal/auth"
+	"ledger/pkg/ledgerjwt"
+)
+
+// issuer serves the JWKS of a token service whose keys can be swapped, and
+// counts how often it is asked for them. While hold is set, requests wait
+// for it to be closed before they are answered.
+type issuer struct {
+	t       *testing.T
+	mu      sync.Mutex
+	tokens  *auth.TokenService
+	fetches int32
+	down    bool
+	hold    chan struct{}
+	server  *httptest.Server
+}
+
+func newIssuer(t *testing.T, active string, keys ...auth.Key) *issuer {
+	i := &issuer{t: t}
+	i.rotate(active, keys...)
+	i.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&i.fetches, 1)
+		i.mu.Lock()
+		hold := i.hold
+		i.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		if i.down {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(i.tokens.JWKS())
+	}))
+	t.Cleanup(i.server.Close)
+	return i
+}
+
+func (i *issuer) rotate(active string, keys ...auth.Key) {
+	tokens, err := auth.NewTokenService("ledger", active, time.Hour, keys...)
+	if err != nil {
+		i.t.Fatalf("NewTokenService: %v", err)
+	}
+	i.mu.Lock()
+	i.tokens = tokens
+	i.mu.Unlock()
+}
+
+func (i *issuer) issue() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	token, _, err := i.tokens.Issue(7, "user")
+	if err != nil {
+		i.t.Fatalf("Issue: %v", err)
+	}
+	return token
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pub := range []interface {
+		Equal(x crypto.PublicKey) bool
+	}{&rsaKey.PublicKey, edPub} {
+		jwk, err := ledgerjwt.NewJWK("k", pub)
+		if err != nil {
+			t.Fatalf("NewJWK(%T): %v", pub, err)
+		}
+		got, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("PublicKey(%T): %v", pub, err)
+		}
+		if !pub.Equal(got) {
+			t.Errorf("%T did not survive the round trip", pub)
+		}
+	}
+
+	if _, err := ledgerjwt.NewJWK("k", []byte("secret")); !errors.Is(err, ledgerjwt.ErrUnsupportedKey) {
+		t.Errorf("NewJWK(secret): err = %v, want ErrUnsupportedKey", err)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := auth.RSAKey("rs", rsaKey, nil)
+	ed := auth.Ed25519Key("ed", edKey, nil)
+	hs := auth.HMACKey("hs", []byte("secret"))
+
+	iss := newIssuer(t, "rs", rs, ed, hs)
+	v := ledgerjwt.NewVerifier(iss.server.URL, ledgerjwt.WithIssuer("ledger"))
+
+	claims, err := v.Verify(iss.issue())
+	if err != nil {
+		t.Fatalf("RS256 token: %v", err)
+	}
+	if claims.UserID != 7 || claims.Role != "user" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// Shared secrets are not published, so HMAC tokens cannot be verified
+	iss.rotate("hs", rs, ed, hs)
+	if _, err := v.Verify(iss.issue()); err == nil {
+		t.Error("HS256 token verified")
+	}
+
+	// A key added after the keys were fetched is picked up
+	iss.rotate("ed", rs, ed)
+	v = ledgerjwt.NewVerifier(iss.server.URL, ledgerjwt.WithRefresh(time.Hour, 0))
+	iss.rotate("rs", rs)
+	if _, err := v.Verify(iss.issue()); err != nil {
+		t.Fatalf("RS256 token: %v", err)
+	}
+	iss.rotate("ed", rs, ed)
+	if _, err := v.Verify(iss.issue()); err != nil {
+		t.Errorf("token signed with a newly published key: %v", err)
+	}
+
+	// The wrong issuer is turned away
+	v = ledgerjwt.NewVerifier(iss.server.URL, ledgerjwt.WithIssuer("someone-else"))
+	if _, err := v.Verify(iss.issue()); err == nil {
+		t.Error("token from another issuer verified")
+	}
+}
+
+func TestVerifierRefresh(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := newIssuer(t, "ed", auth.Ed25519Key("ed", edKey, nil))
+	v := ledgerjwt.NewVerifier(iss.server.URL)
+
+	token := iss.issue()
+	for n := 0; n < 3; n++ {
+		if _, err := v.Verify(token); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&iss.fetches); n != 1 {
+		t.Errorf("fetched keys %d times, want 1", n)
+	}
+
+	// Unknown kids do not make the verifier fetch again straight away
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss.rotate("other", auth.Ed25519Key("other", other, nil))
+	for n := 0; n < 3; n++ {
+		if _, err := v.Verify(iss.issue()); err == nil {
+			t.Error("token signed with an unknown key verified")
+		}
+	}
+	if n := atomic.LoadInt32(&iss.fetches); n != 1 {
+		t.Errorf("fetched keys %d times, want 1", n)
+	}
+
+	// Known keys keep working while the endpoint is down
+	iss.rotate("ed", auth.Ed25519Key("ed", edKey, nil))
+	token = iss.issue()
+	v = ledgerjwt.NewVerifier(iss.server.URL, ledgerjwt.WithRefresh(0, 0))
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	iss.mu.Lock()
+	iss.down = true
+	iss.mu.Unlock()
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("cached key rejected while the endpoint is down: %v", err)
+	}
+}
+
+func TestVerifierConcurrentRefresh(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := auth.Ed25519Key("ed", edKey, nil)
+	other := auth.Ed25519Key("other", otherKey, nil)
+
+	iss := newIssuer(t, "ed", ed)
+	v := ledgerjwt.NewVerifier(iss.server.URL, ledgerjwt.WithRefresh(time.Hour, 0))
+	known := iss.issue()
+	if _, err := v.Verify(known); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// Hold the next fetch, which a token signed with a new key sets off
+	hold := make(chan struct{})
+	iss.mu.Lock()
+	iss.hold = hold
+	iss.mu.Unlock()
+	iss.rotate("other", ed, other)
+	unknown := iss.issue()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	verify := func() {
+		defer wg.Done()
+		_, err := v.Verify(unknown)
+		errs <- err
+	}
+	wg.Add(1)
+	go verify()
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&iss.fetches) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("the verifier did not fetch the keys for an unknown kid")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Cached keys are served while the fetch is under way
+	done := make(chan error)
+	go func() {
+		_, err := v.Verify(known)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Verify with a cached key: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Verify with a cached key waited for the fetch")
+	}
+
+	// Other callers after the new key share the fetch under way
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go verify()
+	}
+	close(hold)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Verify with a newly published key: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&iss.fetches); n != 2 {
+		t.Errorf("fetched keys %d times, want 2", n)
+	}
+}